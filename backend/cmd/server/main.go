@@ -3,15 +3,20 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
+	"chatapp/internal/broker"
 	"chatapp/internal/database"
 	"chatapp/internal/handler"
 	"chatapp/internal/middleware"
+	"chatapp/internal/ratelimit"
 	"chatapp/internal/repo"
 	"chatapp/internal/service"
 	ws "chatapp/internal/websocket"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 func main() {
@@ -21,11 +26,29 @@ func main() {
 	}
 	defer database.Close()
 
-	// Redis接続
-	if err := database.ConnectRedis(); err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+	// メッセージブローカーの種類（redis, redis-streams, nats, memory）
+	brokerKind := getEnv("MESSAGE_BROKER", broker.KindRedis)
+
+	// Redis接続（memoryブローカーでは不要。natsブローカーではREDIS_HOSTが
+	// 設定されている場合のみ接続し、プレゼンスをインスタンス間で共有する）
+	useRedis := brokerKind != broker.KindMemory
+	if brokerKind == broker.KindNATS {
+		useRedis = os.Getenv("REDIS_HOST") != ""
+	}
+	if useRedis {
+		if err := database.ConnectRedis(); err != nil {
+			log.Fatal("Failed to connect to Redis:", err)
+		}
+		defer database.CloseRedis()
+	}
+
+	// NATS接続
+	if brokerKind == broker.KindNATS {
+		if err := database.ConnectNATS(); err != nil {
+			log.Fatal("Failed to connect to NATS:", err)
+		}
+		defer database.CloseNATS()
 	}
-	defer database.CloseRedis()
 
 	// 環境変数からポートを取得（デフォルト: 8080）
 	port := os.Getenv("PORT")
@@ -36,10 +59,17 @@ func main() {
 	// Ginルーターを初期化
 	r := gin.Default()
 
+	// X-Forwarded-Forは信頼するプロキシからのものだけを使う（ログイン失敗の
+	// IP単位のロックアウトを偽装したヘッダーで回避されないように）。未設定なら
+	// 接続元アドレスをそのまま使う
+	if err := r.SetTrustedProxies(trustedProxies(os.Getenv("TRUSTED_PROXIES"))); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// CORS設定（開発用）
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
 
 		if c.Request.Method == "OPTIONS" {
@@ -53,22 +83,102 @@ func main() {
 	// リポジトリ層の初期化
 	userRepo := repo.NewUserRepository()
 	messageRepo := repo.NewMessageRepository()
+	channelRepo := repo.NewChannelRepository()
+	conversationRepo := repo.NewConversationRepository()
+	reactionRepo := repo.NewReactionRepository()
+	statusRepo := repo.NewUserStatusRepository()
+	loginAttemptRepo := repo.NewLoginAttemptRepository()
+	refreshTokenRepo := repo.NewRefreshTokenRepository()
+	sessionRepo := repo.NewSessionRepository()
+	signingKeyRepo := repo.NewSigningKeyRepository()
+
+	// ログイン失敗回数の記録（Redisがあればインスタンス間で共有）
+	var loginAttempts ratelimit.AttemptTracker = ratelimit.NewMemoryAttempts()
+	if database.RedisClient != nil {
+		loginAttempts = ratelimit.NewRedisAttempts(database.RedisClient)
+	}
+
+	// アクセストークンの署名設定（本番環境では既定のシークレットを拒否する）
+	keyConfig, err := service.LoadKeyConfig()
+	if err != nil {
+		log.Fatal("Invalid JWT configuration:", err)
+	}
+	production := os.Getenv("APP_ENV") == "production" || gin.Mode() == gin.ReleaseMode
+	if production {
+		if err := keyConfig.CheckProduction(); err != nil {
+			log.Fatal("Refusing to start in production: ", err)
+		}
+	}
+	keyService, err := service.NewKeyService(signingKeyRepo, keyConfig)
+	if err != nil {
+		log.Fatal("Failed to load signing keys:", err)
+	}
+	go keyService.Run() // 署名鍵を定期的にローテーション
+	log.Printf("JWT signing algorithm: %s", keyConfig.Algorithm)
 
 	// サービス層の初期化
-	jwtSecret := getEnv("JWT_SECRET", "your-secret-key-here")
-	authService := service.NewAuthService(userRepo, jwtSecret)
-	messageService := service.NewMessageService(messageRepo, userRepo)
+	authService := service.NewAuthService(userRepo, loginAttemptRepo, refreshTokenRepo, sessionRepo, loginAttempts, keyService)
+	messageService := service.NewMessageService(messageRepo, userRepo, channelRepo, conversationRepo, reactionRepo)
+	channelService := service.NewChannelService(channelRepo, userRepo)
+	conversationService := service.NewConversationService(conversationRepo, messageRepo, userRepo)
+	statusService := service.NewStatusService(statusRepo, userRepo)
+
+	// WebSocketハブの初期化（インスタンスIDはプレゼンス管理でレプリカを識別する）
+	instanceID := getEnv("INSTANCE_ID", uuid.New().String())
+
+	// インスタンス間のメッセージブローカー
+	var messageBroker broker.Broker
+	switch brokerKind {
+	case broker.KindMemory:
+		messageBroker = broker.NewMemory()
+	case broker.KindRedisStreams:
+		maxLen := int64(broker.DefaultStreamMaxLen)
+		if value, err := strconv.ParseInt(os.Getenv("REDIS_STREAM_MAXLEN"), 10, 64); err == nil {
+			maxLen = value
+		}
+		messageBroker = broker.NewRedisStreams(database.RedisClient, database.RedisSubscriber, maxLen)
+	case broker.KindNATS:
+		messageBroker = broker.NewNATS(database.NATSConn)
+	case broker.KindRedis:
+		messageBroker = broker.NewRedisPubSub(database.RedisClient, database.RedisSubscriber)
+	default:
+		log.Fatalf("Unknown MESSAGE_BROKER: %s", brokerKind)
+	}
+	defer messageBroker.Close()
+	log.Printf("Message broker: %s", brokerKind)
+
+	// チャットメッセージの投稿レート制限（Redisがあればインスタンス間で共有）
+	messageLimit, channelLimits, err := ratelimit.LoadMessageLimits()
+	if err != nil {
+		log.Fatal("Invalid message rate limit:", err)
+	}
+	var limiter ratelimit.Limiter = ratelimit.NewMemory()
+	if database.RedisClient != nil {
+		limiter = ratelimit.NewRedis(database.RedisClient)
+	}
+	messageLimiter := ratelimit.NewMessageLimiter(limiter, messageLimit, channelLimits)
 
-	// WebSocketハブの初期化
-	hub := ws.NewHub(database.RedisClient, database.RedisSubscriber, messageService)
+	hub := ws.NewHub(messageBroker, database.RedisClient, messageLimiter, messageService, conversationService, statusService, instanceID)
 	go hub.Run() // バックグラウンドでハブを実行
 
+	// 失効したセッションのWebSocket接続を全インスタンスで切断
+	authService.OnSessionRevoked(func(userID uint, sessionID string) {
+		if err := hub.DisconnectSession(userID, sessionID); err != nil {
+			log.Printf("❌ Failed to disconnect revoked session %s: %v", sessionID, err)
+		}
+	})
+
 	// ミドルウェアの初期化
 	authMiddleware := middleware.NewAuthMiddleware(authService)
 
 	// ハンドラーの初期化
 	authHandler := handler.NewAuthHandler(authService)
-	messageHandler := handler.NewMessageHandler(messageService)
+	jwksHandler := handler.NewJWKSHandler(keyService)
+	messageHandler := handler.NewMessageHandler(messageService, messageLimiter, hub)
+	channelHandler := handler.NewChannelHandler(channelService, hub)
+	dmHandler := handler.NewDirectMessageHandler(conversationService, messageLimiter, hub)
+	conversationHandler := handler.NewConversationHandler(conversationService, messageLimiter, hub)
+	statusHandler := handler.NewStatusHandler(statusService, hub)
 	wsHandler := handler.NewWebSocketHandler(hub, authService)
 
 	// ヘルスチェックエンドポイント
@@ -79,6 +189,9 @@ func main() {
 		})
 	})
 
+	// アクセストークン検証用の公開鍵（他サービス向け）
+	r.GET("/.well-known/jwks.json", jwksHandler.GetJWKS)
+
 	// APIルートグループ
 	api := r.Group("/api")
 	{
@@ -119,7 +232,10 @@ func main() {
 			auth.POST("/signup", authHandler.Signup)
 			auth.POST("/login", authHandler.Login)
 			auth.GET("/me", authMiddleware.RequireAuth(), authHandler.GetMe)
-			auth.POST("/refresh", authMiddleware.RequireAuth(), authHandler.RefreshToken)
+			auth.POST("/refresh", authHandler.RefreshToken)
+			auth.POST("/logout", authHandler.Logout)
+			auth.GET("/sessions", authMiddleware.RequireAuth(), authHandler.GetSessions)
+			auth.DELETE("/sessions/:id", authMiddleware.RequireAuth(), authHandler.RevokeSession)
 		}
 
 		// メッセージエンドポイント
@@ -127,11 +243,16 @@ func main() {
 		{
 			// 認証が必要なエンドポイント
 			messages.POST("", authMiddleware.RequireAuth(), messageHandler.CreateMessage)
+			messages.PATCH("/:id", authMiddleware.RequireAuth(), messageHandler.EditMessage)
 			messages.DELETE("/:id", authMiddleware.RequireAuth(), messageHandler.DeleteMessage)
+			messages.GET("/:id/revisions", authMiddleware.RequireAuth(), messageHandler.GetMessageRevisions)
+			messages.POST("/:id/reactions", authMiddleware.RequireAuth(), messageHandler.AddReaction)
+			messages.DELETE("/:id/reactions/:emoji", authMiddleware.RequireAuth(), messageHandler.RemoveReaction)
 
-			// 認証がオプショナルなエンドポイント（将来的にプライベートチャンネル対応時に認証必須にする）
+			// 認証がオプショナルなエンドポイント（プライベートチャンネルはメンバーのみ閲覧可能）
 			messages.GET("", authMiddleware.OptionalAuth(), messageHandler.GetMessages)
 			messages.GET("/recent", authMiddleware.OptionalAuth(), messageHandler.GetRecentMessages)
+			messages.GET("/:id/thread", authMiddleware.OptionalAuth(), messageHandler.GetThread)
 		}
 
 		// チャンネルエンドポイント
@@ -139,6 +260,48 @@ func main() {
 		{
 			channels.GET("", authMiddleware.OptionalAuth(), messageHandler.GetChannels)
 			channels.GET("/:channel", authMiddleware.OptionalAuth(), messageHandler.GetChannelInfo)
+
+			// 認証が必要なエンドポイント
+			channels.POST("", authMiddleware.RequireAuth(), channelHandler.CreateChannel)
+			channels.PATCH("/:channel", authMiddleware.RequireAuth(), channelHandler.UpdateChannel)
+			channels.DELETE("/:channel", authMiddleware.RequireAuth(), channelHandler.DeleteChannel)
+			channels.POST("/:channel/read", authMiddleware.RequireAuth(), messageHandler.MarkChannelRead)
+
+			// メンバーシップ
+			channels.POST("/:channel/join", authMiddleware.RequireAuth(), channelHandler.JoinChannel)
+			channels.POST("/:channel/leave", authMiddleware.RequireAuth(), channelHandler.LeaveChannel)
+			channels.GET("/:channel/members", authMiddleware.RequireAuth(), channelHandler.GetMembers)
+			channels.POST("/:channel/members", authMiddleware.RequireAuth(), channelHandler.InviteMember)
+			channels.DELETE("/:channel/members/:user_id", authMiddleware.RequireAuth(), channelHandler.KickMember)
+		}
+
+		// ダイレクトメッセージエンドポイント（認証必須）
+		dms := api.Group("/dms", authMiddleware.RequireAuth())
+		{
+			dms.GET("", dmHandler.GetConversations)
+			dms.GET("/:user_id/messages", dmHandler.GetMessages)
+			dms.POST("/:user_id/messages", dmHandler.SendMessage)
+			dms.POST("/:user_id/read", dmHandler.MarkRead)
+		}
+
+		// グループ会話エンドポイント（認証必須）
+		conversations := api.Group("/conversations", authMiddleware.RequireAuth())
+		{
+			conversations.GET("", conversationHandler.GetConversations)
+			conversations.POST("", conversationHandler.CreateConversation)
+			conversations.GET("/:id/messages", conversationHandler.GetMessages)
+			conversations.POST("/:id/messages", conversationHandler.SendMessage)
+			conversations.POST("/:id/read", conversationHandler.MarkRead)
+			conversations.POST("/:id/participants", conversationHandler.AddParticipant)
+			conversations.DELETE("/:id/participants/:user_id", conversationHandler.RemoveParticipant)
+		}
+
+		// ユーザーステータスエンドポイント（認証必須）
+		status := api.Group("/status", authMiddleware.RequireAuth())
+		{
+			status.PUT("", statusHandler.SetStatus)
+			status.DELETE("", statusHandler.ClearStatus)
+			status.GET("/:user_id", statusHandler.GetStatus)
 		}
 
 		// WebSocket関連エンドポイント
@@ -160,6 +323,18 @@ func main() {
 }
 
 // getEnv gets environment variable with fallback
+// trustedProxies parses a comma separated list of proxy IPs or CIDRs; an
+// empty list trusts no proxy
+func trustedProxies(value string) []string {
+	var proxies []string
+	for _, proxy := range strings.Split(value, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func getEnv(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		proxies    string
+		remoteAddr string
+		want       string
+	}{
+		{name: "no proxy ignores a spoofed header", proxies: "", remoteAddr: "198.51.100.7:4000", want: "198.51.100.7"},
+		{name: "untrusted peer ignores the header", proxies: "10.0.0.0/8", remoteAddr: "198.51.100.7:4000", want: "198.51.100.7"},
+		{name: "trusted proxy forwards the client", proxies: " 10.0.0.0/8, 192.168.1.1 ", remoteAddr: "10.1.2.3:4000", want: "203.0.113.9"},
+		{name: "trusted single address", proxies: "10.0.0.0/8,192.168.1.1", remoteAddr: "192.168.1.1:4000", want: "203.0.113.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			if err := r.SetTrustedProxies(trustedProxies(tt.proxies)); err != nil {
+				t.Fatalf("SetTrustedProxies: %v", err)
+			}
+
+			var got string
+			r.GET("/", func(c *gin.Context) { got = c.ClientIP() })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "203.0.113.9")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
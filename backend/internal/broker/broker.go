@@ -0,0 +1,62 @@
+// Package broker carries hub messages between server instances.
+package broker
+
+import (
+	"context"
+	"errors"
+	"path"
+)
+
+// Broker kinds selectable with the MESSAGE_BROKER environment variable
+const (
+	KindRedis        = "redis"
+	KindRedisStreams = "redis-streams"
+	KindNATS         = "nats"
+	KindMemory       = "memory"
+)
+
+// ErrClosed is returned when using a broker after Close
+var ErrClosed = errors.New("broker closed")
+
+// Message is a payload received on a topic
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Broker publishes messages on topics and delivers them to the subscriptions
+// whose patterns match. Patterns use glob syntax, for example "chat:*".
+type Broker interface {
+	// Publish sends a payload to every matching subscription
+	Publish(ctx context.Context, topic string, payload []byte) error
+
+	// PSubscribe subscribes to the topics matching any of the patterns. The
+	// returned channel is closed when ctx is done or the broker is closed.
+	PSubscribe(ctx context.Context, patterns ...string) (<-chan Message, error)
+
+	// Close ends all subscriptions. It does not close connections the
+	// broker was given.
+	Close() error
+}
+
+// InterestFilter is implemented by brokers that can skip the topics no local
+// subscriber needs
+type InterestFilter interface {
+	// SetInterest limits delivery to the topics for which wants returns true.
+	// wants must be safe for concurrent use.
+	SetInterest(wants func(topic string) bool)
+
+	// RefreshInterest must be called when a topic gains its first local
+	// subscriber. It does not block.
+	RefreshInterest()
+}
+
+// matchAny reports whether a topic matches one of the glob patterns
+func matchAny(patterns []string, topic string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, topic); matched {
+			return true
+		}
+	}
+	return false
+}
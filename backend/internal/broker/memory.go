@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryBuffer is the number of messages a subscription buffers before
+// Publish waits for the subscriber
+const memoryBuffer = 256
+
+// Memory is an in-process broker for tests and single-node deployments.
+// Messages are delivered in publish order and never dropped.
+type Memory struct {
+	mutex         sync.RWMutex
+	subscriptions map[*memorySubscription]bool
+	closed        bool
+}
+
+type memorySubscription struct {
+	patterns []string
+	out      chan Message
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewMemory creates an in-process broker
+func NewMemory() *Memory {
+	return &Memory{
+		subscriptions: make(map[*memorySubscription]bool),
+	}
+}
+
+// Publish delivers a payload to the matching subscriptions
+func (b *Memory) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	for sub := range b.subscriptions {
+		if !matchAny(sub.patterns, topic) {
+			continue
+		}
+		select {
+		case sub.out <- Message{Topic: topic, Payload: payload}:
+		case <-sub.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// PSubscribe subscribes to the topics matching any of the patterns
+func (b *Memory) PSubscribe(ctx context.Context, patterns ...string) (<-chan Message, error) {
+	sub := &memorySubscription{
+		patterns: patterns,
+		out:      make(chan Message, memoryBuffer),
+		done:     make(chan struct{}),
+	}
+
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	b.subscriptions[sub] = true
+	b.mutex.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+		b.unsubscribe(sub)
+	}()
+
+	return sub.out, nil
+}
+
+// unsubscribe stops a subscription and closes its channel. done is closed
+// first so a Publish waiting on the subscription lets go of the lock.
+func (b *Memory) unsubscribe(sub *memorySubscription) {
+	sub.once.Do(func() { close(sub.done) })
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscriptions[sub] {
+		delete(b.subscriptions, sub)
+		close(sub.out)
+	}
+}
+
+// Close ends all subscriptions
+func (b *Memory) Close() error {
+	b.mutex.Lock()
+	b.closed = true
+	subscriptions := make([]*memorySubscription, 0, len(b.subscriptions))
+	for sub := range b.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	b.mutex.Unlock()
+
+	for _, sub := range subscriptions {
+		b.unsubscribe(sub)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsBuffer is the number of messages a subscription buffers. NATS drops
+// messages for a subscriber that falls further behind (slow consumer).
+const natsBuffer = 256
+
+// NATS publishes every topic on its own subject and fans messages out to all
+// instances; no queue groups are used, so each instance receives every
+// message. Like Redis Pub/Sub, messages published while an instance is
+// disconnected are lost for it.
+//
+// Topics map to subjects by turning the "kind:" prefix into a subject token,
+// so "chat:general" is published on "chat.general" and "presence" on
+// "presence". Characters NATS does not allow in a token are escaped.
+type NATS struct {
+	conn *nats.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+// NewNATS creates a broker on an established NATS connection
+func NewNATS(conn *nats.Conn) *NATS {
+	return &NATS{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+}
+
+// Publish sends a payload on the topic's subject
+func (b *NATS) Publish(ctx context.Context, topic string, payload []byte) error {
+	select {
+	case <-b.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	return b.conn.Publish(natsSubject(topic), payload)
+}
+
+// PSubscribe subscribes to the subjects of the patterns and waits until the
+// server processed the subscriptions. Only exact topics and "kind:*"
+// patterns are supported.
+func (b *NATS) PSubscribe(ctx context.Context, patterns ...string) (<-chan Message, error) {
+	subjects := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		subject, err := natsPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		subjects[i] = subject
+	}
+
+	select {
+	case <-b.done:
+		return nil, ErrClosed
+	default:
+	}
+
+	in := make(chan *nats.Msg, natsBuffer)
+	subscriptions := make([]*nats.Subscription, 0, len(subjects))
+	unsubscribe := func() {
+		for _, sub := range subscriptions {
+			sub.Unsubscribe()
+		}
+	}
+
+	for _, subject := range subjects {
+		sub, err := b.conn.ChanSubscribe(subject, in)
+		if err != nil {
+			unsubscribe()
+			return nil, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+
+	// サブスクリプションがサーバーに登録されたことを確認
+	if err := b.conn.Flush(); err != nil {
+		unsubscribe()
+		return nil, err
+	}
+	log.Printf("✅ NATS subscription confirmed: %v", subjects)
+
+	out := make(chan Message)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		for {
+			select {
+			case msg := <-in:
+				topic, err := natsTopic(msg.Subject)
+				if err != nil {
+					log.Printf("❌ Ignoring NATS message on subject %s: %v", msg.Subject, err)
+					continue
+				}
+				select {
+				case out <- Message{Topic: topic, Payload: msg.Data}:
+				case <-ctx.Done():
+					return
+				case <-b.done:
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-b.done:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Close ends all subscriptions
+func (b *NATS) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return nil
+}
+
+// natsSubject returns the subject a topic is published on
+func natsSubject(topic string) string {
+	kind, name, found := strings.Cut(topic, ":")
+	if !found {
+		return escapeToken(topic)
+	}
+	return escapeToken(kind) + "." + escapeToken(name)
+}
+
+// natsPattern returns the subject to subscribe to for a topic pattern
+func natsPattern(pattern string) (string, error) {
+	if kind, found := strings.CutSuffix(pattern, ":*"); found && !strings.ContainsAny(kind, "*?[") {
+		return escapeToken(kind) + ".*", nil
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		return "", fmt.Errorf("unsupported NATS topic pattern: %s", pattern)
+	}
+	return natsSubject(pattern), nil
+}
+
+// natsTopic returns the topic a message received on a subject belongs to
+func natsTopic(subject string) (string, error) {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) > 2 {
+		return "", errors.New("too many subject tokens")
+	}
+
+	for i, token := range tokens {
+		unescaped, err := url.PathUnescape(token)
+		if err != nil {
+			return "", err
+		}
+		tokens[i] = unescaped
+	}
+	return strings.Join(tokens, ":"), nil
+}
+
+// escapeToken percent-encodes the characters that may not appear in a
+// subject token: separators, wildcards, whitespace and the escape itself
+func escapeToken(token string) string {
+	var builder strings.Builder
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		switch {
+		case c == '.' || c == '*' || c == '>' || c == '%' || c <= ' ' || c == 0x7f:
+			fmt.Fprintf(&builder, "%%%02X", c)
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,200 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// runNATSServer starts an embedded NATS server on a random port
+func runNATSServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// newNATSBroker connects a broker to the server, like one server instance
+func newNATSBroker(t *testing.T, srv *server.Server) *NATS {
+	t.Helper()
+
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	b := NewNATS(conn)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func receive(t *testing.T, messages <-chan Message) Message {
+	t.Helper()
+
+	select {
+	case msg, ok := <-messages:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return Message{}
+}
+
+func expectNothing(t *testing.T, messages <-chan Message) {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected message on %s: %s", msg.Topic, msg.Payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNATSFanOutToAllInstances(t *testing.T) {
+	srv := runNATSServer(t)
+	ctx := context.Background()
+
+	instances := []*NATS{newNATSBroker(t, srv), newNATSBroker(t, srv)}
+	subscriptions := make([]<-chan Message, len(instances))
+	for i, b := range instances {
+		messages, err := b.PSubscribe(ctx, "chat:*", "user:*", "presence")
+		if err != nil {
+			t.Fatalf("PSubscribe: %v", err)
+		}
+		subscriptions[i] = messages
+	}
+
+	topics := []string{"chat:general", "user:42", "presence"}
+	for _, topic := range topics {
+		if err := instances[0].Publish(ctx, topic, []byte(topic)); err != nil {
+			t.Fatalf("Publish %s: %v", topic, err)
+		}
+	}
+
+	for i, messages := range subscriptions {
+		for _, topic := range topics {
+			msg := receive(t, messages)
+			if msg.Topic != topic || string(msg.Payload) != topic {
+				t.Errorf("instance %d: got %s %q, want %s", i, msg.Topic, msg.Payload, topic)
+			}
+		}
+	}
+}
+
+func TestNATSPatternFiltering(t *testing.T) {
+	srv := runNATSServer(t)
+	ctx := context.Background()
+	b := newNATSBroker(t, srv)
+
+	messages, err := b.PSubscribe(ctx, "chat:*")
+	if err != nil {
+		t.Fatalf("PSubscribe: %v", err)
+	}
+
+	if err := b.Publish(ctx, "user:1", []byte("dm")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := b.Publish(ctx, "presence", []byte("online")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	expectNothing(t, messages)
+}
+
+func TestNATSChannelNamesRoundTrip(t *testing.T) {
+	srv := runNATSServer(t)
+	ctx := context.Background()
+	b := newNATSBroker(t, srv)
+
+	messages, err := b.PSubscribe(ctx, "chat:*")
+	if err != nil {
+		t.Fatalf("PSubscribe: %v", err)
+	}
+
+	// Names with subject separators, wildcards and spaces stay one token
+	names := []string{"release.v2", "q&a > faq", "100% * off", "dm:1:2", "日本語"}
+	for _, name := range names {
+		if err := b.Publish(ctx, "chat:"+name, []byte(name)); err != nil {
+			t.Fatalf("Publish %q: %v", name, err)
+		}
+	}
+
+	for _, name := range names {
+		msg := receive(t, messages)
+		if msg.Topic != "chat:"+name {
+			t.Errorf("got topic %q, want %q", msg.Topic, "chat:"+name)
+		}
+	}
+}
+
+func TestNATSSubscriptionEndsWithContext(t *testing.T) {
+	srv := runNATSServer(t)
+	b := newNATSBroker(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, err := b.PSubscribe(ctx, "chat:*")
+	if err != nil {
+		t.Fatalf("PSubscribe: %v", err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Fatal("expected subscription to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not closed after cancel")
+	}
+}
+
+func TestNATSClose(t *testing.T) {
+	srv := runNATSServer(t)
+	ctx := context.Background()
+	b := newNATSBroker(t, srv)
+
+	messages, err := b.PSubscribe(ctx, "chat:*")
+	if err != nil {
+		t.Fatalf("PSubscribe: %v", err)
+	}
+	b.Close()
+
+	if _, ok := <-messages; ok {
+		t.Fatal("expected subscription to be closed")
+	}
+	if err := b.Publish(ctx, "chat:general", nil); err != ErrClosed {
+		t.Fatalf("Publish after Close: got %v, want ErrClosed", err)
+	}
+	if _, err := b.PSubscribe(ctx, "chat:*"); err != ErrClosed {
+		t.Fatalf("PSubscribe after Close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestNATSUnsupportedPattern(t *testing.T) {
+	srv := runNATSServer(t)
+	b := newNATSBroker(t, srv)
+
+	if _, err := b.PSubscribe(context.Background(), "chat:gen*"); err == nil {
+		t.Fatal("expected an error for a partial wildcard")
+	}
+}
@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisPubSub uses Redis PUBLISH and pattern subscriptions. Messages
+// published while an instance is disconnected from Redis are lost for it.
+type RedisPubSub struct {
+	client     *redis.Client
+	subscriber *redis.Client
+	done       chan struct{}
+	once       sync.Once
+}
+
+// NewRedisPubSub creates a broker publishing with client and subscribing with
+// subscriber, a separate connection dedicated to subscriptions
+func NewRedisPubSub(client, subscriber *redis.Client) *RedisPubSub {
+	return &RedisPubSub{
+		client:     client,
+		subscriber: subscriber,
+		done:       make(chan struct{}),
+	}
+}
+
+// Publish sends a payload with PUBLISH
+func (b *RedisPubSub) Publish(ctx context.Context, topic string, payload []byte) error {
+	return b.client.Publish(ctx, topic, payload).Err()
+}
+
+// PSubscribe subscribes with PSUBSCRIBE and waits until Redis confirmed every pattern
+func (b *RedisPubSub) PSubscribe(ctx context.Context, patterns ...string) (<-chan Message, error) {
+	pubsub := b.subscriber.PSubscribe(ctx, patterns...)
+
+	// サブスクリプションが正常に作成されたかテスト（パターンごとに確認が届く）
+	for range patterns {
+		if _, err := pubsub.Receive(ctx); err != nil {
+			pubsub.Close()
+			return nil, err
+		}
+	}
+	log.Printf("✅ Redis pattern subscription confirmed: %v", patterns)
+
+	out := make(chan Message)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					log.Printf("⚠️ Redis subscription channel closed")
+					return
+				}
+				select {
+				case out <- Message{Topic: msg.Channel, Payload: []byte(msg.Payload)}:
+				case <-ctx.Done():
+					return
+				case <-b.done:
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-b.done:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Close ends all subscriptions
+func (b *RedisPubSub) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return nil
+}
@@ -0,0 +1,313 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultStreamMaxLen is the approximate number of entries kept per stream
+	DefaultStreamMaxLen = 1000
+
+	// streamKeyPrefix prefixes the stream of every topic
+	streamKeyPrefix = "stream:"
+
+	// streamTopicsKey is the ZSET of topics by the time of their last message
+	// in milliseconds
+	streamTopicsKey = "stream_topics:active"
+
+	// streamTopicsStream announces new topics so readers pick them up at once
+	streamTopicsStream = "stream_topics:new"
+
+	// streamWakeKeyPrefix prefixes the stream each broker reads to wake up its
+	// readers when a topic gains local subscribers
+	streamWakeKeyPrefix = "stream_wake:"
+
+	// streamIdleTTL is how long a stream is kept without new messages
+	streamIdleTTL = time.Hour
+
+	// streamTopicTTL is how long a topic stays listed without new messages,
+	// longer than its stream so readers never drop a stream that still exists
+	streamTopicTTL = 2 * streamIdleTTL
+
+	// streamPruneInterval is how often readers drop idle topics
+	streamPruneInterval = time.Minute
+
+	// streamReadBlock is how long a read waits for new entries
+	streamReadBlock = 5 * time.Second
+
+	// streamRetryDelay is how long to wait after a failed read
+	streamRetryDelay = time.Second
+)
+
+// RedisStreams appends every message to a capped Redis stream per topic
+// (XADD with MAXLEN) and reads the matching streams with XREAD, remembering
+// the last entry ID of each. After losing the connection to Redis it
+// continues from those IDs, so a subscriber sees every message still in the
+// streams (at-least-once delivery). Streams are read from the time of the
+// subscription as told by the Redis server, whose clock also assigns entry
+// IDs, so clock drift between hosts neither skips nor replays entries.
+//
+// Streams expire after streamIdleTTL without messages and their topics are
+// dropped after streamTopicTTL. With SetInterest only the streams of topics
+// that have local subscribers are read.
+type RedisStreams struct {
+	client  *redis.Client
+	reader  *redis.Client
+	maxLen  int64
+	wakeKey string
+	done    chan struct{}
+	once    sync.Once
+
+	mutex sync.RWMutex
+	wants func(topic string) bool
+}
+
+// NewRedisStreams creates a streams broker writing with client and reading
+// with reader, a separate connection for blocking reads. maxLen bounds each
+// stream; zero uses DefaultStreamMaxLen.
+func NewRedisStreams(client, reader *redis.Client, maxLen int64) *RedisStreams {
+	if maxLen <= 0 {
+		maxLen = DefaultStreamMaxLen
+	}
+	return &RedisStreams{
+		client:  client,
+		reader:  reader,
+		maxLen:  maxLen,
+		wakeKey: streamWakeKeyPrefix + uuid.New().String(),
+		done:    make(chan struct{}),
+	}
+}
+
+// SetInterest limits reading to the topics for which wants returns true. It
+// is called from the readers and must be safe for concurrent use.
+func (b *RedisStreams) SetInterest(wants func(topic string) bool) {
+	b.mutex.Lock()
+	b.wants = wants
+	b.mutex.Unlock()
+}
+
+// RefreshInterest wakes up the readers so they start reading the topics that
+// gained local subscribers
+func (b *RedisStreams) RefreshInterest() {
+	go func() {
+		ctx := context.Background()
+		pipe := b.client.TxPipeline()
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: b.wakeKey,
+			MaxLen: 1,
+			Values: map[string]interface{}{"wake": 1},
+		})
+		pipe.PExpire(ctx, b.wakeKey, streamIdleTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("❌ Failed to wake up Redis stream readers: %v", err)
+		}
+	}()
+}
+
+// wanted reports whether a topic has local subscribers
+func (b *RedisStreams) wanted(topic string) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.wants == nil || b.wants(topic)
+}
+
+// Publish appends a payload to the topic's stream
+func (b *RedisStreams) Publish(ctx context.Context, topic string, payload []byte) error {
+	key := streamKeyPrefix + topic
+	pipe := b.client.TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"payload": payload},
+	})
+	pipe.PExpire(ctx, key, streamIdleTTL)
+	added := pipe.ZAdd(ctx, streamTopicsKey, &redis.Z{
+		Score:  float64(time.Now().UnixMilli()),
+		Member: topic,
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	// Wake up the readers so they start reading the new topic
+	if added.Val() == 1 {
+		return b.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: streamTopicsStream,
+			MaxLen: b.maxLen,
+			Approx: true,
+			Values: map[string]interface{}{"topic": topic},
+		}).Err()
+	}
+	return nil
+}
+
+// PSubscribe reads the streams of the topics matching the patterns
+func (b *RedisStreams) PSubscribe(ctx context.Context, patterns ...string) (<-chan Message, error) {
+	now, err := b.reader.Time(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	startsAt := streamIDBefore(now)
+
+	out := make(chan Message)
+	go b.read(ctx, patterns, startsAt, out)
+	return out, nil
+}
+
+// read delivers stream entries after startsAt until ctx is done or the
+// broker is closed
+func (b *RedisStreams) read(ctx context.Context, patterns []string, startsAt string, out chan<- Message) {
+	defer close(out)
+	log.Printf("🔔 Starting Redis stream reader for %v from %s", patterns, startsAt)
+
+	// ストリームごとに最後に読んだエントリIDを保持する
+	lastIDs := map[string]string{streamTopicsStream: startsAt, b.wakeKey: startsAt}
+
+	// Streams skipped in the previous round because nobody subscribed to
+	// them are read from the start of that round once they become wanted;
+	// new streams are read from startsAt
+	readFrom := startsAt
+	skipped := make(map[string]bool)
+	var prunedAt time.Time
+
+	for !b.stopped(ctx) {
+		pipe := b.reader.Pipeline()
+		timeCmd := pipe.Time(ctx)
+		topicsCmd := pipe.ZRange(ctx, streamTopicsKey, 0, -1)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("❌ Failed to list stream topics: %v", err)
+			time.Sleep(streamRetryDelay)
+			continue
+		}
+		now := timeCmd.Val()
+
+		if now.Sub(prunedAt) >= streamPruneInterval {
+			b.pruneTopics(ctx, now)
+			prunedAt = now
+		}
+
+		reading := map[string]bool{streamTopicsStream: true, b.wakeKey: true}
+		unwanted := make(map[string]bool)
+		for _, topic := range topicsCmd.Val() {
+			if !matchAny(patterns, topic) {
+				continue
+			}
+			key := streamKeyPrefix + topic
+			if !b.wanted(topic) {
+				unwanted[key] = true
+				continue
+			}
+			reading[key] = true
+			if _, ok := lastIDs[key]; !ok {
+				lastIDs[key] = startsAt
+				if skipped[key] {
+					lastIDs[key] = readFrom
+				}
+			}
+		}
+		skipped = unwanted
+		for key := range lastIDs {
+			if !reading[key] {
+				delete(lastIDs, key)
+			}
+		}
+		readFrom = streamIDBefore(now)
+
+		streams := make([]string, 0, len(lastIDs)*2)
+		ids := make([]string, 0, len(lastIDs))
+		for key, id := range lastIDs {
+			streams = append(streams, key)
+			ids = append(ids, id)
+		}
+
+		results, err := b.reader.XRead(ctx, &redis.XReadArgs{
+			Streams: append(streams, ids...),
+			Block:   streamReadBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if !b.stopped(ctx) {
+				log.Printf("❌ Failed to read Redis streams, retrying: %v", err)
+				time.Sleep(streamRetryDelay)
+			}
+			continue
+		}
+
+		for _, result := range results {
+			for _, entry := range result.Messages {
+				lastIDs[result.Stream] = entry.ID
+				if result.Stream == streamTopicsStream || result.Stream == b.wakeKey {
+					continue
+				}
+
+				payload, ok := entry.Values["payload"].(string)
+				if !ok {
+					continue
+				}
+
+				message := Message{
+					Topic:   strings.TrimPrefix(result.Stream, streamKeyPrefix),
+					Payload: []byte(payload),
+				}
+				select {
+				case out <- message:
+				case <-ctx.Done():
+					return
+				case <-b.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// streamIDBefore returns the last possible entry ID before the millisecond
+// of t, so reading after it includes every entry added from then on
+func streamIDBefore(t time.Time) string {
+	return fmt.Sprintf("%d-%d", t.UnixMilli()-1, uint64(math.MaxUint64))
+}
+
+// pruneTopics drops the topics without messages for streamTopicTTL, whose
+// streams have expired
+func (b *RedisStreams) pruneTopics(ctx context.Context, now time.Time) {
+	cutoff := strconv.FormatInt(now.Add(-streamTopicTTL).UnixMilli(), 10)
+	removed, err := b.reader.ZRemRangeByScore(ctx, streamTopicsKey, "-inf", "("+cutoff).Result()
+	if err != nil {
+		log.Printf("❌ Failed to prune idle stream topics: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("🧹 Pruned %d idle stream topics", removed)
+	}
+}
+
+// stopped reports whether reading should end
+func (b *RedisStreams) stopped(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close ends all subscriptions once their current read returns
+func (b *RedisStreams) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return nil
+}
@@ -0,0 +1,171 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newStreamsBroker connects a streams broker to the server, like one server instance
+func newStreamsBroker(t *testing.T, srv *miniredis.Miniredis) *RedisStreams {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	reader := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+		reader.Close()
+	})
+
+	b := NewRedisStreams(client, reader, 0)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// topicSet is an interest filter whose topics can change during a test
+type topicSet struct {
+	mutex  sync.Mutex
+	topics map[string]bool
+}
+
+func (s *topicSet) add(topic string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topics[topic] = true
+}
+
+func (s *topicSet) wants(topic string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.topics[topic]
+}
+
+func TestRedisStreamsFanOutToAllInstances(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+
+	instances := []*RedisStreams{newStreamsBroker(t, srv), newStreamsBroker(t, srv)}
+	subscriptions := make([]<-chan Message, len(instances))
+	for i, b := range instances {
+		messages, err := b.PSubscribe(ctx, "chat:*", "user:*", "presence")
+		if err != nil {
+			t.Fatalf("PSubscribe: %v", err)
+		}
+		subscriptions[i] = messages
+	}
+
+	topics := []string{"chat:general", "user:42", "presence"}
+	for _, topic := range topics {
+		if err := instances[0].Publish(ctx, topic, []byte(topic)); err != nil {
+			t.Fatalf("Publish %s: %v", topic, err)
+		}
+	}
+
+	for i, messages := range subscriptions {
+		got := make(map[string]bool)
+		for range topics {
+			msg := receive(t, messages)
+			got[msg.Topic] = string(msg.Payload) == msg.Topic
+		}
+		for _, topic := range topics {
+			if !got[topic] {
+				t.Errorf("instance %d: no message on %s", i, topic)
+			}
+		}
+	}
+}
+
+func TestRedisStreamsReadsOnlyWantedTopics(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+	b := newStreamsBroker(t, srv)
+
+	interest := &topicSet{topics: map[string]bool{"chat:general": true}}
+	b.SetInterest(interest.wants)
+	messages, err := b.PSubscribe(ctx, "chat:*")
+	if err != nil {
+		t.Fatalf("PSubscribe: %v", err)
+	}
+
+	if err := b.Publish(ctx, "chat:random", []byte("before")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	expectNothing(t, messages)
+
+	if err := b.Publish(ctx, "chat:general", []byte("general")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if msg := receive(t, messages); msg.Topic != "chat:general" {
+		t.Fatalf("got topic %s, want chat:general", msg.Topic)
+	}
+
+	// A topic that gains a subscriber is read at once, without what was
+	// published before
+	interest.add("chat:random")
+	b.RefreshInterest()
+	expectNothing(t, messages)
+
+	if err := b.Publish(ctx, "chat:random", []byte("after")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case msg := <-messages:
+		if msg.Topic != "chat:random" || string(msg.Payload) != "after" {
+			t.Fatalf("got %s %q, want chat:random %q", msg.Topic, msg.Payload, "after")
+		}
+	case <-time.After(streamReadBlock / 2):
+		t.Fatal("newly wanted topic was not read before the blocking read ended")
+	}
+}
+
+func TestRedisStreamsExpireIdleTopics(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+	b := newStreamsBroker(t, srv)
+
+	for _, topic := range []string{"chat:general", "chat:random"} {
+		if err := b.Publish(ctx, topic, []byte(topic)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	if ttl := srv.TTL(streamKeyPrefix + "chat:general"); ttl != streamIdleTTL {
+		t.Errorf("stream TTL = %v, want %v", ttl, streamIdleTTL)
+	}
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  []string
+	}{
+		{name: "recent topics stay", after: streamIdleTTL, want: []string{"chat:general", "chat:random"}},
+		{name: "idle topics go", after: streamTopicTTL + time.Minute, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.pruneTopics(ctx, time.Now().Add(tt.after))
+
+			got, err := srv.ZMembers(streamTopicsKey)
+			if err != nil && len(tt.want) > 0 {
+				t.Fatalf("ZMembers: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("topics = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("topics = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	srv.FastForward(streamIdleTTL)
+	if srv.Exists(streamKeyPrefix + "chat:general") {
+		t.Error("idle stream was kept")
+	}
+}
@@ -81,17 +81,72 @@ func Migrate() error {
 	
 	err := DB.AutoMigrate(
 		&models.User{},
+		&models.Channel{},
+		&models.ChannelMember{},
+		&models.ChannelRead{},
+		&models.Conversation{},
+		&models.ConversationParticipant{},
 		&models.Message{},
+		&models.MessageRevision{},
+		&models.Reaction{},
+		&models.UserStatus{},
+		&models.LoginAttempt{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.SigningKey{},
 	)
 	
 	if err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	if err := migrateMessageChannels(); err != nil {
+		return fmt.Errorf("failed to migrate message channels: %w", err)
+	}
+
+	// The (channel_id, id) cursor index supersedes the indexes on the channel
+	// name and on channel_id alone; history is read by channel ID
+	for _, index := range []string{"idx_messages_channel", "idx_messages_channel_cursor", "idx_messages_channel_id"} {
+		if DB.Migrator().HasIndex(&models.Message{}, index) {
+			if err := DB.Migrator().DropIndex(&models.Message{}, index); err != nil {
+				return fmt.Errorf("failed to drop superseded message index: %w", err)
+			}
+		}
+	}
+
 	log.Println("Database migrations completed successfully")
 	return nil
 }
 
+// DefaultChannelName is the channel every installation starts with
+const DefaultChannelName = "general"
+
+// migrateMessageChannels creates a channel row for every channel name already
+// used by messages and links those messages to it by foreign key. Direct and
+// group conversation messages are left alone, and names of deleted channels
+// are not brought back.
+func migrateMessageChannels() error {
+	if err := DB.FirstOrCreate(&models.Channel{}, models.Channel{Name: DefaultChannelName}).Error; err != nil {
+		return err
+	}
+
+	if err := DB.Exec(`
+		INSERT INTO channels (name, topic, description, is_archived, created_at, updated_at)
+		SELECT DISTINCT m.channel, '', '', false, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM messages m
+		WHERE m.conversation_id IS NULL AND NOT EXISTS (
+			SELECT 1 FROM channels c WHERE c.name = m.channel
+		)`).Error; err != nil {
+		return err
+	}
+
+	return DB.Exec(`
+		UPDATE messages SET channel_id = c.id
+		FROM channels c
+		WHERE messages.channel = c.name AND c.deleted_at IS NULL
+			AND messages.channel_id IS NULL AND messages.conversation_id IS NULL`).Error
+}
+
 // SeedData inserts initial data for development
 func SeedData() error {
 	if DB == nil {
@@ -129,17 +184,24 @@ func SeedData() error {
 		}
 	}
 
+	var general models.Channel
+	if err := DB.FirstOrCreate(&general, models.Channel{Name: DefaultChannelName}).Error; err != nil {
+		return fmt.Errorf("failed to create default channel: %w", err)
+	}
+
 	// Create sample messages
 	messages := []models.Message{
 		{
-			UserID:  1,
-			Content: "Welcome to the general channel!",
-			Channel: "general",
+			UserID:    1,
+			Content:   "Welcome to the general channel!",
+			Channel:   general.Name,
+			ChannelID: &general.ID,
 		},
 		{
-			UserID:  2,
-			Content: "Hello everyone!",
-			Channel: "general",
+			UserID:    2,
+			Content:   "Hello everyone!",
+			Channel:   general.Name,
+			ChannelID: &general.ID,
 		},
 	}
 
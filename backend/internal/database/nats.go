@@ -0,0 +1,62 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nats-io/nats.go"
+)
+
+var NATSConn *nats.Conn
+
+// NATSConfig holds NATS configuration
+type NATSConfig struct {
+	URL  string
+	Name string
+}
+
+// LoadNATSConfig loads NATS configuration from environment variables
+func LoadNATSConfig() *NATSConfig {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	return &NATSConfig{
+		URL:  url,
+		Name: "chatapp",
+	}
+}
+
+// ConnectNATS establishes the NATS connection. The client reconnects on its
+// own if the connection drops later.
+func ConnectNATS() error {
+	config := LoadNATSConfig()
+
+	conn, err := nats.Connect(config.URL,
+		nats.Name(config.Name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Printf("⚠️ NATS disconnected: %v", err)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Printf("🔄 NATS reconnected to %s", conn.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	NATSConn = conn
+
+	log.Println("NATS connection established successfully")
+	return nil
+}
+
+// CloseNATS drains and closes the NATS connection
+func CloseNATS() error {
+	if NATSConn == nil {
+		return nil
+	}
+	return NATSConn.Drain()
+}
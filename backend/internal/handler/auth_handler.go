@@ -1,7 +1,11 @@
 package handler
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"chatapp/internal/middleware"
 	"chatapp/internal/service"
@@ -29,13 +33,16 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 		return
 	}
 
-	response, err := h.authService.Signup(req)
+	response, err := h.authService.Signup(req, service.LoginClient{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
 	if err != nil {
 		status := http.StatusInternalServerError
 		if err.Error() == "email already exists" || err.Error() == "username already exists" {
 			status = http.StatusConflict
 		}
-		
+
 		c.JSON(status, gin.H{
 			"error": err.Error(),
 		})
@@ -59,13 +66,27 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	response, err := h.authService.Login(req)
+	response, err := h.authService.Login(req, service.LoginClient{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
 	if err != nil {
+		var lockedErr *service.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			retryAfter := time.Until(lockedErr.LockedUntil)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":        err.Error(),
+				"locked_until": lockedErr.LockedUntil,
+			})
+			return
+		}
+
 		status := http.StatusInternalServerError
 		if err.Error() == "invalid email or password" {
 			status = http.StatusUnauthorized
 		}
-		
+
 		c.JSON(status, gin.H{
 			"error": err.Error(),
 		})
@@ -101,8 +122,65 @@ func (h *AuthHandler) GetMe(c *gin.Context) {
 	})
 }
 
-// RefreshToken generates a new token for the authenticated user
+// RefreshToken exchanges a refresh token for a new access token and refresh token
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req service.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	response, err := h.authService.Refresh(req.RefreshToken, service.LoginClient{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "invalid refresh token", "refresh token reused", "session revoked", "user not found":
+			status = http.StatusUnauthorized
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Token refreshed successfully",
+		"data":    response,
+	})
+}
+
+// Logout ends the refresh token's session, or all of the user's sessions
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req service.LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := h.authService.Logout(req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged out successfully",
+	})
+}
+
+// GetSessions lists the devices the user is signed in on
+func (h *AuthHandler) GetSessions(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -110,24 +188,44 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		})
 		return
 	}
+	sessionID, _ := middleware.GetSessionID(c)
 
-	user, err := h.authService.GetUserByID(userID)
+	sessions, err := h.authService.ListSessions(userID, sessionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get user information",
+			"error": "Failed to get sessions",
 		})
 		return
 	}
 
-	// Create a new signup request to generate a new token
-	// Note: This is a simplified approach. In production, you might want
-	// a dedicated refresh token mechanism
-	response := &service.AuthResponse{
-		User: *user,
+	c.JSON(http.StatusOK, gin.H{
+		"data": sessions,
+	})
+}
+
+// RevokeSession signs the user out of one of their devices
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	if err := h.authService.RevokeSession(userID, c.Param("id")); err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "session not found" {
+			status = http.StatusNotFound
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Token refreshed successfully",
-		"data":    response,
+		"message": "Session revoked successfully",
 	})
-}
\ No newline at end of file
+}
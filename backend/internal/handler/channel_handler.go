@@ -0,0 +1,334 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"chatapp/internal/middleware"
+	"chatapp/internal/models"
+	"chatapp/internal/service"
+	ws "chatapp/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChannelHandler struct {
+	channelService *service.ChannelService
+	hub            *ws.Hub
+}
+
+func NewChannelHandler(channelService *service.ChannelService, hub *ws.Hub) *ChannelHandler {
+	return &ChannelHandler{
+		channelService: channelService,
+		hub:            hub,
+	}
+}
+
+// CreateChannel handles channel creation
+func (h *ChannelHandler) CreateChannel(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req service.CreateChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	channel, err := h.channelService.CreateChannel(userID, req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "invalid channel name":
+			status = http.StatusBadRequest
+		case "channel already exists":
+			status = http.StatusConflict
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Channel created successfully",
+		"data":    channel,
+	})
+}
+
+// UpdateChannel handles topic/description changes and archiving
+func (h *ChannelHandler) UpdateChannel(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req service.UpdateChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	channel, err := h.channelService.UpdateChannel(c.Param("channel"), userID, req)
+	if err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Channel updated successfully",
+		"data":    channel,
+	})
+}
+
+// DeleteChannel handles channel deletion
+func (h *ChannelHandler) DeleteChannel(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	channelName := c.Param("channel")
+	if err := h.channelService.DeleteChannel(channelName, userID); err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.publishChannelDeleted(channelName)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Channel deleted successfully",
+	})
+}
+
+// JoinChannel handles joining a public channel
+func (h *ChannelHandler) JoinChannel(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	channelName := c.Param("channel")
+	member, err := h.channelService.JoinChannel(channelName, userID)
+	if err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.publishMemberAdded(channelName, member)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Joined channel successfully",
+		"data":    member,
+	})
+}
+
+// LeaveChannel handles leaving a channel
+func (h *ChannelHandler) LeaveChannel(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	channel, err := h.channelService.LeaveChannel(c.Param("channel"), userID)
+	if err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.publishMemberRemoved(channel, userID, "left")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Left channel successfully",
+	})
+}
+
+// GetMembers handles channel member list retrieval
+func (h *ChannelHandler) GetMembers(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	members, err := h.channelService.ListMembers(c.Param("channel"), userID)
+	if err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": members,
+	})
+}
+
+// InviteMember handles adding another user to a channel
+func (h *ChannelHandler) InviteMember(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req service.ChannelMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	channelName := c.Param("channel")
+	member, err := h.channelService.InviteMember(channelName, userID, req.UserID)
+	if err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.publishMemberAdded(channelName, member)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member added successfully",
+		"data":    member,
+	})
+}
+
+// KickMember handles removing another user from a channel
+func (h *ChannelHandler) KickMember(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	targetID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	channel, err := h.channelService.KickMember(c.Param("channel"), userID, uint(targetID))
+	if err != nil {
+		c.JSON(channelErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.publishMemberRemoved(channel, uint(targetID), "kicked")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Member removed successfully",
+	})
+}
+
+// publishMemberAdded notifies the channel that a member joined
+func (h *ChannelHandler) publishMemberAdded(channel string, member *service.ChannelMemberResponse) {
+	msg := ws.Message{
+		Type:    "member_added",
+		Channel: channel,
+		Data:    member,
+		User: ws.UserInfo{
+			ID:       member.UserID,
+			Username: member.Username,
+		},
+	}
+
+	if err := h.hub.PublishMessage(channel, msg); err != nil {
+		log.Printf("❌ Failed to publish member_added event: %v", err)
+	}
+}
+
+// publishChannelDeleted notifies the channel that it was deleted. Every hub
+// instance also unsubscribes all clients from it when it sees this event.
+func (h *ChannelHandler) publishChannelDeleted(channel string) {
+	msg := ws.Message{
+		Type:    "channel_deleted",
+		Channel: channel,
+	}
+
+	if err := h.hub.PublishMessage(channel, msg); err != nil {
+		log.Printf("❌ Failed to publish channel_deleted event: %v", err)
+	}
+}
+
+// publishMemberRemoved notifies the channel that a member is gone. Every hub
+// instance also unsubscribes that user's clients when it sees this event.
+func (h *ChannelHandler) publishMemberRemoved(channel *models.Channel, userID uint, reason string) {
+	msg := ws.Message{
+		Type:    "member_removed",
+		Channel: channel.Name,
+		Data: map[string]interface{}{
+			"user_id": userID,
+			"reason":  reason,
+		},
+	}
+
+	if err := h.hub.PublishMessage(channel.Name, msg); err != nil {
+		log.Printf("❌ Failed to publish member_removed event: %v", err)
+	}
+}
+
+// channelErrorStatus maps channel service errors to HTTP status codes
+func channelErrorStatus(err error) int {
+	switch err.Error() {
+	case "channel not found", "user not found", "user is not a member of this channel":
+		return http.StatusNotFound
+	case "unauthorized: only the channel creator can modify this channel",
+		"unauthorized: only the channel creator can remove members",
+		"access denied: channel is private",
+		"access denied: not a member of this channel",
+		"channel is archived":
+		return http.StatusForbidden
+	case "cannot remove the channel creator",
+		"the channel creator cannot leave a private channel":
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"chatapp/internal/middleware"
+	"chatapp/internal/ratelimit"
+	"chatapp/internal/service"
+	ws "chatapp/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConversationHandler struct {
+	conversationService *service.ConversationService
+	messageLimiter      *ratelimit.MessageLimiter
+	hub                 *ws.Hub
+}
+
+func NewConversationHandler(conversationService *service.ConversationService, messageLimiter *ratelimit.MessageLimiter, hub *ws.Hub) *ConversationHandler {
+	return &ConversationHandler{
+		conversationService: conversationService,
+		messageLimiter:      messageLimiter,
+		hub:                 hub,
+	}
+}
+
+// CreateConversation handles group conversation creation
+func (h *ConversationHandler) CreateConversation(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req service.CreateGroupConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	conversation, err := h.conversationService.CreateGroupConversation(userID, req)
+	if err != nil {
+		c.JSON(conversationErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Conversation created successfully",
+		"data":    conversation,
+	})
+}
+
+// GetConversations handles listing the user's group conversations
+func (h *ConversationHandler) GetConversations(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversations, err := h.conversationService.ListGroupConversations(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve conversations",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": conversations,
+	})
+}
+
+// GetMessages handles conversation history retrieval with pagination
+func (h *ConversationHandler) GetMessages(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversationID, ok := parseConversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	includeDeleted := c.Query("include_deleted") == "true"
+
+	messages, err := h.conversationService.GetConversationMessages(conversationID, userID, page, limit, includeDeleted)
+	if err != nil {
+		c.JSON(conversationErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": messages,
+	})
+}
+
+// SendMessage handles sending a message to a conversation
+func (h *ConversationHandler) SendMessage(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversationID, ok := parseConversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req service.SendConversationMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	key, err := h.conversationService.GetConversationKey(conversationID, userID)
+	if err != nil {
+		c.JSON(conversationErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if !allowWrite(c, h.messageLimiter, userID, key) {
+		return
+	}
+
+	sent, err := h.conversationService.SendConversationMessage(conversationID, userID, req)
+	if err != nil {
+		c.JSON(conversationErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishConversationMessage(sent); err != nil {
+		log.Printf("❌ Error publishing conversation message to Redis: %v", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Message sent successfully",
+		"data":    sent.Message,
+	})
+}
+
+// MarkRead handles moving the user's read position in a conversation
+func (h *ConversationHandler) MarkRead(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversationID, ok := parseConversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	// The body is optional: without a message ID everything is marked as read
+	var req service.MarkReadRequest
+	_ = c.ShouldBindJSON(&req)
+
+	if err := h.conversationService.MarkConversationRead(conversationID, userID, req.MessageID); err != nil {
+		c.JSON(conversationErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Conversation marked as read",
+	})
+}
+
+// AddParticipant handles adding a user to a group conversation
+func (h *ConversationHandler) AddParticipant(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversationID, ok := parseConversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req service.ParticipantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	change, err := h.conversationService.AddParticipant(conversationID, userID, req.UserID)
+	if err != nil {
+		c.JSON(conversationErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishParticipantEvent("participant_added", change); err != nil {
+		log.Printf("❌ Failed to publish participant_added event: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Participant added successfully",
+		"data":    change.User,
+	})
+}
+
+// RemoveParticipant handles leaving a group conversation or removing another participant
+func (h *ConversationHandler) RemoveParticipant(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversationID, ok := parseConversationIDParam(c)
+	if !ok {
+		return
+	}
+
+	targetID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	change, err := h.conversationService.RemoveParticipant(conversationID, userID, targetID)
+	if err != nil {
+		c.JSON(conversationErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishParticipantEvent("participant_left", change); err != nil {
+		log.Printf("❌ Failed to publish participant_left event: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Participant removed successfully",
+	})
+}
+
+// parseConversationIDParam reads the :id path parameter, writing a 400 response when invalid
+func parseConversationIDParam(c *gin.Context) (uint, bool) {
+	conversationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid conversation ID",
+		})
+		return 0, false
+	}
+	return uint(conversationID), true
+}
+
+// conversationErrorStatus maps conversation service errors to HTTP status codes
+func conversationErrorStatus(err error) int {
+	switch err.Error() {
+	case "conversation not found", "user not found", "user is not a participant":
+		return http.StatusNotFound
+	case "group conversations need between 3 and 8 participants",
+		"participants can only be changed in group conversations":
+		return http.StatusBadRequest
+	case "conversation is full", "user is already a participant":
+		return http.StatusConflict
+	case "unauthorized: only the conversation creator can remove other participants":
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"chatapp/internal/middleware"
+	"chatapp/internal/models"
+	"chatapp/internal/ratelimit"
+	"chatapp/internal/service"
+	ws "chatapp/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DirectMessageHandler struct {
+	conversationService *service.ConversationService
+	messageLimiter      *ratelimit.MessageLimiter
+	hub                 *ws.Hub
+}
+
+func NewDirectMessageHandler(conversationService *service.ConversationService, messageLimiter *ratelimit.MessageLimiter, hub *ws.Hub) *DirectMessageHandler {
+	return &DirectMessageHandler{
+		conversationService: conversationService,
+		messageLimiter:      messageLimiter,
+		hub:                 hub,
+	}
+}
+
+// GetConversations handles listing the user's direct message conversations
+func (h *DirectMessageHandler) GetConversations(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	conversations, err := h.conversationService.ListDirectConversations(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve conversations",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": conversations,
+	})
+}
+
+// GetMessages handles direct message history retrieval with pagination
+func (h *DirectMessageHandler) GetMessages(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	otherUserID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	includeDeleted := c.Query("include_deleted") == "true"
+
+	messages, err := h.conversationService.GetDirectMessages(userID, otherUserID, page, limit, includeDeleted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve messages",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": messages,
+	})
+}
+
+// SendMessage handles sending a direct message
+func (h *DirectMessageHandler) SendMessage(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	otherUserID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req service.SendConversationMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !allowWrite(c, h.messageLimiter, userID, models.DirectConversationKey(userID, otherUserID)) {
+		return
+	}
+
+	sent, err := h.conversationService.SendDirectMessage(userID, otherUserID, req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "user not found":
+			status = http.StatusNotFound
+		case "cannot start a conversation with yourself":
+			status = http.StatusBadRequest
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishConversationMessage(sent); err != nil {
+		log.Printf("❌ Error publishing direct message to Redis: %v", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Message sent successfully",
+		"data":    sent.Message,
+	})
+}
+
+// MarkRead handles moving the user's read position in a direct message conversation
+func (h *DirectMessageHandler) MarkRead(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	otherUserID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	// The body is optional: without a message ID everything is marked as read
+	var req service.MarkReadRequest
+	_ = c.ShouldBindJSON(&req)
+
+	if err := h.conversationService.MarkDirectConversationRead(userID, otherUserID, req.MessageID); err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "conversation not found" {
+			status = http.StatusNotFound
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Conversation marked as read",
+	})
+}
+
+// parseUserIDParam reads the :user_id path parameter, writing a 400 response when invalid
+func parseUserIDParam(c *gin.Context) (uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return 0, false
+	}
+	return uint(userID), true
+}
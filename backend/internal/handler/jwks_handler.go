@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"net/http"
+
+	"chatapp/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSHandler struct {
+	keyService *service.KeyService
+}
+
+func NewJWKSHandler(keyService *service.KeyService) *JWKSHandler {
+	return &JWKSHandler{
+		keyService: keyService,
+	}
+}
+
+// GetJWKS returns the public keys verifying access tokens, so other services
+// can verify them. Keys are published well before they start signing, so
+// caching the set briefly is safe.
+func (h *JWKSHandler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keyService.JWKS())
+}
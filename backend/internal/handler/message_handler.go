@@ -1,21 +1,29 @@
 package handler
 
 import (
+	"log"
+	"math"
 	"net/http"
 	"strconv"
 
 	"chatapp/internal/middleware"
+	"chatapp/internal/ratelimit"
 	"chatapp/internal/service"
+	ws "chatapp/internal/websocket"
 	"github.com/gin-gonic/gin"
 )
 
 type MessageHandler struct {
 	messageService *service.MessageService
+	messageLimiter *ratelimit.MessageLimiter
+	hub            *ws.Hub
 }
 
-func NewMessageHandler(messageService *service.MessageService) *MessageHandler {
+func NewMessageHandler(messageService *service.MessageService, messageLimiter *ratelimit.MessageLimiter, hub *ws.Hub) *MessageHandler {
 	return &MessageHandler{
 		messageService: messageService,
+		messageLimiter: messageLimiter,
+		hub:            hub,
 	}
 }
 
@@ -38,46 +46,149 @@ func (h *MessageHandler) CreateMessage(c *gin.Context) {
 		return
 	}
 
-	message, err := h.messageService.CreateMessage(userID, req)
-	if err != nil {
-		status := http.StatusInternalServerError
-		if err.Error() == "user not found" {
-			status = http.StatusNotFound
+	// A retried request returns the message created the first time without
+	// counting against the rate limit
+	if message, found, err := h.messageService.FindSentMessage(userID, req); found {
+		if err != nil {
+			c.JSON(createMessageErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			return
 		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Message already created",
+			"data":    message,
+		})
+		return
+	}
 
-		c.JSON(status, gin.H{
+	// Limit with the same bucket as sends over the WebSocket
+	if !allowWrite(c, h.messageLimiter, userID, req.Channel) {
+		return
+	}
+
+	message, created, err := h.messageService.CreateMessage(userID, req)
+	if err != nil {
+		c.JSON(createMessageErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	// A retried request returns the message created the first time
+	if !created {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Message already created",
+			"data":    message,
+		})
+		return
+	}
+
+	// Deliver to connected clients the same way as messages sent over WebSocket
+	if err := h.hub.PublishChatMessage(message); err != nil {
+		log.Printf("❌ Error publishing message to Redis: %v", err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Message created successfully",
 		"data":    message,
 	})
 }
 
+// allowMessageWrite takes a token for a write to an existing message from
+// the bucket of the message's channel or conversation. Unknown messages pass
+// so the write itself reports them.
+func (h *MessageHandler) allowMessageWrite(c *gin.Context, userID, messageID uint) bool {
+	channel, err := h.messageService.GetMessageChannel(messageID)
+	if err != nil {
+		return true
+	}
+	return allowWrite(c, h.messageLimiter, userID, channel)
+}
+
+// allowWrite takes a token for a write of the user in a channel or
+// conversation, answering 429 with Retry-After when the bucket is empty
+func allowWrite(c *gin.Context, limiter *ratelimit.MessageLimiter, userID uint, channel string) bool {
+	result := limiter.AllowMessage(c.Request.Context(), userID, channel)
+	if result.Allowed {
+		return true
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":          "rate limit exceeded",
+		"retry_after_ms": result.RetryAfter.Milliseconds(),
+	})
+	return false
+}
+
 // GetMessages handles message retrieval with pagination
 func (h *MessageHandler) GetMessages(c *gin.Context) {
 	// Get channel from query parameter (default: general)
 	channel := c.DefaultQuery("channel", "general")
 
-	// Get pagination parameters
-	pageStr := c.DefaultQuery("page", "1")
-	limitStr := c.DefaultQuery("limit", "50")
-
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		page = 1
+	// Offset paging was replaced by cursors; page=1 is the newest page either way
+	if page, err := strconv.Atoi(c.DefaultQuery("page", "1")); err == nil && page > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "page is no longer supported, use cursor",
+		})
+		return
 	}
 
+	// Get pagination parameters
+	limitStr := c.DefaultQuery("limit", "50")
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil || limit < 1 || limit > 100 {
 		limit = 50
 	}
 
-	messages, err := h.messageService.GetMessagesByChannel(channel, page, limit)
+	// Keyset pagination: an opaque cursor from a previous page, or a message ID to page from
+	req := service.MessagePageRequest{
+		Cursor:       c.Query("cursor"),
+		Limit:        limit,
+		IncludeTotal: c.Query("include_total") == "true",
+	}
+	for param, target := range map[string]*uint{
+		"before_id": &req.BeforeID,
+		"after_id":  &req.AfterID,
+		"around_id": &req.AroundID,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid " + param,
+			})
+			return
+		}
+		*target = uint(id)
+	}
+
+	// Anonymous requests only see public channels
+	userID, _ := middleware.GetUserID(c)
+
+	// Tombstones let clients reconcile messages deleted while they were away
+	req.IncludeDeleted = c.Query("include_deleted") == "true"
+
+	messages, err := h.messageService.GetMessagesByChannel(channel, userID, req)
 	if err != nil {
+		if status, ok := channelAccessErrorStatus(err); ok {
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err.Error() == "invalid cursor" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to retrieve messages",
 		})
@@ -101,8 +212,19 @@ func (h *MessageHandler) GetRecentMessages(c *gin.Context) {
 		limit = 50
 	}
 
-	messages, err := h.messageService.GetRecentMessagesByChannel(channel, limit)
+	userID, _ := middleware.GetUserID(c)
+
+	includeDeleted := c.Query("include_deleted") == "true"
+
+	messages, err := h.messageService.GetRecentMessagesByChannel(channel, userID, limit, includeDeleted)
 	if err != nil {
+		if status, ok := channelAccessErrorStatus(err); ok {
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to retrieve recent messages",
 		})
@@ -116,7 +238,10 @@ func (h *MessageHandler) GetRecentMessages(c *gin.Context) {
 
 // GetChannels handles channel list retrieval
 func (h *MessageHandler) GetChannels(c *gin.Context) {
-	channels, err := h.messageService.GetAvailableChannels()
+	includeArchived := c.Query("include_archived") == "true"
+	userID, _ := middleware.GetUserID(c)
+
+	channels, err := h.messageService.GetAvailableChannels(userID, includeArchived)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to retrieve channels",
@@ -129,6 +254,45 @@ func (h *MessageHandler) GetChannels(c *gin.Context) {
 	})
 }
 
+// MarkChannelRead handles moving the user's read position in a channel
+func (h *MessageHandler) MarkChannelRead(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	// The body is optional: without a message ID everything is marked as read
+	var req service.MarkReadRequest
+	_ = c.ShouldBindJSON(&req)
+
+	position, err := h.messageService.MarkChannelRead(c.Param("channel"), userID, req.MessageID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if accessStatus, ok := channelAccessErrorStatus(err); ok {
+			status = accessStatus
+		} else if err.Error() == "message not found" {
+			status = http.StatusNotFound
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishReadPosition(userID, position); err != nil {
+		log.Printf("❌ Error publishing channel_read to Redis: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Channel marked as read",
+		"data":    position,
+	})
+}
+
 // GetChannelInfo handles channel information retrieval
 func (h *MessageHandler) GetChannelInfo(c *gin.Context) {
 	channel := c.Param("channel")
@@ -139,8 +303,17 @@ func (h *MessageHandler) GetChannelInfo(c *gin.Context) {
 		return
 	}
 
-	channelInfo, err := h.messageService.GetChannelInfo(channel)
+	userID, _ := middleware.GetUserID(c)
+
+	channelInfo, err := h.messageService.GetChannelInfo(channel, userID)
 	if err != nil {
+		if status, ok := channelAccessErrorStatus(err); ok {
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to retrieve channel information",
 		})
@@ -152,6 +325,243 @@ func (h *MessageHandler) GetChannelInfo(c *gin.Context) {
 	})
 }
 
+// EditMessage handles editing the content of a message
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid message ID",
+		})
+		return
+	}
+
+	var req service.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !h.allowMessageWrite(c, userID, uint(messageID)) {
+		return
+	}
+
+	message, err := h.messageService.EditMessage(uint(messageID), userID, req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "message not found":
+			status = http.StatusNotFound
+		case "unauthorized: can only edit your own messages",
+			"access denied: not a member of this channel",
+			"channel is archived":
+			status = http.StatusForbidden
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishMessageEvent("message_updated", message); err != nil {
+		log.Printf("❌ Error publishing message update to Redis: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Message updated successfully",
+		"data":    message,
+	})
+}
+
+// GetThread handles retrieval of a message's thread replies with pagination
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid message ID",
+		})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	includeDeleted := c.Query("include_deleted") == "true"
+
+	thread, err := h.messageService.GetThread(uint(messageID), userID, page, limit, includeDeleted)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if s, ok := channelAccessErrorStatus(err); ok {
+			status = s
+		} else if err.Error() == "message not found" {
+			status = http.StatusNotFound
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": thread,
+	})
+}
+
+// AddReaction handles adding the user's emoji reaction to a message
+func (h *MessageHandler) AddReaction(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid message ID",
+		})
+		return
+	}
+
+	var req service.ReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !h.allowMessageWrite(c, userID, uint(messageID)) {
+		return
+	}
+
+	message, update, err := h.messageService.AddReaction(uint(messageID), userID, req)
+	if err != nil {
+		c.JSON(reactionErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishReactionEvent("reaction_added", message, update); err != nil {
+		log.Printf("❌ Error publishing reaction to Redis: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Reaction added successfully",
+		"data":    update,
+	})
+}
+
+// RemoveReaction handles removing the user's emoji reaction from a message
+func (h *MessageHandler) RemoveReaction(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid message ID",
+		})
+		return
+	}
+
+	emoji := c.Param("emoji")
+	if emoji == "" || len(emoji) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid emoji",
+		})
+		return
+	}
+
+	if !h.allowMessageWrite(c, userID, uint(messageID)) {
+		return
+	}
+
+	message, update, err := h.messageService.RemoveReaction(uint(messageID), userID, service.ReactionRequest{Emoji: emoji})
+	if err != nil {
+		c.JSON(reactionErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishReactionEvent("reaction_removed", message, update); err != nil {
+		log.Printf("❌ Error publishing reaction to Redis: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Reaction removed successfully",
+		"data":    update,
+	})
+}
+
+// GetMessageRevisions handles retrieval of a message's edit history
+func (h *MessageHandler) GetMessageRevisions(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid message ID",
+		})
+		return
+	}
+
+	revisions, err := h.messageService.GetMessageRevisions(uint(messageID), userID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if s, ok := channelAccessErrorStatus(err); ok {
+			status = s
+		} else if err.Error() == "message not found" {
+			status = http.StatusNotFound
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": revisions,
+	})
+}
+
 // DeleteMessage handles message deletion
 func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
@@ -171,7 +581,7 @@ func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 		return
 	}
 
-	err = h.messageService.DeleteMessage(uint(messageID), userID)
+	tombstone, err := h.messageService.DeleteMessage(uint(messageID), userID)
 	if err != nil {
 		status := http.StatusInternalServerError
 		if err.Error() == "message not found" {
@@ -186,7 +596,50 @@ func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 		return
 	}
 
+	if err := h.hub.PublishMessageEvent("message_deleted", tombstone); err != nil {
+		log.Printf("❌ Error publishing message deletion to Redis: %v", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Message deleted successfully",
 	})
-}
\ No newline at end of file
+}
+
+// createMessageErrorStatus maps message creation errors to HTTP status codes
+func createMessageErrorStatus(err error) int {
+	switch err.Error() {
+	case "user not found", "channel not found", "parent message not found":
+		return http.StatusNotFound
+	case "channel is archived", "access denied: not a member of this channel":
+		return http.StatusForbidden
+	case "cannot reply to a thread reply":
+		return http.StatusBadRequest
+	case "client_msg_id already used for another message":
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// channelAccessErrorStatus maps channel lookup and access errors to HTTP status codes
+func channelAccessErrorStatus(err error) (int, bool) {
+	switch err.Error() {
+	case "channel not found":
+		return http.StatusNotFound, true
+	case "access denied: not a member of this channel":
+		return http.StatusForbidden, true
+	}
+	return 0, false
+}
+
+// reactionErrorStatus maps reaction errors to HTTP status codes
+func reactionErrorStatus(err error) int {
+	if status, ok := channelAccessErrorStatus(err); ok {
+		return status
+	}
+
+	switch err.Error() {
+	case "message not found", "user not found":
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
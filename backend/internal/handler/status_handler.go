@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"chatapp/internal/middleware"
+	"chatapp/internal/service"
+	ws "chatapp/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StatusHandler struct {
+	statusService *service.StatusService
+	hub           *ws.Hub
+}
+
+func NewStatusHandler(statusService *service.StatusService, hub *ws.Hub) *StatusHandler {
+	return &StatusHandler{
+		statusService: statusService,
+		hub:           hub,
+	}
+}
+
+// GetStatus handles reading a user's status
+func (h *StatusHandler) GetStatus(c *gin.Context) {
+	userID, ok := parseUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.statusService.GetStatus(userID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err.Error() == "user not found" {
+			code = http.StatusNotFound
+		}
+
+		c.JSON(code, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": status,
+	})
+}
+
+// SetStatus handles setting the user's status and custom status text
+func (h *StatusHandler) SetStatus(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req service.SetStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	change, err := h.statusService.SetStatus(userID, req)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err.Error() == "expiry must be in the future" {
+			code = http.StatusBadRequest
+		}
+
+		c.JSON(code, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishStatusChange(change); err != nil {
+		log.Printf("❌ Error publishing status_changed to Redis: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Status updated successfully",
+		"data":    change.Status,
+	})
+}
+
+// ClearStatus handles resetting the user's status to active without custom text
+func (h *StatusHandler) ClearStatus(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	change, err := h.statusService.ClearStatus(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.hub.PublishStatusChange(change); err != nil {
+		log.Printf("❌ Error publishing status_changed to Redis: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Status cleared successfully",
+		"data":    change.Status,
+	})
+}
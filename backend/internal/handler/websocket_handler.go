@@ -36,11 +36,12 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 
 	username, _ := middleware.GetUsername(c)
 	email, _ := middleware.GetEmail(c)
+	sessionID, _ := middleware.GetSessionID(c)
 
 	// Upgrade HTTP connection to WebSocket
 	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{
 		OriginPatterns: []string{"*"}, // Allow all origins for development
-		Subprotocols:   []string{"chat"},
+		Subprotocols:   []string{ws.Subprotocol, ws.LegacySubprotocol},
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -50,7 +51,7 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 	}
 
 	// Create new client
-	client := ws.NewClient(h.hub, conn, userID, username, email, c)
+	client := ws.NewClient(h.hub, conn, userID, username, email, sessionID, c)
 
 	// Run client (this will block until connection is closed)
 	client.Run()
@@ -59,12 +60,11 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 // GetConnectedUsers returns the list of connected users
 func (h *WebSocketHandler) GetConnectedUsers(c *gin.Context) {
 	users := h.hub.GetConnectedUsers()
-	count := h.hub.GetClientCount()
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": gin.H{
 			"users": users,
-			"count": count,
+			"count": len(users),
 		},
 	})
 }
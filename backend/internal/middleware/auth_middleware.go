@@ -42,8 +42,15 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		// Validate token
-		claims, err := m.authService.ValidateToken(tokenString)
+		// Validate token and its session
+		claims, err := m.authService.Authenticate(tokenString, c.ClientIP())
+		if err != nil && err.Error() == "session revoked" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Session revoked",
+			})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			// Debug: Log the exact error
 			fmt.Printf("JWT Validation Error: %v\n", err)
@@ -66,6 +73,7 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("email", claims.Email)
+		c.Set("session_id", claims.SessionID)
 
 		c.Next()
 	}
@@ -91,7 +99,7 @@ func (m *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := m.authService.ValidateToken(tokenString)
+		claims, err := m.authService.Authenticate(tokenString, c.ClientIP())
 		if err != nil {
 			c.Next()
 			return
@@ -101,6 +109,7 @@ func (m *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("email", claims.Email)
+		c.Set("session_id", claims.SessionID)
 
 		c.Next()
 	}
@@ -138,3 +147,14 @@ func GetEmail(c *gin.Context) (string, bool) {
 	emailStr, ok := email.(string)
 	return emailStr, ok
 }
+
+// GetSessionID helper function to get the session ID from context
+func GetSessionID(c *gin.Context) (string, bool) {
+	sessionID, exists := c.Get("session_id")
+	if !exists {
+		return "", false
+	}
+
+	id, ok := sessionID.(string)
+	return id, ok
+}
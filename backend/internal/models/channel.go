@@ -0,0 +1,39 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Channel visibility values
+const (
+	ChannelVisibilityPublic  = "public"
+	ChannelVisibilityPrivate = "private"
+)
+
+// Channel represents a named chat room
+type Channel struct {
+	ID          uint           `gorm:"primarykey" json:"id"`
+	Name        string         `gorm:"not null;size:50;uniqueIndex:idx_channels_name,where:deleted_at IS NULL" json:"name"`
+	Topic       string         `gorm:"size:250" json:"topic"`
+	Description string         `gorm:"type:text" json:"description"`
+	CreatorID   *uint          `gorm:"index" json:"creator_id"` // システム作成チャンネルはNULL
+	Visibility  string         `gorm:"not null;size:10;default:'public'" json:"visibility"`
+	IsArchived  bool           `gorm:"not null;default:false" json:"is_archived"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// リレーション
+	Creator *User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
+}
+
+// IsPrivate reports whether the channel is restricted to its members
+func (c *Channel) IsPrivate() bool {
+	return c.Visibility == ChannelVisibilityPrivate
+}
+
+// TableName specifies the table name for Channel model
+func (Channel) TableName() string {
+	return "channels"
+}
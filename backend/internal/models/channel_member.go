@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// Channel member roles
+const (
+	ChannelRoleOwner  = "owner"
+	ChannelRoleMember = "member"
+)
+
+// ChannelMember represents a user's membership in a channel
+type ChannelMember struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	ChannelID uint      `gorm:"not null;uniqueIndex:idx_channel_members_channel_user" json:"channel_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_channel_members_channel_user;index" json:"user_id"`
+	Role      string    `gorm:"not null;size:20;default:'member'" json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// リレーション
+	Channel Channel `gorm:"foreignKey:ChannelID;constraint:OnDelete:CASCADE" json:"-"`
+	User    User    `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// TableName specifies the table name for ChannelMember model
+func (ChannelMember) TableName() string {
+	return "channel_members"
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// ChannelRead is a user's read position in a channel. Readers of public
+// channels need not be members, so it is kept apart from ChannelMember.
+type ChannelRead struct {
+	ID                uint      `gorm:"primarykey" json:"id"`
+	ChannelID         uint      `gorm:"not null;uniqueIndex:idx_channel_reads_channel_user" json:"channel_id"`
+	UserID            uint      `gorm:"not null;uniqueIndex:idx_channel_reads_channel_user;index" json:"user_id"`
+	LastReadMessageID uint      `gorm:"not null;default:0" json:"last_read_message_id"`
+	UpdatedAt         time.Time `json:"updated_at"`
+
+	// リレーション
+	Channel Channel `gorm:"foreignKey:ChannelID;constraint:OnDelete:CASCADE" json:"-"`
+	User    User    `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// TableName specifies the table name for ChannelRead model
+func (ChannelRead) TableName() string {
+	return "channel_reads"
+}
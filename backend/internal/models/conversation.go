@@ -0,0 +1,63 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Conversation kinds
+const (
+	ConversationKindDirect = "dm"
+	ConversationKindGroup  = "group"
+)
+
+// Conversation represents a private conversation between users outside of channels
+type Conversation struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Key       string    `gorm:"uniqueIndex;not null;size:50" json:"key"`
+	Kind      string    `gorm:"not null;size:10;default:'dm'" json:"kind"`
+	CreatorID *uint     `gorm:"index" json:"creator_id,omitempty"` // グループ会話の作成者
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// リレーション
+	Participants []ConversationParticipant `gorm:"foreignKey:ConversationID" json:"participants,omitempty"`
+}
+
+// TableName specifies the table name for Conversation model
+func (Conversation) TableName() string {
+	return "conversations"
+}
+
+// DirectConversationKey returns the key shared by both users of a 1:1 conversation,
+// independent of which user started it
+func DirectConversationKey(userA, userB uint) string {
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	return fmt.Sprintf("dm:%d:%d", userA, userB)
+}
+
+// GroupConversationKey returns a new unique key for a group conversation
+func GroupConversationKey() string {
+	return "group:" + uuid.New().String()
+}
+
+// ConversationParticipant represents a user taking part in a conversation
+type ConversationParticipant struct {
+	ID                uint      `gorm:"primarykey" json:"id"`
+	ConversationID    uint      `gorm:"not null;uniqueIndex:idx_conversation_participants_conversation_user" json:"conversation_id"`
+	UserID            uint      `gorm:"not null;uniqueIndex:idx_conversation_participants_conversation_user;index" json:"user_id"`
+	LastReadMessageID uint      `gorm:"not null;default:0" json:"last_read_message_id"`
+	CreatedAt         time.Time `json:"created_at"`
+
+	// リレーション
+	User User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// TableName specifies the table name for ConversationParticipant model
+func (ConversationParticipant) TableName() string {
+	return "conversation_participants"
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// Reasons a login attempt failed
+const (
+	LoginFailureInvalidPassword = "invalid_password"
+	LoginFailureUnknownEmail    = "unknown_email"
+	LoginFailureLocked          = "locked"
+)
+
+// LoginAttempt is the audit record of a failed login attempt
+type LoginAttempt struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Email     string    `gorm:"not null;size:255;index" json:"email"`
+	IP        string    `gorm:"not null;size:45;index" json:"ip"`
+	UserID    *uint     `gorm:"index" json:"user_id,omitempty"`
+	Reason    string    `gorm:"not null;size:32" json:"reason"`
+	UserAgent string    `gorm:"size:255" json:"user_agent"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	// リレーション
+	User *User `gorm:"foreignKey:UserID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+// TableName specifies the table name for LoginAttempt model
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
@@ -7,16 +7,32 @@ import (
 
 // Message represents a chat message
 type Message struct {
-	ID        uint           `gorm:"primarykey" json:"id"`
-	UserID    uint           `gorm:"not null;index" json:"user_id"`
+	ID        uint           `gorm:"primarykey;index:idx_messages_channel_id_cursor,priority:2" json:"id"`
+	UserID    uint           `gorm:"not null;index;uniqueIndex:idx_messages_user_client_msg,priority:1" json:"user_id"`
 	Content   string         `gorm:"not null;type:text" json:"content"`
-	Channel   string         `gorm:"not null;size:50;index;default:'general'" json:"channel"`
+	Channel   string         `gorm:"not null;size:50;default:'general'" json:"channel"`
+	ChannelID *uint          `gorm:"index:idx_messages_channel_id_cursor,priority:1" json:"channel_id,omitempty"`
+	EditedAt  *time.Time     `json:"edited_at,omitempty"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// 会話（DM）内のメッセージの場合はChannelに会話キーが入る
+	ConversationID *uint `gorm:"index" json:"conversation_id,omitempty"`
+
+	// スレッド（返信の場合はParentIDに親メッセージのIDが入る）
+	ParentID    *uint      `gorm:"index" json:"parent_id,omitempty"`
+	ReplyCount  int        `gorm:"not null;default:0" json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty"`
+
+	// クライアントが生成したID（再送時の重複防止、ユーザーごとに一意）
+	ClientMsgID *string `gorm:"size:64;uniqueIndex:idx_messages_user_client_msg,priority:2" json:"client_msg_id,omitempty"`
 	
 	// リレーション
-	User User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	User         User          `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	ChannelRef   *Channel      `gorm:"foreignKey:ChannelID" json:"-"`
+	Conversation *Conversation `gorm:"foreignKey:ConversationID" json:"-"`
+	Parent       *Message      `gorm:"foreignKey:ParentID" json:"-"`
 }
 
 // TableName specifies the table name for Message model
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// MessageRevision stores the content a message had before an edit
+type MessageRevision struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	MessageID uint      `gorm:"not null;index" json:"message_id"`
+	Content   string    `gorm:"not null;type:text" json:"content"`
+	CreatedAt time.Time `json:"created_at"` // 編集された日時
+
+	// リレーション
+	Message Message `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName specifies the table name for MessageRevision model
+func (MessageRevision) TableName() string {
+	return "message_revisions"
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// Reaction represents an emoji reaction left by a user on a message
+type Reaction struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	MessageID uint      `gorm:"not null;uniqueIndex:idx_reactions_message_user_emoji" json:"message_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_reactions_message_user_emoji;index" json:"user_id"`
+	Emoji     string    `gorm:"not null;size:64;uniqueIndex:idx_reactions_message_user_emoji" json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// リレーション
+	Message Message `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"-"`
+	User    User    `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// TableName specifies the table name for Reaction model
+func (Reaction) TableName() string {
+	return "reactions"
+}
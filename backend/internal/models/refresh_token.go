@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken is a long-lived opaque token exchanged for new access tokens.
+// Only its SHA-256 hash is stored. Each use rotates it: the token is marked
+// used and a new one of the same family is issued, so a used token that
+// shows up again reveals theft and revokes the family. A family belongs to
+// one session; FamilyID is the session ID.
+type RefreshToken struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	FamilyID  string     `gorm:"not null;size:36;index" json:"family_id"`
+	TokenHash string     `gorm:"not null;size:64;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	// リレーション
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName specifies the table name for RefreshToken model
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
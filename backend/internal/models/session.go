@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a signed-in device. It starts at login, is kept alive by its
+// refresh tokens (whose FamilyID is the session ID) and ends when revoked.
+type Session struct {
+	ID         string     `gorm:"primarykey;size:36" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	DeviceName string     `gorm:"size:100" json:"device_name"`
+	UserAgent  string     `gorm:"size:255" json:"user_agent"`
+	IP         string     `gorm:"size:45" json:"ip"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// リレーション
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName specifies the table name for Session model
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// SigningKey is an asymmetric key signing access tokens. A key signs from
+// ActiveFrom until RetiresAt, when the next key takes over, and is published
+// for verification until ExpiresAt, once the last token it signed expired.
+// PrivateKey is PKCS #8 PEM.
+type SigningKey struct {
+	KID        string    `gorm:"primarykey;size:36" json:"kid"`
+	Algorithm  string    `gorm:"not null;size:16" json:"algorithm"`
+	PrivateKey string    `gorm:"not null;type:text" json:"-"`
+	ActiveFrom time.Time `gorm:"not null" json:"active_from"`
+	RetiresAt  time.Time `gorm:"not null" json:"retires_at"`
+	ExpiresAt  time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for SigningKey model
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
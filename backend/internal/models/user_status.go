@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// User status values chosen by the user
+const (
+	UserStatusActive = "active"
+	UserStatusAway   = "away"
+	UserStatusDND    = "dnd"
+)
+
+// UserStatus holds the status a user set for themselves and whether they are
+// idle. A status with ExpiresAt is reset once that time has passed.
+type UserStatus struct {
+	UserID    uint       `gorm:"primarykey;autoIncrement:false" json:"user_id"`
+	Status    string     `gorm:"not null;size:20;default:'active'" json:"status"`
+	Text      string     `gorm:"size:100" json:"text"`
+	Emoji     string     `gorm:"size:64" json:"emoji"`
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"`
+	Idle      bool       `gorm:"not null;default:false" json:"idle"`
+	UpdatedAt time.Time  `json:"updated_at"`
+
+	// リレーション
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// TableName specifies the table name for UserStatus model
+func (UserStatus) TableName() string {
+	return "user_statuses"
+}
+
+// IsExpired reports whether the status expired at the given time
+func (s *UserStatus) IsExpired(now time.Time) bool {
+	return s.ExpiresAt != nil && !s.ExpiresAt.After(now)
+}
+
+// Effective returns the status shown to other users: dnd and away as chosen,
+// otherwise away while idle
+func (s *UserStatus) Effective() string {
+	switch {
+	case s.Status == UserStatusDND:
+		return UserStatusDND
+	case s.Status == UserStatusAway || s.Idle:
+		return UserStatusAway
+	default:
+		return UserStatusActive
+	}
+}
@@ -0,0 +1,194 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// LockoutPolicy decides how long a key is locked after consecutive failures.
+// From BackoffAfter failures on, each failure locks the key for an
+// exponentially growing delay starting at BaseDelay; from LockoutAfter
+// failures on, for LockoutDuration. Failures are forgotten after Window
+// without a failure.
+type LockoutPolicy struct {
+	BackoffAfter    int
+	LockoutAfter    int
+	BaseDelay       time.Duration
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// LockFor returns how long a key is locked after the given number of failures
+func (p LockoutPolicy) LockFor(failures int) time.Duration {
+	switch {
+	case failures >= p.LockoutAfter:
+		return p.LockoutDuration
+	case failures >= p.BackoffAfter:
+		delay := p.BaseDelay << (failures - p.BackoffAfter)
+		if delay > p.LockoutDuration || delay <= 0 {
+			return p.LockoutDuration
+		}
+		return delay
+	}
+	return 0
+}
+
+// AttemptTracker counts consecutive failures per key and locks keys
+// according to a policy
+type AttemptTracker interface {
+	// LockedUntil returns when the lock of a key ends, or the zero time
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+
+	// Fail records a failure and returns the resulting lock end, if any
+	Fail(ctx context.Context, key string, policy LockoutPolicy) (time.Time, error)
+
+	// Reset forgets the failures of a key
+	Reset(ctx context.Context, key string) error
+}
+
+// RedisAttempts tracks attempts in Redis so that every instance enforces
+// the same locks
+type RedisAttempts struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisAttempts creates an attempt tracker storing failures under
+// "attempts:<key>" and locks under "attempts_locked:<key>"
+func NewRedisAttempts(client *redis.Client) *RedisAttempts {
+	return &RedisAttempts{
+		client: client,
+		prefix: "attempts",
+	}
+}
+
+func (r *RedisAttempts) failuresKey(key string) string {
+	return r.prefix + ":" + key
+}
+
+func (r *RedisAttempts) lockedKey(key string) string {
+	return r.prefix + "_locked:" + key
+}
+
+// LockedUntil returns when the lock of a key ends, or the zero time
+func (r *RedisAttempts) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	value, err := r.client.Get(ctx, r.lockedKey(key)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	until, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(until), nil
+}
+
+// Fail records a failure and returns the resulting lock end, if any
+func (r *RedisAttempts) Fail(ctx context.Context, key string, policy LockoutPolicy) (time.Time, error) {
+	pipe := r.client.TxPipeline()
+	failures := pipe.Incr(ctx, r.failuresKey(key))
+	pipe.PExpire(ctx, r.failuresKey(key), policy.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return time.Time{}, err
+	}
+
+	lock := policy.LockFor(int(failures.Val()))
+	if lock == 0 {
+		return time.Time{}, nil
+	}
+
+	until := time.Now().Add(lock)
+	if err := r.client.Set(ctx, r.lockedKey(key), until.UnixMilli(), lock).Err(); err != nil {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
+// Reset forgets the failures of a key
+func (r *RedisAttempts) Reset(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.failuresKey(key), r.lockedKey(key)).Err()
+}
+
+// MemoryAttempts tracks attempts in process memory, for single-node deployments
+type MemoryAttempts struct {
+	mutex    sync.Mutex
+	attempts map[string]*memoryAttempts
+}
+
+type memoryAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	window      time.Duration
+}
+
+// NewMemoryAttempts creates an in-process attempt tracker
+func NewMemoryAttempts() *MemoryAttempts {
+	return &MemoryAttempts{
+		attempts: make(map[string]*memoryAttempts),
+	}
+}
+
+// LockedUntil returns when the lock of a key ends, or the zero time
+func (m *MemoryAttempts) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, ok := m.attempts[key]
+	if !ok || !entry.lockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return entry.lockedUntil, nil
+}
+
+// Fail records a failure and returns the resulting lock end, if any
+func (m *MemoryAttempts) Fail(ctx context.Context, key string, policy LockoutPolicy) (time.Time, error) {
+	now := time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sweep(now)
+
+	entry, ok := m.attempts[key]
+	if !ok {
+		entry = &memoryAttempts{}
+		m.attempts[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+	entry.window = policy.Window
+
+	lock := policy.LockFor(entry.failures)
+	if lock == 0 {
+		return time.Time{}, nil
+	}
+	entry.lockedUntil = now.Add(lock)
+	return entry.lockedUntil, nil
+}
+
+// Reset forgets the failures of a key
+func (m *MemoryAttempts) Reset(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+// sweep drops the entries whose failures are forgotten and whose lock ended
+func (m *MemoryAttempts) sweep(now time.Time) {
+	for key, entry := range m.attempts {
+		if now.Sub(entry.lastFailure) > entry.window && !entry.lockedUntil.After(now) {
+			delete(m.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newMiniredis starts an in-process Redis server and a client for it
+func newMiniredis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return srv, client
+}
+
+var testLockout = LockoutPolicy{
+	BackoffAfter:    3,
+	LockoutAfter:    6,
+	BaseDelay:       time.Second,
+	LockoutDuration: time.Minute,
+	Window:          time.Hour,
+}
+
+func TestLockoutPolicyLockFor(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: time.Second},
+		{failures: 4, want: 2 * time.Second},
+		{failures: 5, want: 4 * time.Second},
+		{failures: 6, want: time.Minute},
+		{failures: 100, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := testLockout.LockFor(tt.failures); got != tt.want {
+			t.Errorf("LockFor(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// The backoff never exceeds the lockout, even when the shift overflows
+	long := LockoutPolicy{BackoffAfter: 1, LockoutAfter: 1000, BaseDelay: time.Second, LockoutDuration: time.Minute}
+	for _, failures := range []int{7, 64, 200} {
+		if got := long.LockFor(failures); got != time.Minute {
+			t.Errorf("LockFor(%d) = %v, want the lockout duration", failures, got)
+		}
+	}
+}
+
+func TestAttemptTrackers(t *testing.T) {
+	trackers := map[string]func(t *testing.T) AttemptTracker{
+		"memory": func(t *testing.T) AttemptTracker { return NewMemoryAttempts() },
+		"redis": func(t *testing.T) AttemptTracker {
+			_, client := newMiniredis(t)
+			return NewRedisAttempts(client)
+		},
+	}
+
+	tests := []struct {
+		name       string
+		failures   int
+		reset      bool
+		wantLocked bool
+		wantLock   time.Duration
+	}{
+		{name: "below the backoff", failures: 2},
+		{name: "backoff", failures: 4, wantLocked: true, wantLock: 2 * time.Second},
+		{name: "lockout", failures: 6, wantLocked: true, wantLock: time.Minute},
+		{name: "reset after a success", failures: 6, reset: true},
+	}
+
+	for trackerName, newTracker := range trackers {
+		for _, tt := range tests {
+			t.Run(trackerName+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				tracker := newTracker(t)
+
+				start := time.Now()
+				for i := 0; i < tt.failures; i++ {
+					if _, err := tracker.Fail(ctx, "email:a@example.com", testLockout); err != nil {
+						t.Fatalf("Fail: %v", err)
+					}
+				}
+				if tt.reset {
+					if err := tracker.Reset(ctx, "email:a@example.com"); err != nil {
+						t.Fatalf("Reset: %v", err)
+					}
+				}
+
+				until, err := tracker.LockedUntil(ctx, "email:a@example.com")
+				if err != nil {
+					t.Fatalf("LockedUntil: %v", err)
+				}
+				if locked := !until.IsZero(); locked != tt.wantLocked {
+					t.Fatalf("locked = %v, want %v", locked, tt.wantLocked)
+				}
+				if tt.wantLocked {
+					if lock := until.Sub(start); lock < tt.wantLock-time.Second || lock > tt.wantLock+time.Second {
+						t.Errorf("locked for %v, want about %v", lock, tt.wantLock)
+					}
+				}
+
+				// Other keys are not affected
+				if other, err := tracker.LockedUntil(ctx, "ip:192.0.2.1"); err != nil || !other.IsZero() {
+					t.Errorf("other key locked until %v (err %v)", other, err)
+				}
+			})
+		}
+	}
+}
+
+func TestRedisAttemptsExpire(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newMiniredis(t)
+	tracker := NewRedisAttempts(client)
+
+	for i := 0; i < testLockout.LockoutAfter; i++ {
+		if _, err := tracker.Fail(ctx, "ip:192.0.2.1", testLockout); err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+	}
+
+	// The lock lapses after the lockout duration, and the failures after the window
+	srv.FastForward(testLockout.LockoutDuration + time.Second)
+	if until, _ := tracker.LockedUntil(ctx, "ip:192.0.2.1"); !until.IsZero() {
+		t.Fatalf("still locked until %v after the lockout duration", until)
+	}
+	srv.FastForward(testLockout.Window)
+	if until, _ := tracker.Fail(ctx, "ip:192.0.2.1", testLockout); !until.IsZero() {
+		t.Fatalf("first failure after the window locked until %v", until)
+	}
+}
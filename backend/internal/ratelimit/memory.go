@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// memorySweepSize is the number of buckets after which full buckets are
+// dropped, since they behave like new ones
+const memorySweepSize = 10000
+
+// Memory keeps buckets in process memory, for single-node deployments
+type Memory struct {
+	mutex   sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	limiter *rate.Limiter
+	limit   Limit
+}
+
+// NewMemory creates an in-process limiter
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+// Allow takes a token from the key's bucket
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	bucket, ok := m.buckets[key]
+	if !ok || bucket.limit != limit {
+		if len(m.buckets) >= memorySweepSize {
+			m.sweep(now)
+		}
+		bucket = &memoryBucket{
+			limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst),
+			limit:   limit,
+		}
+		m.buckets[key] = bucket
+	}
+
+	if bucket.limiter.AllowN(now, 1) {
+		return Result{Allowed: true}, nil
+	}
+	return Result{RetryAfter: retryAfter(bucket.limiter.TokensAt(now), limit)}, nil
+}
+
+// sweep drops the buckets that refilled completely
+func (m *Memory) sweep(now time.Time) {
+	for key, bucket := range m.buckets {
+		if bucket.limiter.TokensAt(now) >= float64(bucket.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Default limit of chat messages per user and channel
+var DefaultMessageLimit = Limit{Rate: 1, Burst: 10}
+
+// MessageLimiter limits how fast each user may write to a channel or
+// conversation: posts, thread replies, edits and reactions all take from the
+// same bucket. Channels can have their own limits; the others, and
+// conversations, use the default.
+type MessageLimiter struct {
+	limiter  Limiter
+	fallback Limit
+	channels map[string]Limit
+}
+
+// NewMessageLimiter creates a message limiter using the given default and
+// per-channel limits
+func NewMessageLimiter(limiter Limiter, fallback Limit, channels map[string]Limit) *MessageLimiter {
+	if channels == nil {
+		channels = make(map[string]Limit)
+	}
+	return &MessageLimiter{
+		limiter:  limiter,
+		fallback: fallback,
+		channels: channels,
+	}
+}
+
+// LoadMessageLimits loads the message limits from environment variables:
+// MESSAGE_RATE_LIMIT ("rate:burst") and MESSAGE_RATE_LIMIT_CHANNELS
+// ("general=0.5:5,random=2:20")
+func LoadMessageLimits() (Limit, map[string]Limit, error) {
+	fallback := DefaultMessageLimit
+	if value := os.Getenv("MESSAGE_RATE_LIMIT"); value != "" {
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return Limit{}, nil, err
+		}
+		fallback = limit
+	}
+
+	channels := make(map[string]Limit)
+	for _, entry := range strings.Split(os.Getenv("MESSAGE_RATE_LIMIT_CHANNELS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		channel, value, found := strings.Cut(entry, "=")
+		if !found || channel == "" {
+			return Limit{}, nil, fmt.Errorf("invalid channel rate limit %q: expected channel=rate:burst", entry)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return Limit{}, nil, err
+		}
+		channels[channel] = limit
+	}
+
+	return fallback, channels, nil
+}
+
+// LimitFor returns the limit applying to a channel
+func (l *MessageLimiter) LimitFor(channel string) Limit {
+	if limit, ok := l.channels[channel]; ok {
+		return limit
+	}
+	return l.fallback
+}
+
+// AllowMessage takes a token for a write of the user in the channel, given
+// by name, or in a direct or group conversation, given by its key. If the
+// limiter fails the write is allowed, so an outage of Redis does not stop
+// the chat.
+func (l *MessageLimiter) AllowMessage(ctx context.Context, userID uint, channel string) Result {
+	key := fmt.Sprintf("message:%d:%s", userID, channel)
+	result, err := l.limiter.Allow(ctx, key, l.LimitFor(channel))
+	if err != nil {
+		log.Printf("❌ Rate limiter failed for user %d in channel %s, allowing message: %v", userID, channel, err)
+		return Result{Allowed: true}
+	}
+
+	if !result.Allowed {
+		log.Printf("🚦 User %d is rate limited in channel %s (retry after %v)", userID, channel, result.RetryAfter)
+	}
+	return result
+}
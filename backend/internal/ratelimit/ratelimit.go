@@ -0,0 +1,58 @@
+// Package ratelimit implements token-bucket rate limiting shared between
+// server instances.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket: Rate tokens per second are added up to Burst
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of taking a token
+type Result struct {
+	Allowed bool
+
+	// How long to wait until a token is available, when not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from buckets identified by key
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// ParseLimit parses a limit written as "rate:burst", for example "0.5:5"
+func ParseLimit(value string) (Limit, error) {
+	rateValue, burstValue, found := strings.Cut(strings.TrimSpace(value), ":")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected rate:burst", value)
+	}
+
+	rate, err := strconv.ParseFloat(rateValue, 64)
+	if err != nil || rate <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate in rate limit %q", value)
+	}
+	burst, err := strconv.Atoi(burstValue)
+	if err != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("invalid burst in rate limit %q", value)
+	}
+
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+// retryAfter returns how long it takes to refill a bucket holding tokens
+// to one token
+func retryAfter(tokens float64, limit Limit) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+}
@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "1:10", want: Limit{Rate: 1, Burst: 10}},
+		{value: " 0.5:5 ", want: Limit{Rate: 0.5, Burst: 5}},
+		{value: "10", wantErr: true},
+		{value: "0:5", wantErr: true},
+		{value: "-1:5", wantErr: true},
+		{value: "1:0", wantErr: true},
+		{value: "fast:5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLimiters(t *testing.T) {
+	limiters := map[string]func(t *testing.T) Limiter{
+		"memory": func(t *testing.T) Limiter { return NewMemory() },
+		"redis": func(t *testing.T) Limiter {
+			_, client := newMiniredis(t)
+			return NewRedis(client)
+		},
+	}
+
+	tests := []struct {
+		name        string
+		limit       Limit
+		takes       int
+		wantAllowed int
+		wantRetry   time.Duration
+	}{
+		{name: "within the burst", limit: Limit{Rate: 1, Burst: 5}, takes: 5, wantAllowed: 5},
+		{name: "over the burst", limit: Limit{Rate: 1, Burst: 5}, takes: 8, wantAllowed: 5, wantRetry: time.Second},
+		{name: "slow refill", limit: Limit{Rate: 0.5, Burst: 2}, takes: 3, wantAllowed: 2, wantRetry: 2 * time.Second},
+	}
+
+	for limiterName, newLimiter := range limiters {
+		for _, tt := range tests {
+			t.Run(limiterName+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				limiter := newLimiter(t)
+
+				allowed := 0
+				var last Result
+				for i := 0; i < tt.takes; i++ {
+					result, err := limiter.Allow(ctx, "message:1:general", tt.limit)
+					if err != nil {
+						t.Fatalf("Allow: %v", err)
+					}
+					if result.Allowed {
+						allowed++
+					}
+					last = result
+				}
+
+				if allowed != tt.wantAllowed {
+					t.Errorf("allowed %d of %d, want %d", allowed, tt.takes, tt.wantAllowed)
+				}
+				if tt.wantRetry > 0 {
+					if last.RetryAfter <= 0 || last.RetryAfter > tt.wantRetry {
+						t.Errorf("RetryAfter = %v, want in (0, %v]", last.RetryAfter, tt.wantRetry)
+					}
+				}
+
+				// Other keys have their own bucket
+				if result, err := limiter.Allow(ctx, "message:2:general", tt.limit); err != nil || !result.Allowed {
+					t.Errorf("other key: %+v (err %v), want allowed", result, err)
+				}
+			})
+		}
+	}
+}
+
+func TestRedisBucketRefills(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newMiniredis(t)
+	limiter := NewRedis(client)
+	limit := Limit{Rate: 1, Burst: 2}
+
+	for i := 0; i < limit.Burst; i++ {
+		if result, _ := limiter.Allow(ctx, "message:1:general", limit); !result.Allowed {
+			t.Fatalf("take %d denied within the burst", i+1)
+		}
+	}
+
+	// The bucket expires once it would be full again
+	ttl := srv.TTL("ratelimit:message:1:general")
+	if ttl <= 0 || ttl > 3*time.Second {
+		t.Errorf("bucket TTL = %v, want the refill time plus a second", ttl)
+	}
+	srv.FastForward(ttl)
+	if srv.Exists("ratelimit:message:1:general") {
+		t.Error("bucket still exists after its TTL")
+	}
+	if result, _ := limiter.Allow(ctx, "message:1:general", limit); !result.Allowed {
+		t.Error("expired bucket was not full again")
+	}
+}
+
+func TestMessageLimiterBuckets(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewMessageLimiter(NewMemory(), Limit{Rate: 1, Burst: 2}, map[string]Limit{
+		"announcements": {Rate: 1, Burst: 1},
+	})
+
+	tests := []struct {
+		name        string
+		userID      uint
+		channel     string
+		takes       int
+		wantAllowed int
+	}{
+		{name: "channel uses the default", userID: 1, channel: "general", takes: 3, wantAllowed: 2},
+		{name: "channel with its own limit", userID: 1, channel: "announcements", takes: 3, wantAllowed: 1},
+		{name: "direct conversation has its own bucket", userID: 1, channel: "dm:1:2", takes: 3, wantAllowed: 2},
+		{name: "group conversation has its own bucket", userID: 1, channel: "group:0b7c", takes: 3, wantAllowed: 2},
+		{name: "other users have their own bucket", userID: 2, channel: "general", takes: 3, wantAllowed: 2},
+	}
+
+	// The cases share the limiter, so each starts from its own bucket
+	for _, tt := range tests {
+		allowed := 0
+		for i := 0; i < tt.takes; i++ {
+			if limiter.AllowMessage(ctx, tt.userID, tt.channel).Allowed {
+				allowed++
+			}
+		}
+		if allowed != tt.wantAllowed {
+			t.Errorf("%s: allowed %d of %d, want %d", tt.name, allowed, tt.takes, tt.wantAllowed)
+		}
+	}
+}
+
+func TestMessageLimiterFailsOpen(t *testing.T) {
+	srv, client := newMiniredis(t)
+	limiter := NewMessageLimiter(NewRedis(client), Limit{Rate: 1, Burst: 1}, nil)
+	srv.Close()
+
+	for i := 0; i < 3; i++ {
+		if result := limiter.AllowMessage(context.Background(), 1, "general"); !result.Allowed {
+			t.Fatalf("write %d denied while Redis is down", i+1)
+		}
+	}
+}
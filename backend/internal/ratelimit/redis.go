@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// takeTokenScript refills a bucket for the time elapsed since it was last
+// used and takes a token if one is available. Buckets expire once they
+// would be full again.
+//
+// KEYS[1] bucket HASH (tokens, ts)
+// ARGV: rate per second, burst, now in milliseconds
+// Returns {allowed, tokens left}
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+
+if now > ts then
+  tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+  ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// Redis keeps buckets in Redis so every instance draws from the same ones
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis creates a limiter storing buckets under "ratelimit:<key>"
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{
+		client: client,
+		prefix: "ratelimit:",
+	}
+}
+
+// Allow takes a token from the key's bucket
+func (r *Redis) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now().UnixMilli()
+	values, err := takeTokenScript.Run(ctx, r.client, []string{r.prefix + key},
+		limit.Rate, limit.Burst, now).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	allowed, _ := values[0].(int64)
+	if allowed == 1 {
+		return Result{Allowed: true}, nil
+	}
+
+	tokensValue, _ := values[1].(string)
+	tokens, _ := strconv.ParseFloat(tokensValue, 64)
+	return Result{RetryAfter: retryAfter(tokens, limit)}, nil
+}
@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"time"
+
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ChannelRepository struct {
+	db *gorm.DB
+}
+
+func NewChannelRepository() *ChannelRepository {
+	return &ChannelRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a new channel
+func (r *ChannelRepository) Create(channel *models.Channel) error {
+	return r.db.Create(channel).Error
+}
+
+// GetByID retrieves a channel by ID
+func (r *ChannelRepository) GetByID(id uint) (*models.Channel, error) {
+	var channel models.Channel
+	err := r.db.First(&channel, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// GetByName retrieves a channel by name
+func (r *ChannelRepository) GetByName(name string) (*models.Channel, error) {
+	var channel models.Channel
+	err := r.db.Where("name = ?", name).First(&channel).Error
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// Update updates a channel
+func (r *ChannelRepository) Update(channel *models.Channel) error {
+	return r.db.Save(channel).Error
+}
+
+// Delete soft deletes a channel
+func (r *ChannelRepository) Delete(id uint) error {
+	return r.db.Delete(&models.Channel{}, id).Error
+}
+
+// ListVisible retrieves public channels plus the private channels the user
+// belongs to, ordered by name and optionally including archived ones
+func (r *ChannelRepository) ListVisible(userID uint, includeArchived bool) ([]models.Channel, error) {
+	var channels []models.Channel
+	query := r.db.Order("name ASC").
+		Where("visibility = ? OR id IN (?)", models.ChannelVisibilityPublic,
+			r.db.Model(&models.ChannelMember{}).Select("channel_id").Where("user_id = ?", userID))
+	if !includeArchived {
+		query = query.Where("is_archived = ?", false)
+	}
+	err := query.Find(&channels).Error
+	return channels, err
+}
+
+// NameExists checks if a channel name is already in use
+func (r *ChannelRepository) NameExists(name string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.Channel{}).Where("name = ?", name).Count(&count).Error
+	return count > 0, err
+}
+
+// AddMember adds a user to a channel, leaving an existing membership untouched
+func (r *ChannelRepository) AddMember(member *models.ChannelMember) error {
+	return r.db.Where(models.ChannelMember{ChannelID: member.ChannelID, UserID: member.UserID}).
+		FirstOrCreate(member).Error
+}
+
+// RemoveMember removes a user from a channel
+func (r *ChannelRepository) RemoveMember(channelID, userID uint) error {
+	return r.db.Where("channel_id = ? AND user_id = ?", channelID, userID).
+		Delete(&models.ChannelMember{}).Error
+}
+
+// GetMember retrieves a user's membership in a channel
+func (r *ChannelRepository) GetMember(channelID, userID uint) (*models.ChannelMember, error) {
+	var member models.ChannelMember
+	err := r.db.Where("channel_id = ? AND user_id = ?", channelID, userID).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// IsMember checks if a user belongs to a channel
+func (r *ChannelRepository) IsMember(channelID, userID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.ChannelMember{}).
+		Where("channel_id = ? AND user_id = ?", channelID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ListMembers retrieves the members of a channel
+func (r *ChannelRepository) ListMembers(channelID uint) ([]models.ChannelMember, error) {
+	var members []models.ChannelMember
+	err := r.db.Preload("User").
+		Where("channel_id = ?", channelID).
+		Order("created_at ASC").
+		Find(&members).Error
+	return members, err
+}
+
+// GetLastReads returns the user's read positions in the given channels, keyed by channel ID
+func (r *ChannelRepository) GetLastReads(userID uint, channelIDs []uint) (map[uint]uint, error) {
+	lastReads := make(map[uint]uint, len(channelIDs))
+	if len(channelIDs) == 0 {
+		return lastReads, nil
+	}
+
+	var reads []models.ChannelRead
+	err := r.db.Where("user_id = ? AND channel_id IN ?", userID, channelIDs).Find(&reads).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, read := range reads {
+		lastReads[read.ChannelID] = read.LastReadMessageID
+	}
+	return lastReads, nil
+}
+
+// UpdateLastRead moves a user's read position in a channel forward; an older
+// message ID never moves it back
+func (r *ChannelRepository) UpdateLastRead(channelID, userID, messageID uint) error {
+	read := models.ChannelRead{
+		ChannelID:         channelID,
+		UserID:            userID,
+		LastReadMessageID: messageID,
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "channel_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_read_message_id": messageID,
+			"updated_at":           time.Now(),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Lt{Column: "channel_reads.last_read_message_id", Value: messageID},
+		}},
+	}).Create(&read).Error
+}
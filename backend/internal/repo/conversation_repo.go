@@ -0,0 +1,94 @@
+package repo
+
+import (
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"gorm.io/gorm"
+)
+
+type ConversationRepository struct {
+	db *gorm.DB
+}
+
+func NewConversationRepository() *ConversationRepository {
+	return &ConversationRepository{
+		db: database.DB,
+	}
+}
+
+// Create creates a conversation together with its participants
+func (r *ConversationRepository) Create(conversation *models.Conversation) error {
+	return r.db.Create(conversation).Error
+}
+
+// GetByID retrieves a conversation by ID with its participants
+func (r *ConversationRepository) GetByID(id uint) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := r.db.Preload("Participants.User").First(&conversation, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// GetByKey retrieves a conversation by its key with its participants
+func (r *ConversationRepository) GetByKey(key string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := r.db.Preload("Participants.User").Where("key = ?", key).First(&conversation).Error
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// ListForUser retrieves the conversations of a given kind the user takes part in
+func (r *ConversationRepository) ListForUser(userID uint, kind string) ([]models.Conversation, error) {
+	var conversations []models.Conversation
+	err := r.db.Preload("Participants.User").
+		Where("kind = ?", kind).
+		Where("id IN (?)", r.db.Model(&models.ConversationParticipant{}).
+			Select("conversation_id").Where("user_id = ?", userID)).
+		Order("updated_at DESC").
+		Find(&conversations).Error
+	return conversations, err
+}
+
+// GetParticipant retrieves a user's participation in a conversation
+func (r *ConversationRepository) GetParticipant(conversationID, userID uint) (*models.ConversationParticipant, error) {
+	var participant models.ConversationParticipant
+	err := r.db.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&participant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &participant, nil
+}
+
+// UpdateLastRead moves a participant's read position forward
+func (r *ConversationRepository) UpdateLastRead(conversationID, userID, messageID uint) error {
+	return r.db.Model(&models.ConversationParticipant{}).
+		Where("conversation_id = ? AND user_id = ? AND last_read_message_id < ?", conversationID, userID, messageID).
+		Update("last_read_message_id", messageID).Error
+}
+
+// Touch bumps the conversation's updated_at so recently active conversations sort first
+func (r *ConversationRepository) Touch(id uint) error {
+	return r.db.Model(&models.Conversation{}).Where("id = ?", id).Update("updated_at", gorm.Expr("CURRENT_TIMESTAMP")).Error
+}
+
+// AddParticipant adds a user to a conversation
+func (r *ConversationRepository) AddParticipant(participant *models.ConversationParticipant) error {
+	return r.db.Create(participant).Error
+}
+
+// RemoveParticipant removes a user from a conversation
+func (r *ConversationRepository) RemoveParticipant(conversationID, userID uint) error {
+	return r.db.Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Delete(&models.ConversationParticipant{}).Error
+}
+
+// CountParticipants counts the users taking part in a conversation
+func (r *ConversationRepository) CountParticipants(conversationID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.ConversationParticipant{}).Where("conversation_id = ?", conversationID).Count(&count).Error
+	return count, err
+}
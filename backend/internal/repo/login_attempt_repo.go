@@ -0,0 +1,22 @@
+package repo
+
+import (
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"gorm.io/gorm"
+)
+
+type LoginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository() *LoginAttemptRepository {
+	return &LoginAttemptRepository{
+		db: database.DB,
+	}
+}
+
+// Create records a failed login attempt
+func (r *LoginAttemptRepository) Create(attempt *models.LoginAttempt) error {
+	return r.db.Create(attempt).Error
+}
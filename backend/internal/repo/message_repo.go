@@ -1,6 +1,8 @@
 package repo
 
 import (
+	"time"
+
 	"chatapp/internal/database"
 	"chatapp/internal/models"
 	"gorm.io/gorm"
@@ -21,6 +23,19 @@ func (r *MessageRepository) Create(message *models.Message) error {
 	return r.db.Create(message).Error
 }
 
+// GetByClientMsgID retrieves a user's message by the ID the client generated
+// for it, including a deleted one
+func (r *MessageRepository) GetByClientMsgID(userID uint, clientMsgID string) (*models.Message, error) {
+	var message models.Message
+	err := r.db.Unscoped().Preload("User").
+		Where("user_id = ? AND client_msg_id = ?", userID, clientMsgID).
+		First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
 // GetByID retrieves a message by ID
 func (r *MessageRepository) GetByID(id uint) (*models.Message, error) {
 	var message models.Message
@@ -31,23 +46,46 @@ func (r *MessageRepository) GetByID(id uint) (*models.Message, error) {
 	return &message, nil
 }
 
-// GetByChannel retrieves messages by channel with pagination
-func (r *MessageRepository) GetByChannel(channel string, offset, limit int) ([]models.Message, error) {
+// scoped returns the base query, including soft-deleted messages (tombstones) when requested
+func (r *MessageRepository) scoped(includeDeleted bool) *gorm.DB {
+	if includeDeleted {
+		return r.db.Unscoped()
+	}
+	return r.db
+}
+
+// GetBeforeID retrieves up to limit top-level messages of a channel with an ID
+// below beforeID, newest first. A zero beforeID returns the newest messages.
+func (r *MessageRepository) GetBeforeID(channelID uint, beforeID uint, limit int, includeDeleted bool) ([]models.Message, error) {
 	var messages []models.Message
-	err := r.db.Preload("User").
-		Where("channel = ?", channel).
-		Order("created_at DESC").
-		Offset(offset).
+	query := r.scoped(includeDeleted).Preload("User").
+		Where("channel_id = ? AND parent_id IS NULL", channelID)
+	if beforeID != 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	err := query.Order("id DESC").
 		Limit(limit).
 		Find(&messages).Error
 	return messages, err
 }
 
-// GetRecentByChannel retrieves recent messages by channel
-func (r *MessageRepository) GetRecentByChannel(channel string, limit int) ([]models.Message, error) {
+// GetAfterID retrieves up to limit top-level messages of a channel with an ID
+// above afterID, oldest first
+func (r *MessageRepository) GetAfterID(channelID uint, afterID uint, limit int, includeDeleted bool) ([]models.Message, error) {
 	var messages []models.Message
-	err := r.db.Preload("User").
-		Where("channel = ?", channel).
+	err := r.scoped(includeDeleted).Preload("User").
+		Where("channel_id = ? AND parent_id IS NULL AND id > ?", channelID, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// GetRecentByChannel retrieves recent top-level messages by channel
+func (r *MessageRepository) GetRecentByChannel(channelID uint, limit int, includeDeleted bool) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.scoped(includeDeleted).Preload("User").
+		Where("channel_id = ? AND parent_id IS NULL", channelID).
 		Order("created_at DESC").
 		Limit(limit).
 		Find(&messages).Error
@@ -60,6 +98,90 @@ func (r *MessageRepository) GetRecentByChannel(channel string, limit int) ([]mod
 	return messages, err
 }
 
+// GetSinceID retrieves up to limit messages of a channel, thread replies
+// included, with an ID above afterID, oldest first
+func (r *MessageRepository) GetSinceID(channelID uint, afterID uint, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.Preload("User").
+		Where("channel_id = ? AND id > ?", channelID, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// GetChangedSince retrieves up to limit messages of a channel, thread replies
+// and deleted messages included, with an ID up to maxID that were updated or
+// deleted after since, oldest first
+func (r *MessageRepository) GetChangedSince(channelID uint, maxID uint, since time.Time, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.Unscoped().Preload("User").
+		Where("channel_id = ? AND id <= ? AND (updated_at > ? OR deleted_at > ?)", channelID, maxID, since, since).
+		Order("id ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// GetCreatedAt returns when a message was created, deleted or not
+func (r *MessageRepository) GetCreatedAt(id uint) (time.Time, error) {
+	var message models.Message
+	err := r.db.Unscoped().Select("created_at").First(&message, id).Error
+	return message.CreatedAt, err
+}
+
+// CreateReply creates a thread reply and updates the parent's reply statistics
+// in the same transaction. It returns the parent's new reply count.
+func (r *MessageRepository) CreateReply(reply *models.Message) (int, error) {
+	var replyCount int
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(reply).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Message{}).
+			Where("id = ?", *reply.ParentID).
+			Updates(map[string]interface{}{
+				"reply_count":   gorm.Expr("reply_count + 1"),
+				"last_reply_at": reply.CreatedAt,
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.Message{}).
+			Select("reply_count").
+			Where("id = ?", *reply.ParentID).
+			Scan(&replyCount).Error
+	})
+	return replyCount, err
+}
+
+// DecrementReplyCount lowers a parent's reply count after a reply is deleted
+func (r *MessageRepository) DecrementReplyCount(parentID uint) error {
+	return r.db.Model(&models.Message{}).
+		Where("id = ? AND reply_count > 0", parentID).
+		Update("reply_count", gorm.Expr("reply_count - 1")).Error
+}
+
+// GetReplies retrieves the replies of a thread in chronological order with pagination
+func (r *MessageRepository) GetReplies(parentID uint, offset, limit int, includeDeleted bool) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.scoped(includeDeleted).Preload("User").
+		Where("parent_id = ?", parentID).
+		Order("created_at ASC").
+		Offset(offset).
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// CountReplies counts the replies of a thread
+func (r *MessageRepository) CountReplies(parentID uint, includeDeleted bool) (int64, error) {
+	var count int64
+	err := r.scoped(includeDeleted).Model(&models.Message{}).Where("parent_id = ?", parentID).Count(&count).Error
+	return count, err
+}
+
 // GetByUserID retrieves messages by user ID
 func (r *MessageRepository) GetByUserID(userID uint, offset, limit int) ([]models.Message, error) {
 	var messages []models.Message
@@ -77,18 +199,166 @@ func (r *MessageRepository) Update(message *models.Message) error {
 	return r.db.Save(message).Error
 }
 
+// UpdateContent replaces a message's content and records the previous content
+// as a revision in the same transaction
+func (r *MessageRepository) UpdateContent(message *models.Message, content string, editedAt time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		revision := models.MessageRevision{
+			MessageID: message.ID,
+			Content:   message.Content,
+		}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(message).Updates(map[string]interface{}{
+			"content":   content,
+			"edited_at": editedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		message.Content = content
+		message.EditedAt = &editedAt
+		return nil
+	})
+}
+
+// GetRevisions retrieves the previous contents of a message, oldest first
+func (r *MessageRepository) GetRevisions(messageID uint) ([]models.MessageRevision, error) {
+	var revisions []models.MessageRevision
+	err := r.db.Where("message_id = ?", messageID).
+		Order("id ASC").
+		Find(&revisions).Error
+	return revisions, err
+}
+
 // Delete soft deletes a message
 func (r *MessageRepository) Delete(id uint) error {
 	return r.db.Delete(&models.Message{}, id).Error
 }
 
-// CountByChannel counts messages in a channel
-func (r *MessageRepository) CountByChannel(channel string) (int64, error) {
+// CountByChannel counts top-level messages in a channel
+func (r *MessageRepository) CountByChannel(channelID uint, includeDeleted bool) (int64, error) {
+	var count int64
+	err := r.scoped(includeDeleted).Model(&models.Message{}).Where("channel_id = ? AND parent_id IS NULL", channelID).Count(&count).Error
+	return count, err
+}
+
+// GetByConversation retrieves messages of a conversation with pagination
+func (r *MessageRepository) GetByConversation(conversationID uint, offset, limit int, includeDeleted bool) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.scoped(includeDeleted).Preload("User").
+		Where("conversation_id = ?", conversationID).
+		Order("created_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// GetLatestByConversation retrieves the newest message of a conversation
+func (r *MessageRepository) GetLatestByConversation(conversationID uint) (*models.Message, error) {
+	var message models.Message
+	err := r.db.Preload("User").
+		Where("conversation_id = ?", conversationID).
+		Order("id DESC").
+		First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// CountByConversation counts messages in a conversation
+func (r *MessageRepository) CountByConversation(conversationID uint, includeDeleted bool) (int64, error) {
 	var count int64
-	err := r.db.Model(&models.Message{}).Where("channel = ?", channel).Count(&count).Error
+	err := r.scoped(includeDeleted).Model(&models.Message{}).Where("conversation_id = ?", conversationID).Count(&count).Error
 	return count, err
 }
 
+// CountUnreadInConversation counts messages from other users after the given message ID
+func (r *MessageRepository) CountUnreadInConversation(conversationID, userID, afterID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Message{}).
+		Where("conversation_id = ? AND user_id <> ? AND id > ?", conversationID, userID, afterID).
+		Count(&count).Error
+	return count, err
+}
+
+// ChannelStats is the number of top-level messages in a channel and the ID of
+// the newest one
+type ChannelStats struct {
+	ChannelID     uint
+	Count         int64
+	LastMessageID uint
+}
+
+// GetChannelStats counts the top-level messages of each of the given channels
+// and finds their newest one in a single grouped query. Channels without
+// messages are left out.
+func (r *MessageRepository) GetChannelStats(channelIDs []uint) ([]ChannelStats, error) {
+	var stats []ChannelStats
+	if len(channelIDs) == 0 {
+		return stats, nil
+	}
+
+	err := r.db.Model(&models.Message{}).
+		Select("channel_id, COUNT(*) AS count, MAX(id) AS last_message_id").
+		Where("channel_id IN ? AND parent_id IS NULL", channelIDs).
+		Group("channel_id").
+		Scan(&stats).Error
+	return stats, err
+}
+
+// GetByIDs retrieves the messages with the given IDs
+func (r *MessageRepository) GetByIDs(ids []uint) ([]models.Message, error) {
+	var messages []models.Message
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	err := r.db.Preload("User").Where("id IN ?", ids).Find(&messages).Error
+	return messages, err
+}
+
+// UnreadCount is the number of unread top-level messages in a channel and
+// the number of unread messages, replies included, that mention the user
+type UnreadCount struct {
+	ChannelID uint
+	Unread    int64
+	Mentions  int64
+}
+
+// CountUnreadByChannels counts messages from other users after the user's
+// read position in each of the given channels. mentionPattern is a
+// case-insensitive POSIX regular expression matching a mention of the user.
+func (r *MessageRepository) CountUnreadByChannels(channelIDs []uint, userID uint, mentionPattern string) ([]UnreadCount, error) {
+	var counts []UnreadCount
+	if len(channelIDs) == 0 {
+		return counts, nil
+	}
+
+	err := r.db.Table("messages AS m").
+		Select("m.channel_id, COUNT(*) FILTER (WHERE m.parent_id IS NULL) AS unread, COUNT(*) FILTER (WHERE m.content ~* ?) AS mentions", mentionPattern).
+		Joins("LEFT JOIN channel_reads AS r ON r.channel_id = m.channel_id AND r.user_id = ?", userID).
+		Where("m.channel_id IN ? AND m.user_id <> ? AND m.deleted_at IS NULL", channelIDs, userID).
+		Where("m.id > COALESCE(r.last_read_message_id, 0)").
+		Group("m.channel_id").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// GetLatestIDByChannel returns the ID of the newest message in a channel, replies included, or 0
+func (r *MessageRepository) GetLatestIDByChannel(channelID uint) (uint, error) {
+	var latestID uint
+	err := r.db.Model(&models.Message{}).
+		Where("channel_id = ?", channelID).
+		Select("COALESCE(MAX(id), 0)").
+		Scan(&latestID).Error
+	return latestID, err
+}
+
 // List retrieves all messages with pagination
 func (r *MessageRepository) List(offset, limit int) ([]models.Message, error) {
 	var messages []models.Message
@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"time"
+
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReactionRepository struct {
+	db *gorm.DB
+}
+
+// ReactionCount is the number of users who reacted to a message with an emoji
+type ReactionCount struct {
+	MessageID uint
+	Emoji     string
+	Count     int
+	Reacted   bool // 指定したユーザーがリアクション済みか
+}
+
+func NewReactionRepository() *ReactionRepository {
+	return &ReactionRepository{
+		db: database.DB,
+	}
+}
+
+// Add records a reaction, ignoring duplicates
+func (r *ReactionRepository) Add(reaction *models.Reaction) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return touchMessage(tx, reaction.MessageID)
+	})
+}
+
+// Remove deletes a user's reaction from a message
+func (r *ReactionRepository) Remove(messageID, userID uint, emoji string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+			Delete(&models.Reaction{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return touchMessage(tx, messageID)
+	})
+}
+
+// touchMessage bumps the update time of a message whose reactions changed,
+// so resuming clients find it among the changed messages
+func touchMessage(tx *gorm.DB, messageID uint) error {
+	return tx.Model(&models.Message{}).Where("id = ?", messageID).UpdateColumn("updated_at", time.Now()).Error
+}
+
+// CountByEmoji counts the reactions of one emoji on a message
+func (r *ReactionRepository) CountByEmoji(messageID uint, emoji string) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Reaction{}).
+		Where("message_id = ? AND emoji = ?", messageID, emoji).
+		Count(&count).Error
+	return count, err
+}
+
+// CountsByMessages aggregates reactions per message and emoji, flagging the
+// emojis the given user reacted with
+func (r *ReactionRepository) CountsByMessages(messageIDs []uint, userID uint) ([]ReactionCount, error) {
+	var counts []ReactionCount
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	err := r.db.Model(&models.Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, MAX(CASE WHEN user_id = ? THEN 1 ELSE 0 END) = 1 AS reacted", userID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("MIN(created_at) ASC").
+		Scan(&counts).Error
+	return counts, err
+}
@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"time"
+
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"gorm.io/gorm"
+)
+
+type RefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository() *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		db: database.DB,
+	}
+}
+
+// Create stores a refresh token
+func (r *RefreshTokenRepository) Create(token *models.RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+// GetByHash retrieves a refresh token by the hash of its value
+func (r *RefreshTokenRepository) GetByHash(tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed marks a token as used unless it was used or revoked already. It
+// reports false when another request got there first.
+func (r *RefreshTokenRepository) MarkUsed(id uint, now time.Time) (bool, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeFamily revokes every token of a family
+func (r *RefreshTokenRepository) RevokeFamily(familyID string, now time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// RevokeUser revokes every token of a user
+func (r *RefreshTokenRepository) RevokeUser(userID uint, now time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
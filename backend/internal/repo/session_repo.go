@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"time"
+
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"gorm.io/gorm"
+)
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository() *SessionRepository {
+	return &SessionRepository{
+		db: database.DB,
+	}
+}
+
+// Create stores a new session
+func (r *SessionRepository) Create(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+// GetByID retrieves a session by ID
+func (r *SessionRepository) GetByID(id string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.Where("id = ?", id).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListActive retrieves a user's sessions that are neither revoked nor
+// expired, most recently used first
+func (r *SessionRepository) ListActive(userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// ListActiveIDs retrieves the IDs of a user's sessions that are not revoked
+func (r *SessionRepository) ListActiveIDs(userID uint) ([]string, error) {
+	var ids []string
+	err := r.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// Touch records that a session was used from the given IP
+func (r *SessionRepository) Touch(id, ip string, now time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"ip":           ip,
+		}).Error
+}
+
+// Extend moves a session's expiry after its refresh token was rotated
+func (r *SessionRepository) Extend(id, ip string, expiresAt, now time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"ip":           ip,
+			"expires_at":   expiresAt,
+		}).Error
+}
+
+// Revoke ends a session. It reports false if the session was revoked already.
+func (r *SessionRepository) Revoke(id string, now time.Time) (bool, error) {
+	result := r.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", now)
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,38 @@
+package repo
+
+import (
+	"time"
+
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"gorm.io/gorm"
+)
+
+type SigningKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyRepository() *SigningKeyRepository {
+	return &SigningKeyRepository{
+		db: database.DB,
+	}
+}
+
+// Create stores a signing key
+func (r *SigningKeyRepository) Create(key *models.SigningKey) error {
+	return r.db.Create(key).Error
+}
+
+// ListUnexpired retrieves the keys still valid for verification, oldest first
+func (r *SigningKeyRepository) ListUnexpired(now time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.Where("expires_at > ?", now).
+		Order("active_from ASC, k_id ASC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// DeleteExpired deletes the keys no token signed with can be valid anymore
+func (r *SigningKeyRepository) DeleteExpired(now time.Time) error {
+	return r.db.Where("expires_at <= ?", now).Delete(&models.SigningKey{}).Error
+}
@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"errors"
+	"time"
+
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UserStatusRepository struct {
+	db *gorm.DB
+}
+
+func NewUserStatusRepository() *UserStatusRepository {
+	return &UserStatusRepository{
+		db: database.DB,
+	}
+}
+
+// Get returns a user's status, or the default active status when none was stored
+func (r *UserStatusRepository) Get(userID uint) (*models.UserStatus, error) {
+	var status models.UserStatus
+	err := r.db.Where("user_id = ?", userID).First(&status).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.UserStatus{UserID: userID, Status: models.UserStatusActive}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// GetMany returns the stored statuses of the given users
+func (r *UserStatusRepository) GetMany(userIDs []uint) ([]models.UserStatus, error) {
+	var statuses []models.UserStatus
+	if len(userIDs) == 0 {
+		return statuses, nil
+	}
+	err := r.db.Where("user_id IN ?", userIDs).Find(&statuses).Error
+	return statuses, err
+}
+
+// Save stores the status a user chose, keeping their idle flag
+func (r *UserStatusRepository) Save(status *models.UserStatus) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "text", "emoji", "expires_at", "updated_at"}),
+	}).Create(status).Error
+}
+
+// SetIdle updates a user's idle flag and reports whether it changed, so that
+// only one server instance announces the change
+func (r *UserStatusRepository) SetIdle(userID uint, idle bool) (bool, error) {
+	if !idle {
+		result := r.db.Model(&models.UserStatus{}).
+			Where("user_id = ? AND idle = ?", userID, true).
+			Updates(map[string]interface{}{"idle": false, "updated_at": time.Now()})
+		return result.RowsAffected > 0, result.Error
+	}
+
+	status := models.UserStatus{UserID: userID, Status: models.UserStatusActive, Idle: idle}
+	result := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"idle": idle, "updated_at": time.Now()}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Neq{Column: "user_statuses.idle", Value: idle}}},
+	}).Create(&status)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListExpiredUserIDs returns the users whose status expired before the given time
+func (r *UserStatusRepository) ListExpiredUserIDs(now time.Time) ([]uint, error) {
+	var userIDs []uint
+	err := r.db.Model(&models.UserStatus{}).
+		Where("expires_at <= ?", now).
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// Reset clears a user's chosen status and custom text. With onlyExpired set
+// it only clears a status that expired before now, and reports whether it did.
+func (r *UserStatusRepository) Reset(userID uint, onlyExpired bool, now time.Time) (bool, error) {
+	query := r.db.Model(&models.UserStatus{}).Where("user_id = ?", userID)
+	if onlyExpired {
+		query = query.Where("expires_at <= ?", now)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":     models.UserStatusActive,
+		"text":       "",
+		"emoji":      "",
+		"expires_at": nil,
+		"updated_at": now,
+	})
+	return result.RowsAffected > 0, result.Error
+}
@@ -1,55 +1,164 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"chatapp/internal/models"
+	"chatapp/internal/ratelimit"
 	"chatapp/internal/repo"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type AuthService struct {
-	userRepo  *repo.UserRepository
-	jwtSecret []byte
+	userRepo    *repo.UserRepository
+	attemptRepo *repo.LoginAttemptRepository
+	refreshRepo *repo.RefreshTokenRepository
+	sessionRepo *repo.SessionRepository
+	attempts    ratelimit.AttemptTracker
+	keys        *KeyService
+
+	// Called after a session was revoked, to disconnect its clients
+	onSessionRevoked func(userID uint, sessionID string)
+}
+
+const (
+	// accessTokenTTL is how long an access token (JWT) is valid
+	accessTokenTTL = 15 * time.Minute
+
+	// refreshTokenTTL is how long a refresh token is valid; every refresh
+	// issues a new one
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	// refreshReuseWindow is how long a used refresh token may be used again,
+	// so that tabs refreshing at the same time do not revoke their session
+	refreshReuseWindow = 30 * time.Second
+
+	// sessionTouchInterval is how often the last seen time of a session in
+	// use is written at most
+	sessionTouchInterval = time.Minute
+)
+
+// Lockout policies for failed logins. An IP address gets more attempts since
+// many users may share it.
+var (
+	emailLockout = ratelimit.LockoutPolicy{
+		BackoffAfter:    3,
+		LockoutAfter:    10,
+		BaseDelay:       time.Second,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+	ipLockout = ratelimit.LockoutPolicy{
+		BackoffAfter:    20,
+		LockoutAfter:    100,
+		BaseDelay:       time.Second,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+)
+
+// LoginLockedError is returned while the email address or client IP of a
+// login is locked after too many failed attempts
+type LoginLockedError struct {
+	LockedUntil time.Time
+}
+
+func (e *LoginLockedError) Error() string {
+	return "too many failed login attempts"
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Email      string `json:"email" binding:"required,email"`
+	Password   string `json:"password" binding:"required,min=6"`
+	DeviceName string `json:"device_name" binding:"max=100"`
+}
+
+// LoginClient identifies where a login attempt came from
+type LoginClient struct {
+	IP        string
+	UserAgent string
 }
 
 type SignupRequest struct {
-	Username string `json:"username" binding:"required,min=3,max=50"`
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Username   string `json:"username" binding:"required,min=3,max=50"`
+	Email      string `json:"email" binding:"required,email"`
+	Password   string `json:"password" binding:"required,min=6"`
+	DeviceName string `json:"device_name" binding:"max=100"`
 }
 
+// AuthResponse carries a short-lived access token (Token) and the refresh
+// token to get the next one with
 type AuthResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token        string      `json:"token"`
+	ExpiresAt    time.Time   `json:"expires_at"`
+	RefreshToken string      `json:"refresh_token"`
+	User         models.User `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest revokes the refresh token's family, or with All every
+// refresh token of its user
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+	All          bool   `json:"all"`
+}
+
+// SessionResponse is a signed-in device as shown to its user. Current marks
+// the session of the request.
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	Current    bool      `json:"current"`
 }
 
+// Claims of an access token. SessionID ties the token to the session it was
+// issued for; the token ID (jti) is unique per token.
 type Claims struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	UserID    uint   `json:"user_id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func NewAuthService(userRepo *repo.UserRepository, jwtSecret string) *AuthService {
+func NewAuthService(userRepo *repo.UserRepository, attemptRepo *repo.LoginAttemptRepository, refreshRepo *repo.RefreshTokenRepository, sessionRepo *repo.SessionRepository, attempts ratelimit.AttemptTracker, keys *KeyService) *AuthService {
 	return &AuthService{
-		userRepo:  userRepo,
-		jwtSecret: []byte(jwtSecret),
+		userRepo:    userRepo,
+		attemptRepo: attemptRepo,
+		refreshRepo: refreshRepo,
+		sessionRepo: sessionRepo,
+		attempts:    attempts,
+		keys:        keys,
 	}
 }
 
-// Signup creates a new user account
-func (s *AuthService) Signup(req SignupRequest) (*AuthResponse, error) {
+// OnSessionRevoked sets the function called after a session was revoked
+func (s *AuthService) OnSessionRevoked(fn func(userID uint, sessionID string)) {
+	s.onSessionRevoked = fn
+}
+
+// Signup creates a new user account and signs it in
+func (s *AuthService) Signup(req SignupRequest, client LoginClient) (*AuthResponse, error) {
 	// Check if email already exists
 	exists, err := s.userRepo.EmailExists(req.Email)
 	if err != nil {
@@ -85,27 +194,28 @@ func (s *AuthService) Signup(req SignupRequest) (*AuthResponse, error) {
 		return nil, err
 	}
 
-	// Generate JWT token
-	token, err := s.generateToken(&user)
-	if err != nil {
-		return nil, err
-	}
-
-	// Remove password from response
-	user.Password = ""
-
-	return &AuthResponse{
-		Token: token,
-		User:  user,
-	}, nil
+	return s.startSession(&user, client, req.DeviceName)
 }
 
-// Login authenticates a user and returns a JWT token
-func (s *AuthService) Login(req LoginRequest) (*AuthResponse, error) {
+// Login authenticates a user and returns a JWT token. Failed attempts are
+// counted per email address and client IP; while either is locked the
+// password is not checked and a LoginLockedError is returned.
+func (s *AuthService) Login(req LoginRequest, client LoginClient) (*AuthResponse, error) {
+	ctx := context.Background()
+	emailKey := "login:email:" + strings.ToLower(strings.TrimSpace(req.Email))
+	ipKey := "login:ip:" + client.IP
+
+	if lockedUntil := s.lockedUntil(ctx, emailKey, ipKey); !lockedUntil.IsZero() {
+		s.recordFailure(req.Email, client, nil, models.LoginFailureLocked)
+		return nil, &LoginLockedError{LockedUntil: lockedUntil}
+	}
+
 	// Find user by email
 	user, err := s.userRepo.GetByEmail(req.Email)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.fail(ctx, emailKey, ipKey)
+			s.recordFailure(req.Email, client, nil, models.LoginFailureUnknownEmail)
 			return nil, errors.New("invalid email or password")
 		}
 		return nil, err
@@ -113,22 +223,242 @@ func (s *AuthService) Login(req LoginRequest) (*AuthResponse, error) {
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		s.fail(ctx, emailKey, ipKey)
+		s.recordFailure(req.Email, client, &user.ID, models.LoginFailureInvalidPassword)
 		return nil, errors.New("invalid email or password")
 	}
 
-	// Generate JWT token
-	token, err := s.generateToken(user)
+	// 成功したらメールアドレスの失敗回数をリセット（IPは共有され得るのでリセットしない）
+	if err := s.attempts.Reset(ctx, emailKey); err != nil {
+		log.Printf("❌ Failed to reset login attempts of %s: %v", req.Email, err)
+	}
+
+	return s.startSession(user, client, req.DeviceName)
+}
+
+// startSession creates a session for a signed-in device and its first tokens
+func (s *AuthService) startSession(user *models.User, client LoginClient, deviceName string) (*AuthResponse, error) {
+	if deviceName == "" {
+		deviceName = deviceNameFromUserAgent(client.UserAgent)
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		DeviceName: deviceName,
+		UserAgent:  truncate(client.UserAgent, 255),
+		IP:         client.IP,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+	}
+	if err := s.sessionRepo.Create(&session); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(user, session.ID)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token of the same family. A refresh token used again within
+// refreshReuseWindow is a concurrent refresh (another tab, a retry) and gets
+// tokens of its own. Used any later, it means the token was stolen (or the
+// client misbehaves): its whole family, and so its session, is revoked.
+func (s *AuthService) Refresh(refreshToken string, client LoginClient) (*AuthResponse, error) {
+	stored, err := s.refreshRepo.GetByHash(hashToken(refreshToken))
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invalid refresh token")
+		}
 		return nil, err
 	}
 
-	// Remove password from response
-	user.Password = ""
+	now := time.Now()
+	if stored.RevokedAt != nil || !stored.ExpiresAt.After(now) {
+		return nil, errors.New("invalid refresh token")
+	}
 
-	return &AuthResponse{
-		Token: token,
-		User:  *user,
-	}, nil
+	marked := false
+	if stored.UsedAt == nil {
+		if marked, err = s.refreshRepo.MarkUsed(stored.ID, now); err != nil {
+			return nil, err
+		}
+	}
+	if !marked {
+		// A concurrent request marked it just now when UsedAt was not loaded yet
+		usedAt := now
+		if stored.UsedAt != nil {
+			usedAt = *stored.UsedAt
+		}
+		if now.Sub(usedAt) > refreshReuseWindow {
+			log.Printf("🚨 Refresh token reuse detected for user %d, revoking session %s", stored.UserID, stored.FamilyID)
+			if err := s.revokeSession(stored.UserID, stored.FamilyID); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("refresh token reused")
+		}
+		log.Printf("🔁 Concurrent refresh of session %s within the reuse window", stored.FamilyID)
+	}
+
+	user, err := s.userRepo.GetByID(stored.UserID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	if err := s.sessionRepo.Extend(stored.FamilyID, client.IP, now.Add(refreshTokenTTL), now); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(user, stored.FamilyID)
+}
+
+// Logout ends the session of a refresh token, or every session of its user.
+// Unknown tokens are ignored so logging out twice succeeds.
+func (s *AuthService) Logout(req LogoutRequest) error {
+	stored, err := s.refreshRepo.GetByHash(hashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if !req.All {
+		return s.revokeSession(stored.UserID, stored.FamilyID)
+	}
+
+	sessionIDs, err := s.sessionRepo.ListActiveIDs(stored.UserID)
+	if err != nil {
+		return err
+	}
+	for _, sessionID := range sessionIDs {
+		if err := s.revokeSession(stored.UserID, sessionID); err != nil {
+			return err
+		}
+	}
+	return s.refreshRepo.RevokeUser(stored.UserID, time.Now())
+}
+
+// ListSessions returns a user's active sessions
+func (s *AuthService) ListSessions(userID uint, currentSessionID string) ([]SessionResponse, error) {
+	sessions, err := s.sessionRepo.ListActive(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		responses[i] = SessionResponse{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			LastSeenAt: session.LastSeenAt,
+			CreatedAt:  session.CreatedAt,
+			Current:    session.ID == currentSessionID,
+		}
+	}
+	return responses, nil
+}
+
+// RevokeSession signs one of the user's devices out
+func (s *AuthService) RevokeSession(userID uint, sessionID string) error {
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil || session.UserID != userID || session.RevokedAt != nil {
+		return errors.New("session not found")
+	}
+	return s.revokeSession(userID, sessionID)
+}
+
+// revokeSession ends a session: its refresh tokens stop working, its access
+// tokens are rejected and its WebSocket clients are disconnected
+func (s *AuthService) revokeSession(userID uint, sessionID string) error {
+	now := time.Now()
+	if err := s.refreshRepo.RevokeFamily(sessionID, now); err != nil {
+		return err
+	}
+
+	revoked, err := s.sessionRepo.Revoke(sessionID, now)
+	if err != nil {
+		return err
+	}
+	if revoked && s.onSessionRevoked != nil {
+		s.onSessionRevoked(userID, sessionID)
+	}
+	return nil
+}
+
+// Authenticate validates an access token and checks that its session was
+// not revoked. The session's last seen time and IP are updated on the way.
+func (s *AuthService) Authenticate(tokenString, ip string) (*Claims, error) {
+	claims, err := s.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" {
+		return nil, errors.New("invalid token")
+	}
+
+	session, err := s.sessionRepo.GetByID(claims.SessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("session revoked")
+		}
+		return nil, err
+	}
+	if session.RevokedAt != nil || session.UserID != claims.UserID {
+		return nil, errors.New("session revoked")
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval || session.IP != ip {
+		if err := s.sessionRepo.Touch(session.ID, ip, now); err != nil {
+			log.Printf("❌ Failed to update last seen time of session %s: %v", session.ID, err)
+		}
+	}
+	return claims, nil
+}
+
+// lockedUntil returns the latest lock end of the keys, or the zero time. If
+// the tracker fails the login is not blocked.
+func (s *AuthService) lockedUntil(ctx context.Context, keys ...string) time.Time {
+	var latest time.Time
+	for _, key := range keys {
+		until, err := s.attempts.LockedUntil(ctx, key)
+		if err != nil {
+			log.Printf("❌ Failed to check login lock of %s: %v", key, err)
+			continue
+		}
+		if until.After(latest) {
+			latest = until
+		}
+	}
+	return latest
+}
+
+// fail records a failed login for the email address and client IP
+func (s *AuthService) fail(ctx context.Context, emailKey, ipKey string) {
+	if _, err := s.attempts.Fail(ctx, emailKey, emailLockout); err != nil {
+		log.Printf("❌ Failed to record login failure of %s: %v", emailKey, err)
+	}
+	if _, err := s.attempts.Fail(ctx, ipKey, ipLockout); err != nil {
+		log.Printf("❌ Failed to record login failure of %s: %v", ipKey, err)
+	}
+}
+
+// recordFailure writes the audit record of a failed login
+func (s *AuthService) recordFailure(email string, client LoginClient, userID *uint, reason string) {
+	attempt := models.LoginAttempt{
+		Email:     email,
+		IP:        client.IP,
+		UserID:    userID,
+		Reason:    reason,
+		UserAgent: client.UserAgent,
+	}
+	attempt.UserAgent = truncate(attempt.UserAgent, 255)
+	if err := s.attemptRepo.Create(&attempt); err != nil {
+		log.Printf("❌ Failed to record login attempt of %s: %v", email, err)
+	}
 }
 
 // GetUserByID retrieves user information by ID
@@ -148,13 +478,7 @@ func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	// Debug: Log token parsing attempt
 	fmt.Printf("Validating token - length: %d\n", len(tokenString))
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Debug: Check signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return s.jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, s.keys.Keyfunc)
 
 	if err != nil {
 		fmt.Printf("JWT parsing error: %v\n", err)
@@ -170,14 +494,66 @@ func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	return nil, errors.New("invalid token")
 }
 
-// generateToken creates a new JWT token for the user
-func (s *AuthService) generateToken(user *models.User) (string, error) {
+// issueTokens creates an access token and a refresh token for a session of
+// the user. The session ID is the refresh token family.
+func (s *AuthService) issueTokens(user *models.User, sessionID string) (*AuthResponse, error) {
+	token, expiresAt, err := s.generateToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	stored := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  sessionID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	if err := s.refreshRepo.Create(&stored); err != nil {
+		return nil, err
+	}
+
+	// Remove password from response
+	user.Password = ""
+
+	return &AuthResponse{
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refreshToken,
+		User:         *user,
+	}, nil
+}
+
+// generateRefreshToken returns a random opaque refresh token
+func generateRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the SHA-256 hash under which a refresh token is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateToken creates a new JWT access token for a session of the user
+func (s *AuthService) generateToken(user *models.User, sessionID string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(accessTokenTTL)
 	claims := Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24 hours
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "chatapp",
@@ -185,11 +561,58 @@ func (s *AuthService) generateToken(user *models.User) (string, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(s.jwtSecret)
+	tokenString, err := s.keys.Sign(claims)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
-	return tokenString, nil
+	return tokenString, expiresAt, nil
+}
+
+// deviceNameFromUserAgent describes the device of a user agent, for example
+// "Chrome on Windows", for sessions whose client did not name the device
+func deviceNameFromUserAgent(userAgent string) string {
+	browser := ""
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		platform = "iOS"
+	case strings.Contains(userAgent, "Android"):
+		platform = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		platform = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		platform = "Linux"
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return "Unknown device"
+}
+
+// truncate shortens a string to at most max bytes
+func truncate(value string, max int) string {
+	if len(value) > max {
+		return value[:max]
+	}
+	return value
 }
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"chatapp/internal/ratelimit"
+	"chatapp/internal/repo"
+)
+
+var testClient = LoginClient{IP: "192.0.2.1", UserAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0"}
+
+// revokedSession is a session reported to OnSessionRevoked
+type revokedSession struct {
+	userID    uint
+	sessionID string
+}
+
+// newTestAuthService creates an auth service with HS256 tokens on a test
+// database and records the revoked sessions
+func newTestAuthService(t *testing.T) (*AuthService, *[]revokedSession) {
+	t.Helper()
+
+	setupTestDB(t)
+	keys, err := NewKeyService(repo.NewSigningKeyRepository(), KeyConfig{Algorithm: AlgorithmHS256, Secret: "test-secret"})
+	if err != nil {
+		t.Fatalf("NewKeyService: %v", err)
+	}
+
+	s := NewAuthService(repo.NewUserRepository(), repo.NewLoginAttemptRepository(), repo.NewRefreshTokenRepository(), repo.NewSessionRepository(), ratelimit.NewMemoryAttempts(), keys)
+	revoked := &[]revokedSession{}
+	s.OnSessionRevoked(func(userID uint, sessionID string) {
+		*revoked = append(*revoked, revokedSession{userID: userID, sessionID: sessionID})
+	})
+	return s, revoked
+}
+
+func signup(t *testing.T, s *AuthService, username string) *AuthResponse {
+	t.Helper()
+
+	auth, err := s.Signup(SignupRequest{Username: username, Email: username + "@example.com", Password: "password"}, testClient)
+	if err != nil {
+		t.Fatalf("Signup: %v", err)
+	}
+	return auth
+}
+
+// ageRefreshToken moves the use of a refresh token into the past
+func ageRefreshToken(t *testing.T, refreshToken string, age time.Duration) {
+	t.Helper()
+
+	err := database.DB.Model(&models.RefreshToken{}).
+		Where("token_hash = ? AND used_at IS NOT NULL", hashToken(refreshToken)).
+		Update("used_at", time.Now().Add(-age)).Error
+	if err != nil {
+		t.Fatalf("failed to age refresh token: %v", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name string
+		// run refreshes starting from the signup tokens and returns the
+		// error of the refresh under test
+		run         func(t *testing.T, s *AuthService, first *AuthResponse) error
+		wantErr     string
+		wantRevoked bool
+	}{
+		{
+			name: "rotated tokens chain",
+			run: func(t *testing.T, s *AuthService, first *AuthResponse) error {
+				second, err := s.Refresh(first.RefreshToken, testClient)
+				if err != nil {
+					return err
+				}
+				if second.RefreshToken == first.RefreshToken {
+					t.Error("refresh token was not rotated")
+				}
+				_, err = s.Refresh(second.RefreshToken, testClient)
+				return err
+			},
+		},
+		{
+			name: "concurrent refresh within the reuse window",
+			run: func(t *testing.T, s *AuthService, first *AuthResponse) error {
+				if _, err := s.Refresh(first.RefreshToken, testClient); err != nil {
+					return err
+				}
+				_, err := s.Refresh(first.RefreshToken, testClient)
+				return err
+			},
+		},
+		{
+			name: "reuse after the window revokes the session",
+			run: func(t *testing.T, s *AuthService, first *AuthResponse) error {
+				if _, err := s.Refresh(first.RefreshToken, testClient); err != nil {
+					return err
+				}
+				ageRefreshToken(t, first.RefreshToken, refreshReuseWindow+time.Second)
+				_, err := s.Refresh(first.RefreshToken, testClient)
+				return err
+			},
+			wantErr:     "refresh token reused",
+			wantRevoked: true,
+		},
+		{
+			name: "successor of a reused token stops working",
+			run: func(t *testing.T, s *AuthService, first *AuthResponse) error {
+				second, err := s.Refresh(first.RefreshToken, testClient)
+				if err != nil {
+					return err
+				}
+				ageRefreshToken(t, first.RefreshToken, time.Hour)
+				s.Refresh(first.RefreshToken, testClient)
+				_, err = s.Refresh(second.RefreshToken, testClient)
+				return err
+			},
+			wantErr:     "invalid refresh token",
+			wantRevoked: true,
+		},
+		{
+			name: "unknown token",
+			run: func(t *testing.T, s *AuthService, first *AuthResponse) error {
+				_, err := s.Refresh("not-a-token", testClient)
+				return err
+			},
+			wantErr: "invalid refresh token",
+		},
+		{
+			name: "token of a logged out session",
+			run: func(t *testing.T, s *AuthService, first *AuthResponse) error {
+				if err := s.Logout(LogoutRequest{RefreshToken: first.RefreshToken}); err != nil {
+					return err
+				}
+				_, err := s.Refresh(first.RefreshToken, testClient)
+				return err
+			},
+			wantErr:     "invalid refresh token",
+			wantRevoked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, revoked := newTestAuthService(t)
+			first := signup(t, s, "alice")
+
+			err := tt.run(t, s, first)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+
+			if got := len(*revoked) > 0; got != tt.wantRevoked {
+				t.Errorf("session revoked = %v, want %v", got, tt.wantRevoked)
+			}
+			_, err = s.Authenticate(first.Token, testClient.IP)
+			if tt.wantRevoked && (err == nil || err.Error() != "session revoked") {
+				t.Errorf("access token of a revoked session: error = %v, want \"session revoked\"", err)
+			}
+			if !tt.wantRevoked && err != nil {
+				t.Errorf("access token rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tests := []struct {
+		name string
+		// revoke returns the user and session to revoke
+		revoke      func(alice, bob, aliceOther *AuthResponse) (uint, string)
+		wantErr     string
+		wantRevoked bool
+	}{
+		{
+			name: "own session",
+			revoke: func(alice, bob, aliceOther *AuthResponse) (uint, string) {
+				return alice.User.ID, sessionOf(alice)
+			},
+			wantRevoked: true,
+		},
+		{
+			name: "session of another user",
+			revoke: func(alice, bob, aliceOther *AuthResponse) (uint, string) {
+				return alice.User.ID, sessionOf(bob)
+			},
+			wantErr: "session not found",
+		},
+		{
+			name: "unknown session",
+			revoke: func(alice, bob, aliceOther *AuthResponse) (uint, string) {
+				return alice.User.ID, "00000000-0000-0000-0000-000000000000"
+			},
+			wantErr: "session not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, revoked := newTestAuthService(t)
+			alice := signup(t, s, "alice")
+			bob := signup(t, s, "bob")
+			aliceOther, err := s.Login(LoginRequest{Email: "alice@example.com", Password: "password"}, testClient)
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+
+			userID, sessionID := tt.revoke(alice, bob, aliceOther)
+			err = s.RevokeSession(userID, sessionID)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+
+			if !tt.wantRevoked {
+				if len(*revoked) != 0 {
+					t.Errorf("revoked %v, want nothing", *revoked)
+				}
+				return
+			}
+
+			want := revokedSession{userID: alice.User.ID, sessionID: sessionOf(alice)}
+			if len(*revoked) != 1 || (*revoked)[0] != want {
+				t.Errorf("revoked %v, want [%v]", *revoked, want)
+			}
+			if _, err := s.Authenticate(alice.Token, testClient.IP); err == nil {
+				t.Error("access token of the revoked session still works")
+			}
+			if _, err := s.Refresh(alice.RefreshToken, testClient); err == nil {
+				t.Error("refresh token of the revoked session still works")
+			}
+
+			// The user's other session is not affected
+			if _, err := s.Authenticate(aliceOther.Token, testClient.IP); err != nil {
+				t.Errorf("other session rejected: %v", err)
+			}
+			sessions, err := s.ListSessions(alice.User.ID, sessionOf(aliceOther))
+			if err != nil {
+				t.Fatalf("ListSessions: %v", err)
+			}
+			if len(sessions) != 1 || sessions[0].ID != sessionOf(aliceOther) || !sessions[0].Current {
+				t.Errorf("sessions = %+v, want only the other, current session", sessions)
+			}
+
+			// Revoking again fails without reporting it twice
+			if err := s.RevokeSession(userID, sessionID); err == nil || err.Error() != "session not found" {
+				t.Errorf("second revoke: error = %v, want \"session not found\"", err)
+			}
+			if len(*revoked) != 1 {
+				t.Errorf("revoked %d times, want once", len(*revoked))
+			}
+		})
+	}
+}
+
+// sessionOf returns the session the tokens were issued for
+func sessionOf(auth *AuthResponse) string {
+	var refresh models.RefreshToken
+	database.DB.Where("token_hash = ?", hashToken(auth.RefreshToken)).First(&refresh)
+	return refresh.FamilyID
+}
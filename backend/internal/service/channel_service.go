@@ -0,0 +1,336 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"chatapp/internal/models"
+	"chatapp/internal/repo"
+)
+
+// channelNamePattern restricts channel names to lowercase slugs
+var channelNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+type ChannelService struct {
+	channelRepo *repo.ChannelRepository
+	userRepo    *repo.UserRepository
+}
+
+type CreateChannelRequest struct {
+	Name        string `json:"name" binding:"required,min=1,max=50"`
+	Topic       string `json:"topic" binding:"max=250"`
+	Description string `json:"description" binding:"max=1000"`
+	Visibility  string `json:"visibility" binding:"omitempty,oneof=public private"`
+}
+
+type ChannelMemberRequest struct {
+	UserID uint `json:"user_id" binding:"required"`
+}
+
+type UpdateChannelRequest struct {
+	Topic       *string `json:"topic" binding:"omitempty,max=250"`
+	Description *string `json:"description" binding:"omitempty,max=1000"`
+	IsArchived  *bool   `json:"is_archived"`
+}
+
+type ChannelResponse struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Topic       string    `json:"topic"`
+	Description string    `json:"description"`
+	CreatorID   *uint     `json:"creator_id"`
+	Visibility  string    `json:"visibility"`
+	IsArchived  bool      `json:"is_archived"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ChannelMemberResponse struct {
+	UserID   uint      `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func NewChannelService(channelRepo *repo.ChannelRepository, userRepo *repo.UserRepository) *ChannelService {
+	return &ChannelService{
+		channelRepo: channelRepo,
+		userRepo:    userRepo,
+	}
+}
+
+// CreateChannel creates a new channel owned by the user
+func (s *ChannelService) CreateChannel(userID uint, req CreateChannelRequest) (*ChannelResponse, error) {
+	if !channelNamePattern.MatchString(req.Name) {
+		return nil, errors.New("invalid channel name")
+	}
+
+	exists, err := s.channelRepo.NameExists(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errors.New("channel already exists")
+	}
+
+	visibility := req.Visibility
+	if visibility == "" {
+		visibility = models.ChannelVisibilityPublic
+	}
+
+	channel := models.Channel{
+		Name:        req.Name,
+		Topic:       req.Topic,
+		Description: req.Description,
+		CreatorID:   &userID,
+		Visibility:  visibility,
+	}
+
+	if err := s.channelRepo.Create(&channel); err != nil {
+		return nil, err
+	}
+
+	// The creator is the channel's first member
+	owner := models.ChannelMember{
+		ChannelID: channel.ID,
+		UserID:    userID,
+		Role:      models.ChannelRoleOwner,
+	}
+	if err := s.channelRepo.AddMember(&owner); err != nil {
+		return nil, err
+	}
+
+	return toChannelResponse(&channel), nil
+}
+
+// UpdateChannel updates the topic, description or archived flag of a channel (only by the creator)
+func (s *ChannelService) UpdateChannel(name string, userID uint, req UpdateChannelRequest) (*ChannelResponse, error) {
+	channel, err := s.getOwnedChannel(name, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Topic != nil {
+		channel.Topic = *req.Topic
+	}
+	if req.Description != nil {
+		channel.Description = *req.Description
+	}
+	if req.IsArchived != nil {
+		channel.IsArchived = *req.IsArchived
+	}
+
+	if err := s.channelRepo.Update(channel); err != nil {
+		return nil, err
+	}
+
+	return toChannelResponse(channel), nil
+}
+
+// DeleteChannel deletes a channel (only by the creator)
+func (s *ChannelService) DeleteChannel(name string, userID uint) error {
+	channel, err := s.getOwnedChannel(name, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.channelRepo.Delete(channel.ID)
+}
+
+// JoinChannel adds the user to a public channel
+func (s *ChannelService) JoinChannel(name string, userID uint) (*ChannelMemberResponse, error) {
+	channel, err := s.channelRepo.GetByName(name)
+	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if channel.IsPrivate() {
+		return nil, errors.New("access denied: channel is private")
+	}
+	if channel.IsArchived {
+		return nil, errors.New("channel is archived")
+	}
+
+	return s.addMember(channel, userID)
+}
+
+// LeaveChannel removes the user from a channel. The creator of a private
+// channel cannot leave it, since nobody could invite them back.
+func (s *ChannelService) LeaveChannel(name string, userID uint) (*models.Channel, error) {
+	channel, err := s.channelRepo.GetByName(name)
+	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if channel.IsPrivate() && channel.CreatorID != nil && *channel.CreatorID == userID {
+		return nil, errors.New("the channel creator cannot leave a private channel")
+	}
+
+	if err := s.removeMember(channel, userID); err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}
+
+// InviteMember adds another user to a channel. The inviter must be a member
+// or the creator of the channel, public or private.
+func (s *ChannelService) InviteMember(name string, inviterID, targetUserID uint) (*ChannelMemberResponse, error) {
+	channel, err := s.channelRepo.GetByName(name)
+	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if channel.CreatorID == nil || *channel.CreatorID != inviterID {
+		isMember, err := s.channelRepo.IsMember(channel.ID, inviterID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, errors.New("access denied: not a member of this channel")
+		}
+	}
+	if channel.IsArchived {
+		return nil, errors.New("channel is archived")
+	}
+
+	return s.addMember(channel, targetUserID)
+}
+
+// KickMember removes another user from a channel (only by the creator)
+func (s *ChannelService) KickMember(name string, requesterID, targetUserID uint) (*models.Channel, error) {
+	channel, err := s.channelRepo.GetByName(name)
+	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if channel.CreatorID == nil || *channel.CreatorID != requesterID {
+		return nil, errors.New("unauthorized: only the channel creator can remove members")
+	}
+
+	// A channel must keep its owner
+	if targetUserID == requesterID {
+		return nil, errors.New("cannot remove the channel creator")
+	}
+
+	if err := s.removeMember(channel, targetUserID); err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}
+
+// ListMembers returns the members of a channel the user can access
+func (s *ChannelService) ListMembers(name string, userID uint) ([]ChannelMemberResponse, error) {
+	channel, err := s.channelRepo.GetByName(name)
+	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if err := checkChannelAccess(s.channelRepo, channel, userID); err != nil {
+		return nil, err
+	}
+
+	members, err := s.channelRepo.ListMembers(channel.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]ChannelMemberResponse, len(members))
+	for i, member := range members {
+		responses[i] = ChannelMemberResponse{
+			UserID:   member.UserID,
+			Username: member.User.Username,
+			Role:     member.Role,
+			JoinedAt: member.CreatedAt,
+		}
+	}
+
+	return responses, nil
+}
+
+// addMember records a membership for an existing user
+func (s *ChannelService) addMember(channel *models.Channel, userID uint) (*ChannelMemberResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	member := models.ChannelMember{
+		ChannelID: channel.ID,
+		UserID:    user.ID,
+		Role:      models.ChannelRoleMember,
+	}
+	if err := s.channelRepo.AddMember(&member); err != nil {
+		return nil, err
+	}
+
+	return &ChannelMemberResponse{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     member.Role,
+		JoinedAt: member.CreatedAt,
+	}, nil
+}
+
+// removeMember deletes an existing membership
+func (s *ChannelService) removeMember(channel *models.Channel, userID uint) error {
+	isMember, err := s.channelRepo.IsMember(channel.ID, userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return errors.New("user is not a member of this channel")
+	}
+
+	return s.channelRepo.RemoveMember(channel.ID, userID)
+}
+
+// checkChannelAccess verifies the user may read a channel. Public channels
+// are open to everyone; private channels only to their members.
+func checkChannelAccess(channelRepo *repo.ChannelRepository, channel *models.Channel, userID uint) error {
+	if !channel.IsPrivate() {
+		return nil
+	}
+
+	if userID == 0 {
+		return errors.New("access denied: not a member of this channel")
+	}
+
+	isMember, err := channelRepo.IsMember(channel.ID, userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return errors.New("access denied: not a member of this channel")
+	}
+
+	return nil
+}
+
+// getOwnedChannel loads a channel and verifies the user created it
+func (s *ChannelService) getOwnedChannel(name string, userID uint) (*models.Channel, error) {
+	channel, err := s.channelRepo.GetByName(name)
+	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if channel.CreatorID == nil || *channel.CreatorID != userID {
+		return nil, errors.New("unauthorized: only the channel creator can modify this channel")
+	}
+
+	return channel, nil
+}
+
+func toChannelResponse(channel *models.Channel) *ChannelResponse {
+	return &ChannelResponse{
+		ID:          channel.ID,
+		Name:        channel.Name,
+		Topic:       channel.Topic,
+		Description: channel.Description,
+		CreatorID:   channel.CreatorID,
+		Visibility:  channel.Visibility,
+		IsArchived:  channel.IsArchived,
+		CreatedAt:   channel.CreatedAt,
+	}
+}
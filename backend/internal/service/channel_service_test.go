@@ -0,0 +1,240 @@
+package service
+
+import (
+	"testing"
+
+	"chatapp/internal/models"
+	"chatapp/internal/repo"
+)
+
+// newTestChannelService creates a channel service on a test database with a
+// channel of each visibility owned by alice, who also added bob to both
+func newTestChannelService(t *testing.T) (*ChannelService, map[string]*models.User) {
+	t.Helper()
+
+	setupTestDB(t)
+	s := NewChannelService(repo.NewChannelRepository(), repo.NewUserRepository())
+	users := map[string]*models.User{
+		"alice": createTestUser(t, "alice"),
+		"bob":   createTestUser(t, "bob"),
+		"carol": createTestUser(t, "carol"),
+		"dave":  createTestUser(t, "dave"),
+	}
+
+	for name, visibility := range map[string]string{"open": models.ChannelVisibilityPublic, "secret": models.ChannelVisibilityPrivate} {
+		if _, err := s.CreateChannel(users["alice"].ID, CreateChannelRequest{Name: name, Visibility: visibility}); err != nil {
+			t.Fatalf("CreateChannel %s: %v", name, err)
+		}
+		if _, err := s.InviteMember(name, users["alice"].ID, users["bob"].ID); err != nil {
+			t.Fatalf("InviteMember %s: %v", name, err)
+		}
+	}
+	return s, users
+}
+
+func TestLeaveChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		user    string
+		wantErr string
+	}{
+		{name: "member leaves a public channel", channel: "open", user: "bob"},
+		{name: "member leaves a private channel", channel: "secret", user: "bob"},
+		{name: "creator leaves a public channel", channel: "open", user: "alice"},
+		{name: "creator of a private channel", channel: "secret", user: "alice", wantErr: "the channel creator cannot leave a private channel"},
+		{name: "not a member", channel: "open", user: "carol", wantErr: "user is not a member of this channel"},
+		{name: "unknown channel", channel: "missing", user: "bob", wantErr: "channel not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, users := newTestChannelService(t)
+
+			_, err := s.LeaveChannel(tt.channel, users[tt.user].ID)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("LeaveChannel error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LeaveChannel: %v", err)
+			}
+		})
+	}
+}
+
+func TestInviteMember(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		inviter string
+		wantErr string
+	}{
+		{name: "creator invites to a public channel", channel: "open", inviter: "alice"},
+		{name: "member invites to a public channel", channel: "open", inviter: "bob"},
+		{name: "non-member invites to a public channel", channel: "open", inviter: "carol", wantErr: "access denied: not a member of this channel"},
+		{name: "creator invites to a private channel", channel: "secret", inviter: "alice"},
+		{name: "member invites to a private channel", channel: "secret", inviter: "bob"},
+		{name: "non-member invites to a private channel", channel: "secret", inviter: "carol", wantErr: "access denied: not a member of this channel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, users := newTestChannelService(t)
+
+			member, err := s.InviteMember(tt.channel, users[tt.inviter].ID, users["dave"].ID)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("InviteMember error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InviteMember: %v", err)
+			}
+			if member.UserID != users["dave"].ID || member.Role != models.ChannelRoleMember {
+				t.Errorf("member = %+v, want dave as a member", member)
+			}
+		})
+	}
+}
+
+func TestCreateChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     CreateChannelRequest
+		wantErr string
+	}{
+		{name: "public by default", req: CreateChannelRequest{Name: "dev-team"}},
+		{name: "private", req: CreateChannelRequest{Name: "hush", Visibility: models.ChannelVisibilityPrivate}},
+		{name: "invalid name", req: CreateChannelRequest{Name: "Dev Team"}, wantErr: "invalid channel name"},
+		{name: "taken name", req: CreateChannelRequest{Name: "open"}, wantErr: "channel already exists"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, users := newTestChannelService(t)
+
+			channel, err := s.CreateChannel(users["carol"].ID, tt.req)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("CreateChannel error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateChannel: %v", err)
+			}
+
+			wantVisibility := tt.req.Visibility
+			if wantVisibility == "" {
+				wantVisibility = models.ChannelVisibilityPublic
+			}
+			if channel.Visibility != wantVisibility || channel.CreatorID == nil || *channel.CreatorID != users["carol"].ID {
+				t.Errorf("channel = %+v, want %s and created by carol", channel, wantVisibility)
+			}
+
+			// The creator is the owner and first member
+			members, err := s.ListMembers(tt.req.Name, users["carol"].ID)
+			if err != nil {
+				t.Fatalf("ListMembers: %v", err)
+			}
+			if len(members) != 1 || members[0].UserID != users["carol"].ID || members[0].Role != models.ChannelRoleOwner {
+				t.Errorf("members = %+v, want carol as the owner", members)
+			}
+		})
+	}
+}
+
+func TestUpdateAndDeleteChannel(t *testing.T) {
+	archived := true
+	topic := "plans"
+
+	tests := []struct {
+		name    string
+		user    string
+		delete  bool
+		wantErr string
+	}{
+		{name: "creator updates", user: "alice"},
+		{name: "member cannot update", user: "bob", wantErr: "unauthorized: only the channel creator can modify this channel"},
+		{name: "creator deletes", user: "alice", delete: true},
+		{name: "member cannot delete", user: "bob", delete: true, wantErr: "unauthorized: only the channel creator can modify this channel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, users := newTestChannelService(t)
+
+			var err error
+			if tt.delete {
+				err = s.DeleteChannel("open", users[tt.user].ID)
+			} else {
+				var channel *ChannelResponse
+				channel, err = s.UpdateChannel("open", users[tt.user].ID, UpdateChannelRequest{Topic: &topic, IsArchived: &archived})
+				if err == nil && (channel.Topic != topic || !channel.IsArchived) {
+					t.Errorf("channel = %+v, want the new topic and archived", channel)
+				}
+			}
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.delete {
+				if _, err := s.ListMembers("open", users["alice"].ID); err == nil || err.Error() != "channel not found" {
+					t.Errorf("ListMembers after delete error = %v, want channel not found", err)
+				}
+				return
+			}
+
+			// Archived channels take no new members
+			if _, err := s.JoinChannel("open", users["carol"].ID); err == nil || err.Error() != "channel is archived" {
+				t.Errorf("JoinChannel error = %v, want channel is archived", err)
+			}
+		})
+	}
+}
+
+func TestReusedChannelNameStartsEmpty(t *testing.T) {
+	s, users := newTestChannelService(t)
+	messages := NewMessageService(repo.NewMessageRepository(), repo.NewUserRepository(), repo.NewChannelRepository(), repo.NewConversationRepository(), repo.NewReactionRepository())
+	alice := users["alice"].ID
+
+	oldIDs := postMessages(t, messages, alice, "open", 2)
+	if err := s.DeleteChannel("open", alice); err != nil {
+		t.Fatalf("DeleteChannel: %v", err)
+	}
+	if _, err := s.CreateChannel(alice, CreateChannelRequest{Name: "open"}); err != nil {
+		t.Fatalf("CreateChannel with a reused name: %v", err)
+	}
+
+	page, err := messages.GetMessagesByChannel("open", alice, MessagePageRequest{})
+	if err != nil {
+		t.Fatalf("GetMessagesByChannel: %v", err)
+	}
+	if len(page.Messages) != 0 {
+		t.Errorf("new channel shows %v, want no history", responseIDs(page.Messages))
+	}
+
+	newIDs := postMessages(t, messages, alice, "open", 1)
+	page, err = messages.GetMessagesByChannel("open", alice, MessagePageRequest{})
+	if err != nil {
+		t.Fatalf("GetMessagesByChannel: %v", err)
+	}
+	if got := responseIDs(page.Messages); !equalIDs(got, newIDs) {
+		t.Errorf("messages = %v, want only %v and none of %v", got, newIDs, oldIDs)
+	}
+
+	// Old messages keep pointing at the deleted channel
+	if _, err := messages.EditMessage(oldIDs[0], alice, EditMessageRequest{Content: "edited"}); err == nil || err.Error() != "message not found" {
+		t.Errorf("editing a message of the deleted channel: error = %v, want message not found", err)
+	}
+}
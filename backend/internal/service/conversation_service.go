@@ -0,0 +1,514 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"chatapp/internal/models"
+	"chatapp/internal/repo"
+)
+
+// Group conversations are meant for small ad-hoc groups, not channels
+const (
+	MinGroupParticipants = 3
+	MaxGroupParticipants = 8
+)
+
+type ConversationService struct {
+	conversationRepo *repo.ConversationRepository
+	messageRepo      *repo.MessageRepository
+	userRepo         *repo.UserRepository
+}
+
+type SendConversationMessageRequest struct {
+	Content string `json:"content" binding:"required,min=1,max=1000"`
+}
+
+type CreateGroupConversationRequest struct {
+	ParticipantIDs []uint `json:"participant_ids" binding:"required,min=1"`
+}
+
+type ParticipantRequest struct {
+	UserID uint `json:"user_id" binding:"required"`
+}
+
+type MarkReadRequest struct {
+	MessageID uint `json:"message_id"`
+}
+
+type ConversationSummary struct {
+	ID           uint             `json:"id"`
+	Key          string           `json:"key"`
+	Kind         string           `json:"kind"`
+	Participants []UserInfo       `json:"participants"`
+	LastMessage  *MessageResponse `json:"last_message,omitempty"`
+	UnreadCount  int64            `json:"unread_count"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
+
+// ConversationMessage is a message stored in a conversation together with the
+// users it must be delivered to
+type ConversationMessage struct {
+	Kind           string
+	Message        *MessageResponse
+	ParticipantIDs []uint
+}
+
+// ParticipantChange describes a user added to or removed from a conversation.
+// ParticipantIDs lists everyone who must be notified, including the user
+// that was removed.
+type ParticipantChange struct {
+	ConversationID  uint
+	ConversationKey string
+	User            UserInfo
+	ChangedBy       uint
+	ParticipantIDs  []uint
+}
+
+func NewConversationService(conversationRepo *repo.ConversationRepository, messageRepo *repo.MessageRepository, userRepo *repo.UserRepository) *ConversationService {
+	return &ConversationService{
+		conversationRepo: conversationRepo,
+		messageRepo:      messageRepo,
+		userRepo:         userRepo,
+	}
+}
+
+// GetOrCreateDirectConversation returns the 1:1 conversation between two users, creating it on first use
+func (s *ConversationService) GetOrCreateDirectConversation(userID, otherUserID uint) (*models.Conversation, error) {
+	if userID == otherUserID {
+		return nil, errors.New("cannot start a conversation with yourself")
+	}
+
+	if _, err := s.userRepo.GetByID(otherUserID); err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	key := models.DirectConversationKey(userID, otherUserID)
+	if conversation, err := s.conversationRepo.GetByKey(key); err == nil {
+		return conversation, nil
+	}
+
+	conversation := models.Conversation{
+		Key:  key,
+		Kind: models.ConversationKindDirect,
+		Participants: []models.ConversationParticipant{
+			{UserID: userID},
+			{UserID: otherUserID},
+		},
+	}
+
+	if err := s.conversationRepo.Create(&conversation); err != nil {
+		// The other user may have created it concurrently
+		if existing, getErr := s.conversationRepo.GetByKey(key); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+
+	return s.conversationRepo.GetByID(conversation.ID)
+}
+
+// SendDirectMessage stores a message in the 1:1 conversation between two users
+func (s *ConversationService) SendDirectMessage(userID, otherUserID uint, req SendConversationMessageRequest) (*ConversationMessage, error) {
+	conversation, err := s.GetOrCreateDirectConversation(userID, otherUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createMessage(conversation, userID, req.Content)
+}
+
+// GetDirectMessages retrieves the history of a 1:1 conversation with pagination
+func (s *ConversationService) GetDirectMessages(userID, otherUserID uint, page, limit int, includeDeleted bool) (*MessagesListResponse, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	conversation, err := s.conversationRepo.GetByKey(models.DirectConversationKey(userID, otherUserID))
+	if err != nil {
+		// No conversation yet means no history
+		return &MessagesListResponse{
+			Messages: []MessageResponse{},
+			Total:    new(int64),
+			Page:     page,
+			Limit:    limit,
+		}, nil
+	}
+
+	return s.listMessages(conversation.ID, page, limit, includeDeleted)
+}
+
+// MarkDirectConversationRead moves the user's read position in a 1:1 conversation.
+// A zero message ID marks everything up to the latest message as read.
+func (s *ConversationService) MarkDirectConversationRead(userID, otherUserID, messageID uint) error {
+	conversation, err := s.conversationRepo.GetByKey(models.DirectConversationKey(userID, otherUserID))
+	if err != nil {
+		return errors.New("conversation not found")
+	}
+
+	return s.markRead(conversation.ID, userID, messageID)
+}
+
+// ListDirectConversations returns the user's 1:1 conversations with their last message and unread count
+func (s *ConversationService) ListDirectConversations(userID uint) ([]ConversationSummary, error) {
+	return s.listConversations(userID, models.ConversationKindDirect)
+}
+
+// CreateGroupConversation starts a group conversation between the creator and the given users
+func (s *ConversationService) CreateGroupConversation(creatorID uint, req CreateGroupConversationRequest) (*ConversationSummary, error) {
+	userIDs := []uint{creatorID}
+	seen := map[uint]bool{creatorID: true}
+	for _, id := range req.ParticipantIDs {
+		if !seen[id] {
+			seen[id] = true
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	if len(userIDs) < MinGroupParticipants || len(userIDs) > MaxGroupParticipants {
+		return nil, errors.New("group conversations need between 3 and 8 participants")
+	}
+
+	participants := make([]models.ConversationParticipant, len(userIDs))
+	for i, id := range userIDs {
+		if _, err := s.userRepo.GetByID(id); err != nil {
+			return nil, errors.New("user not found")
+		}
+		participants[i] = models.ConversationParticipant{UserID: id}
+	}
+
+	conversation := models.Conversation{
+		Key:          models.GroupConversationKey(),
+		Kind:         models.ConversationKindGroup,
+		CreatorID:    &creatorID,
+		Participants: participants,
+	}
+
+	if err := s.conversationRepo.Create(&conversation); err != nil {
+		return nil, err
+	}
+
+	created, err := s.conversationRepo.GetByID(conversation.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.buildSummary(created, creatorID)
+}
+
+// ListGroupConversations returns the user's group conversations with their last message and unread count
+func (s *ConversationService) ListGroupConversations(userID uint) ([]ConversationSummary, error) {
+	return s.listConversations(userID, models.ConversationKindGroup)
+}
+
+// GetConversationMessages retrieves the history of a conversation the user takes part in
+func (s *ConversationService) GetConversationMessages(conversationID, userID uint, page, limit int, includeDeleted bool) (*MessagesListResponse, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	if _, err := s.getParticipantConversation(conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.listMessages(conversationID, page, limit, includeDeleted)
+}
+
+// SendConversationMessage stores a message in a conversation the user takes part in
+func (s *ConversationService) SendConversationMessage(conversationID, userID uint, req SendConversationMessageRequest) (*ConversationMessage, error) {
+	conversation, err := s.getParticipantConversation(conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createMessage(conversation, userID, req.Content)
+}
+
+// MarkConversationRead moves the user's read position in a conversation.
+// A zero message ID marks everything up to the latest message as read.
+func (s *ConversationService) MarkConversationRead(conversationID, userID, messageID uint) error {
+	return s.markRead(conversationID, userID, messageID)
+}
+
+// AddParticipant adds a user to a group conversation. Any participant may add people.
+func (s *ConversationService) AddParticipant(conversationID, requesterID, userID uint) (*ParticipantChange, error) {
+	conversation, err := s.getParticipantConversation(conversationID, requesterID)
+	if err != nil {
+		return nil, err
+	}
+
+	if conversation.Kind != models.ConversationKindGroup {
+		return nil, errors.New("participants can only be changed in group conversations")
+	}
+
+	for _, participant := range conversation.Participants {
+		if participant.UserID == userID {
+			return nil, errors.New("user is already a participant")
+		}
+	}
+
+	if len(conversation.Participants) >= MaxGroupParticipants {
+		return nil, errors.New("conversation is full")
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	participant := models.ConversationParticipant{
+		ConversationID: conversation.ID,
+		UserID:         user.ID,
+	}
+	if err := s.conversationRepo.AddParticipant(&participant); err != nil {
+		return nil, err
+	}
+
+	return &ParticipantChange{
+		ConversationID:  conversation.ID,
+		ConversationKey: conversation.Key,
+		User: UserInfo{
+			ID:       user.ID,
+			Username: user.Username,
+		},
+		ChangedBy:      requesterID,
+		ParticipantIDs: append(participantIDs(conversation), user.ID),
+	}, nil
+}
+
+// RemoveParticipant removes a user from a group conversation. Participants may
+// leave on their own; only the creator may remove someone else.
+func (s *ConversationService) RemoveParticipant(conversationID, requesterID, userID uint) (*ParticipantChange, error) {
+	conversation, err := s.getParticipantConversation(conversationID, requesterID)
+	if err != nil {
+		return nil, err
+	}
+
+	if conversation.Kind != models.ConversationKindGroup {
+		return nil, errors.New("participants can only be changed in group conversations")
+	}
+
+	if requesterID != userID && (conversation.CreatorID == nil || *conversation.CreatorID != requesterID) {
+		return nil, errors.New("unauthorized: only the conversation creator can remove other participants")
+	}
+
+	var removed *models.ConversationParticipant
+	for i := range conversation.Participants {
+		if conversation.Participants[i].UserID == userID {
+			removed = &conversation.Participants[i]
+		}
+	}
+	if removed == nil {
+		return nil, errors.New("user is not a participant")
+	}
+
+	if err := s.conversationRepo.RemoveParticipant(conversation.ID, userID); err != nil {
+		return nil, err
+	}
+
+	return &ParticipantChange{
+		ConversationID:  conversation.ID,
+		ConversationKey: conversation.Key,
+		User: UserInfo{
+			ID:       removed.User.ID,
+			Username: removed.User.Username,
+		},
+		ChangedBy:      requesterID,
+		ParticipantIDs: participantIDs(conversation),
+	}, nil
+}
+
+// GetParticipantIDs returns the users taking part in a conversation
+func (s *ConversationService) GetParticipantIDs(conversationID uint) ([]uint, error) {
+	conversation, err := s.conversationRepo.GetByID(conversationID)
+	if err != nil {
+		return nil, errors.New("conversation not found")
+	}
+
+	return participantIDs(conversation), nil
+}
+
+// GetConversationKey returns the key of a conversation the user takes part in
+func (s *ConversationService) GetConversationKey(conversationID, userID uint) (string, error) {
+	conversation, err := s.getParticipantConversation(conversationID, userID)
+	if err != nil {
+		return "", err
+	}
+	return conversation.Key, nil
+}
+
+// getParticipantConversation loads a conversation the user takes part in.
+// Conversations of other users are reported as not found.
+func (s *ConversationService) getParticipantConversation(conversationID, userID uint) (*models.Conversation, error) {
+	conversation, err := s.conversationRepo.GetByID(conversationID)
+	if err != nil {
+		return nil, errors.New("conversation not found")
+	}
+
+	for _, participant := range conversation.Participants {
+		if participant.UserID == userID {
+			return conversation, nil
+		}
+	}
+
+	return nil, errors.New("conversation not found")
+}
+
+// listConversations returns summaries of the user's conversations of one kind
+func (s *ConversationService) listConversations(userID uint, kind string) ([]ConversationSummary, error) {
+	conversations, err := s.conversationRepo.ListForUser(userID, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]ConversationSummary, 0, len(conversations))
+	for i := range conversations {
+		summary, err := s.buildSummary(&conversations[i], userID)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, *summary)
+	}
+
+	// Most recently active conversations first
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+
+	return summaries, nil
+}
+
+// createMessage stores a message in a conversation and returns it with the participant list
+func (s *ConversationService) createMessage(conversation *models.Conversation, userID uint, content string) (*ConversationMessage, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	message := models.Message{
+		UserID:         userID,
+		Content:        content,
+		Channel:        conversation.Key,
+		ConversationID: &conversation.ID,
+	}
+
+	if err := s.messageRepo.Create(&message); err != nil {
+		return nil, err
+	}
+
+	if err := s.conversationRepo.Touch(conversation.ID); err != nil {
+		return nil, err
+	}
+
+	// The sender has obviously read their own message
+	if err := s.conversationRepo.UpdateLastRead(conversation.ID, userID, message.ID); err != nil {
+		return nil, err
+	}
+
+	message.User = *user
+	response := toMessageResponse(&message)
+
+	return &ConversationMessage{
+		Kind:           conversation.Kind,
+		Message:        &response,
+		ParticipantIDs: participantIDs(conversation),
+	}, nil
+}
+
+// listMessages retrieves a page of conversation history
+func (s *ConversationService) listMessages(conversationID uint, page, limit int, includeDeleted bool) (*MessagesListResponse, error) {
+	offset := (page - 1) * limit
+
+	messages, err := s.messageRepo.GetByConversation(conversationID, offset, limit, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.messageRepo.CountByConversation(conversationID, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	messageResponses := make([]MessageResponse, len(messages))
+	for i := range messages {
+		messageResponses[i] = toMessageResponse(&messages[i])
+	}
+
+	return &MessagesListResponse{
+		Messages: messageResponses,
+		Total:    &total,
+		Page:     page,
+		Limit:    limit,
+		HasMore:  int64(offset+limit) < total,
+	}, nil
+}
+
+// markRead updates a participant's read position
+func (s *ConversationService) markRead(conversationID, userID, messageID uint) error {
+	if _, err := s.conversationRepo.GetParticipant(conversationID, userID); err != nil {
+		return errors.New("conversation not found")
+	}
+
+	if messageID == 0 {
+		latest, err := s.messageRepo.GetLatestByConversation(conversationID)
+		if err != nil {
+			// Nothing to read yet
+			return nil
+		}
+		messageID = latest.ID
+	}
+
+	return s.conversationRepo.UpdateLastRead(conversationID, userID, messageID)
+}
+
+// buildSummary assembles the list entry of a conversation for a user
+func (s *ConversationService) buildSummary(conversation *models.Conversation, userID uint) (*ConversationSummary, error) {
+	summary := &ConversationSummary{
+		ID:           conversation.ID,
+		Key:          conversation.Key,
+		Kind:         conversation.Kind,
+		Participants: make([]UserInfo, 0, len(conversation.Participants)),
+		UpdatedAt:    conversation.UpdatedAt,
+	}
+
+	var lastReadID uint
+	for _, participant := range conversation.Participants {
+		summary.Participants = append(summary.Participants, UserInfo{
+			ID:       participant.User.ID,
+			Username: participant.User.Username,
+		})
+		if participant.UserID == userID {
+			lastReadID = participant.LastReadMessageID
+		}
+	}
+
+	if latest, err := s.messageRepo.GetLatestByConversation(conversation.ID); err == nil {
+		lastMessage := toMessageResponse(latest)
+		summary.LastMessage = &lastMessage
+		summary.UpdatedAt = latest.CreatedAt
+	}
+
+	unread, err := s.messageRepo.CountUnreadInConversation(conversation.ID, userID, lastReadID)
+	if err != nil {
+		return nil, err
+	}
+	summary.UnreadCount = unread
+
+	return summary, nil
+}
+
+// participantIDs returns the user IDs taking part in a conversation
+func participantIDs(conversation *models.Conversation) []uint {
+	ids := make([]uint, len(conversation.Participants))
+	for i, participant := range conversation.Participants {
+		ids[i] = participant.UserID
+	}
+	return ids
+}
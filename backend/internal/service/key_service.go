@@ -0,0 +1,467 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"chatapp/internal/models"
+	"chatapp/internal/repo"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Algorithms access tokens can be signed with
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	// DefaultJWTSecret is the HMAC secret used when JWT_SECRET is not set.
+	// It is only fit for development.
+	DefaultJWTSecret = "your-secret-key-here"
+
+	// DefaultKeyRotation is how long an asymmetric key signs tokens
+	DefaultKeyRotation = 30 * 24 * time.Hour
+
+	// minKeyRotation keeps rotation slower than the key check interval
+	minKeyRotation = time.Hour
+
+	// keyCheckInterval is how often keys are reloaded and rotated
+	keyCheckInterval = time.Minute
+
+	// keyReloadMinInterval limits the reloads triggered by tokens signed
+	// with a key this instance does not know yet
+	keyReloadMinInterval = 5 * time.Second
+
+	// maxKeyPublishAhead is how long at most a key is published before it
+	// starts signing, so services caching the JWKS know it in time
+	maxKeyPublishAhead = 24 * time.Hour
+
+	rsaKeyBits = 2048
+)
+
+// Secrets that must not sign tokens in production: the default and the
+// placeholder of .env.example
+var insecureJWTSecrets = []string{
+	DefaultJWTSecret,
+	"your-very-secure-secret-key-change-this-in-production",
+}
+
+// KeyConfig selects how access tokens are signed
+type KeyConfig struct {
+	Algorithm string
+	Secret    string
+	Rotation  time.Duration
+}
+
+// LoadKeyConfig loads the signing configuration from environment variables:
+// JWT_ALGORITHM (HS256, RS256 or EdDSA), JWT_SECRET for HS256 and
+// JWT_KEY_ROTATION (a duration like "720h") for RS256 and EdDSA
+func LoadKeyConfig() (KeyConfig, error) {
+	config := KeyConfig{
+		Algorithm: os.Getenv("JWT_ALGORITHM"),
+		Secret:    os.Getenv("JWT_SECRET"),
+		Rotation:  DefaultKeyRotation,
+	}
+	if config.Algorithm == "" {
+		config.Algorithm = AlgorithmHS256
+	}
+	if config.Secret == "" {
+		config.Secret = DefaultJWTSecret
+	}
+
+	switch config.Algorithm {
+	case AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA:
+	default:
+		return KeyConfig{}, fmt.Errorf("unsupported JWT_ALGORITHM %q: expected HS256, RS256 or EdDSA", config.Algorithm)
+	}
+
+	if value := os.Getenv("JWT_KEY_ROTATION"); value != "" {
+		rotation, err := time.ParseDuration(value)
+		if err != nil {
+			return KeyConfig{}, fmt.Errorf("invalid JWT_KEY_ROTATION %q: %w", value, err)
+		}
+		if rotation < minKeyRotation {
+			return KeyConfig{}, fmt.Errorf("JWT_KEY_ROTATION must be at least %s", minKeyRotation)
+		}
+		config.Rotation = rotation
+	}
+
+	return config, nil
+}
+
+// CheckProduction reports whether the configuration is safe to run in
+// production: HS256 must not use a well-known secret
+func (c KeyConfig) CheckProduction() error {
+	if c.Algorithm != AlgorithmHS256 {
+		return nil
+	}
+	for _, secret := range insecureJWTSecrets {
+		if c.Secret == secret {
+			return errors.New("JWT_SECRET is not set or uses the example value")
+		}
+	}
+	return nil
+}
+
+// KeyService signs access tokens and finds the key to verify them.
+//
+// With HS256 tokens are signed with the shared secret. With RS256 and EdDSA
+// keys are stored in the database so every instance signs with the same key,
+// and rotated: a key signs for the rotation period, the next key is created
+// and published shortly before it takes over, and retired keys keep
+// verifying until the last token they signed expired. Instances racing to
+// create the next key may both succeed; both keys are then published and
+// the one sorting last signs.
+type KeyService struct {
+	keyRepo *repo.SigningKeyRepository
+	config  KeyConfig
+	secret  []byte
+
+	mutex sync.RWMutex
+	keys  []*signingKey // oldest first
+
+	// Guards reloads for unknown key IDs
+	reloadMutex sync.Mutex
+	lastReload  time.Time
+}
+
+// signingKey is a parsed SigningKey
+type signingKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	private    crypto.Signer
+	activeFrom time.Time
+	retiresAt  time.Time
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a set of public keys in JSON Web Key format
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeyService creates a key service. Asymmetric keys are loaded, and the
+// first key created, right away so tokens can be signed once it returns.
+func NewKeyService(keyRepo *repo.SigningKeyRepository, config KeyConfig) (*KeyService, error) {
+	s := &KeyService{
+		keyRepo: keyRepo,
+		config:  config,
+		secret:  []byte(config.Secret),
+	}
+	if s.symmetric() {
+		return s, nil
+	}
+
+	if err := s.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run reloads and rotates the keys periodically. It returns at once with
+// HS256.
+func (s *KeyService) Run() {
+	if s.symmetric() {
+		return
+	}
+
+	ticker := time.NewTicker(keyCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := s.rotate(now); err != nil {
+			log.Printf("❌ Failed to rotate signing keys: %v", err)
+		}
+	}
+}
+
+// Sign signs the claims with the current key
+func (s *KeyService) Sign(claims jwt.Claims) (string, error) {
+	if s.symmetric() {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+
+	key := s.currentKey(time.Now())
+	if key == nil {
+		return "", errors.New("no signing key available")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Keyfunc returns the key verifying a token, for jwt.Parse
+func (s *KeyService) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if s.symmetric() {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key := s.keyByID(kid)
+	if key == nil && kid != "" {
+		key = s.reloadForUnknownKey(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.private.Public(), nil
+}
+
+// JWKS returns the public keys tokens may be verified with, including the
+// next key once it is published. It is empty with HS256, whose secret must
+// not be shared.
+func (s *KeyService) JWKS() JWKS {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range s.keys {
+		jwk := JWK{
+			KeyID:     key.kid,
+			Use:       "sig",
+			Algorithm: key.method.Alg(),
+		}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// symmetric reports whether tokens are signed with the shared HMAC secret
+func (s *KeyService) symmetric() bool {
+	return s.config.Algorithm == AlgorithmHS256
+}
+
+// currentKey returns the key signing at the given time: the newest active
+// key of the configured algorithm
+func (s *KeyService) currentKey(now time.Time) *signingKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var current *signingKey
+	for _, key := range s.keys {
+		if key.method.Alg() == s.config.Algorithm && !key.activeFrom.After(now) && key.retiresAt.After(now) {
+			current = key
+		}
+	}
+	return current
+}
+
+// keyByID returns the key with the given ID
+func (s *KeyService) keyByID(kid string) *signingKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, key := range s.keys {
+		if key.kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// reloadForUnknownKey reloads the keys when a token names a key this
+// instance does not know, which another instance may have just rotated in.
+// Reloads are rate limited so tokens with made-up key IDs cannot flood the
+// database.
+func (s *KeyService) reloadForUnknownKey(kid string) *signingKey {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	// Another request may have reloaded while this one waited
+	if key := s.keyByID(kid); key != nil {
+		return key
+	}
+
+	now := time.Now()
+	if now.Sub(s.lastReload) < keyReloadMinInterval {
+		return nil
+	}
+	s.lastReload = now
+
+	if err := s.reload(now); err != nil {
+		log.Printf("❌ Failed to reload signing keys: %v", err)
+		return nil
+	}
+	return s.keyByID(kid)
+}
+
+// rotate reloads the keys, creates the current key if there is none and the
+// next key when it is due to be published, and deletes expired keys
+func (s *KeyService) rotate(now time.Time) error {
+	if err := s.reload(now); err != nil {
+		return err
+	}
+
+	created := false
+	current := s.currentKey(now)
+	switch {
+	case current == nil:
+		if err := s.createKey(now); err != nil {
+			return err
+		}
+		created = true
+		log.Printf("🔑 Created %s signing key", s.config.Algorithm)
+	case !now.Before(current.retiresAt.Add(-s.publishAhead())) && s.currentKey(current.retiresAt) == nil:
+		if err := s.createKey(current.retiresAt); err != nil {
+			return err
+		}
+		created = true
+		log.Printf("🔑 Created next %s signing key, active from %s", s.config.Algorithm, current.retiresAt.Format(time.RFC3339))
+	}
+
+	if err := s.keyRepo.DeleteExpired(now); err != nil {
+		return err
+	}
+	if created {
+		return s.reload(now)
+	}
+	return nil
+}
+
+// reload replaces the keys in memory with the unexpired keys of the database
+func (s *KeyService) reload(now time.Time) error {
+	stored, err := s.keyRepo.ListUnexpired(now)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*signingKey, 0, len(stored))
+	for _, record := range stored {
+		key, err := parseSigningKey(record)
+		if err != nil {
+			log.Printf("❌ Skipping signing key %s: %v", record.KID, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	s.mutex.Lock()
+	s.keys = keys
+	s.mutex.Unlock()
+	return nil
+}
+
+// createKey generates and stores a key signing from the given time
+func (s *KeyService) createKey(activeFrom time.Time) error {
+	var private crypto.Signer
+	switch s.config.Algorithm {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return err
+		}
+		private = key
+	case AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		private = key
+	default:
+		return fmt.Errorf("unsupported signing algorithm: %s", s.config.Algorithm)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	retiresAt := activeFrom.Add(s.config.Rotation)
+	return s.keyRepo.Create(&models.SigningKey{
+		KID:        uuid.New().String(),
+		Algorithm:  s.config.Algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ActiveFrom: activeFrom,
+		RetiresAt:  retiresAt,
+		ExpiresAt:  retiresAt.Add(accessTokenTTL),
+	})
+}
+
+// publishAhead returns how long before taking over the next key is created
+func (s *KeyService) publishAhead() time.Duration {
+	if ahead := s.config.Rotation / 2; ahead < maxKeyPublishAhead {
+		return ahead
+	}
+	return maxKeyPublishAhead
+}
+
+// parseSigningKey parses a stored key
+func parseSigningKey(record models.SigningKey) (*signingKey, error) {
+	block, _ := pem.Decode([]byte(record.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{
+		kid:        record.KID,
+		activeFrom: record.ActiveFrom,
+		retiresAt:  record.RetiresAt,
+	}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if record.Algorithm != AlgorithmRS256 {
+			return nil, fmt.Errorf("RSA key stored as %s", record.Algorithm)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.private = private
+	case ed25519.PrivateKey:
+		if record.Algorithm != AlgorithmEdDSA {
+			return nil, fmt.Errorf("Ed25519 key stored as %s", record.Algorithm)
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.private = private
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"chatapp/internal/repo"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var asymmetricAlgorithms = []string{AlgorithmRS256, AlgorithmEdDSA}
+
+// newTestKeyService creates a key service for the algorithm on the current
+// test database, like one server instance
+func newTestKeyService(t *testing.T, algorithm string) *KeyService {
+	t.Helper()
+
+	s, err := NewKeyService(repo.NewSigningKeyRepository(), KeyConfig{Algorithm: algorithm, Rotation: minKeyRotation})
+	if err != nil {
+		t.Fatalf("NewKeyService: %v", err)
+	}
+	return s
+}
+
+// signTestToken signs a token for a user with the current key
+func signTestToken(t *testing.T, s *KeyService) string {
+	t.Helper()
+
+	token, err := s.Sign(jwt.RegisteredClaims{
+		Subject:   "1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
+	})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return token
+}
+
+// verify reports the error of verifying a token with the key service
+func verify(s *KeyService, token string) error {
+	_, err := jwt.Parse(token, s.Keyfunc)
+	return err
+}
+
+// jwksKeyIDs returns the key IDs published in the JWKS
+func jwksKeyIDs(s *KeyService) []string {
+	var kids []string
+	for _, key := range s.JWKS().Keys {
+		kids = append(kids, key.KeyID)
+	}
+	return kids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestKeyRotation(t *testing.T) {
+	for _, algorithm := range asymmetricAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			setupTestDB(t)
+			s := newTestKeyService(t, algorithm)
+			now := time.Now()
+
+			first := s.currentKey(now)
+			if first == nil || first.method.Alg() != algorithm {
+				t.Fatalf("current key = %+v, want an %s key", first, algorithm)
+			}
+			oldToken := signTestToken(t, s)
+
+			// Nothing to do before the next key is due
+			if err := s.rotate(now.Add(minKeyRotation / 4)); err != nil {
+				t.Fatalf("rotate: %v", err)
+			}
+			if kids := jwksKeyIDs(s); !equalStrings(kids, []string{first.kid}) {
+				t.Fatalf("published keys = %v, want only %s", kids, first.kid)
+			}
+
+			// The next key is published before it takes over
+			if err := s.rotate(first.retiresAt.Add(-s.publishAhead())); err != nil {
+				t.Fatalf("rotate: %v", err)
+			}
+			kids := jwksKeyIDs(s)
+			if len(kids) != 2 || kids[0] != first.kid {
+				t.Fatalf("published keys = %v, want %s and the next key", kids, first.kid)
+			}
+			if current := s.currentKey(now); current.kid != first.kid {
+				t.Errorf("current key = %s before the rotation, want %s", current.kid, first.kid)
+			}
+			if next := s.currentKey(first.retiresAt); next == nil || next.kid != kids[1] {
+				t.Errorf("key after the rotation = %+v, want %s", next, kids[1])
+			}
+
+			// Rotating again does not create another key
+			if err := s.rotate(first.retiresAt.Add(-s.publishAhead() / 2)); err != nil {
+				t.Fatalf("rotate: %v", err)
+			}
+			if got := jwksKeyIDs(s); len(got) != 2 {
+				t.Errorf("published keys = %v, want 2", got)
+			}
+
+			// Tokens of the retired key verify until they expire, then the key goes
+			if err := verify(s, oldToken); err != nil {
+				t.Errorf("token of the previous key: %v", err)
+			}
+			if err := s.rotate(first.retiresAt.Add(accessTokenTTL)); err != nil {
+				t.Fatalf("rotate: %v", err)
+			}
+			if got := jwksKeyIDs(s); !equalStrings(got, kids[1:]) {
+				t.Errorf("published keys after the old key expired = %v, want %v", got, kids[1:])
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		keyType   string
+		curve     string
+	}{
+		{algorithm: AlgorithmRS256, keyType: "RSA"},
+		{algorithm: AlgorithmEdDSA, keyType: "OKP", curve: "Ed25519"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			setupTestDB(t)
+			s := newTestKeyService(t, tt.algorithm)
+
+			keys := s.JWKS().Keys
+			if len(keys) != 1 {
+				t.Fatalf("JWKS has %d keys, want 1", len(keys))
+			}
+			key := keys[0]
+			if key.KeyType != tt.keyType || key.Curve != tt.curve || key.Algorithm != tt.algorithm || key.Use != "sig" {
+				t.Errorf("key = %+v, want %s %s for %s signatures", key, tt.keyType, tt.curve, tt.algorithm)
+			}
+			if (key.N != "" && key.E != "") == (key.X != "") {
+				t.Errorf("key = %+v, want either n and e or x", key)
+			}
+		})
+	}
+
+	t.Run(AlgorithmHS256, func(t *testing.T) {
+		setupTestDB(t)
+		s, err := NewKeyService(repo.NewSigningKeyRepository(), KeyConfig{Algorithm: AlgorithmHS256, Secret: "test-secret"})
+		if err != nil {
+			t.Fatalf("NewKeyService: %v", err)
+		}
+		if keys := s.JWKS().Keys; len(keys) != 0 {
+			t.Errorf("JWKS = %+v, want no keys for the shared secret", keys)
+		}
+	})
+}
+
+func TestKeysSharedAcrossInstances(t *testing.T) {
+	for _, algorithm := range asymmetricAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			setupTestDB(t)
+			a := newTestKeyService(t, algorithm)
+			b := newTestKeyService(t, algorithm)
+
+			now := time.Now()
+			if a.currentKey(now).kid != b.currentKey(now).kid {
+				t.Fatal("instances sign with different keys")
+			}
+			if err := verify(b, signTestToken(t, a)); err != nil {
+				t.Fatalf("token of the other instance: %v", err)
+			}
+
+			// rotateIn makes instance a sign with a new key instance b has not loaded
+			rotateIn := func() {
+				t.Helper()
+
+				if err := a.createKey(time.Now()); err != nil {
+					t.Fatalf("createKey: %v", err)
+				}
+				if err := a.reload(time.Now()); err != nil {
+					t.Fatalf("reload: %v", err)
+				}
+				if b.keyByID(a.currentKey(time.Now()).kid) != nil {
+					t.Fatal("instance a still signs with a key instance b knows")
+				}
+			}
+
+			// A key instance b has not loaded yet is found with one reload
+			rotateIn()
+			if err := verify(b, signTestToken(t, a)); err != nil {
+				t.Fatalf("token of a key created by the other instance: %v", err)
+			}
+
+			// Another unknown key is not reloaded within keyReloadMinInterval,
+			// so made-up key IDs cannot flood the database
+			rotateIn()
+			if err := verify(b, signTestToken(t, a)); err == nil {
+				t.Error("instance b reloaded its keys again within the reload interval")
+			}
+		})
+	}
+}
+
+func TestKeyfuncRejectsOtherAlgorithms(t *testing.T) {
+	setupTestDB(t)
+	rs256 := newTestKeyService(t, AlgorithmRS256)
+	hmac, err := NewKeyService(repo.NewSigningKeyRepository(), KeyConfig{Algorithm: AlgorithmHS256, Secret: "test-secret"})
+	if err != nil {
+		t.Fatalf("NewKeyService: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		signer *KeyService
+		verify *KeyService
+	}{
+		{name: "HS256 token on RS256", signer: hmac, verify: rs256},
+		{name: "RS256 token on HS256", signer: rs256, verify: hmac},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verify(tt.verify, signTestToken(t, tt.signer)); err == nil {
+				t.Error("token of another algorithm was accepted")
+			}
+		})
+	}
+}
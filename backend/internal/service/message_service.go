@@ -1,29 +1,99 @@
 package service
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"chatapp/internal/models"
 	"chatapp/internal/repo"
+
+	"gorm.io/gorm"
 )
 
 type MessageService struct {
-	messageRepo *repo.MessageRepository
-	userRepo    *repo.UserRepository
+	messageRepo      *repo.MessageRepository
+	userRepo         *repo.UserRepository
+	channelRepo      *repo.ChannelRepository
+	conversationRepo *repo.ConversationRepository
+	reactionRepo     *repo.ReactionRepository
 }
 
 type CreateMessageRequest struct {
+	Content  string `json:"content" binding:"required,min=1,max=1000"`
+	Channel  string `json:"channel" binding:"required,min=1,max=50"`
+	ParentID *uint  `json:"parent_id,omitempty"`
+
+	// Generated by the client so a retried send does not create a duplicate
+	ClientMsgID string `json:"client_msg_id,omitempty" binding:"max=64"`
+}
+
+type EditMessageRequest struct {
 	Content string `json:"content" binding:"required,min=1,max=1000"`
-	Channel string `json:"channel" binding:"required,min=1,max=50"`
 }
 
 type MessageResponse struct {
+	ID             uint       `json:"id"`
+	Content        string     `json:"content"`
+	Channel        string     `json:"channel"`
+	ConversationID *uint      `json:"conversation_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	IsDeleted      bool       `json:"is_deleted,omitempty"`
+	ParentID       *uint      `json:"parent_id,omitempty"`
+	ReplyCount     int        `json:"reply_count"`
+	LastReplyAt    *time.Time `json:"last_reply_at,omitempty"`
+	ClientMsgID    string     `json:"client_msg_id,omitempty"`
+	User           UserInfo   `json:"user"`
+
+	Reactions []ReactionSummary `json:"reactions,omitempty"`
+
+	// Set on a newly created thread reply: the parent's updated reply statistics
+	Thread *ThreadUpdate `json:"thread,omitempty"`
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required,min=1,max=64"`
+}
+
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"` // リクエストしたユーザーがリアクション済みか
+}
+
+// ReactionUpdate describes a single reaction change and the emoji's new total
+type ReactionUpdate struct {
+	MessageID uint     `json:"message_id"`
+	Emoji     string   `json:"emoji"`
+	Count     int64    `json:"count"`
+	User      UserInfo `json:"user"`
+}
+
+type ThreadUpdate struct {
+	ParentID    uint      `json:"parent_id"`
+	ReplyCount  int       `json:"reply_count"`
+	LastReplyAt time.Time `json:"last_reply_at"`
+}
+
+type ThreadResponse struct {
+	Parent  MessageResponse   `json:"parent"`
+	Replies []MessageResponse `json:"replies"`
+	Total   int64             `json:"total"`
+	Page    int               `json:"page"`
+	Limit   int               `json:"limit"`
+	HasMore bool              `json:"has_more"`
+}
+
+type MessageRevisionResponse struct {
 	ID        uint      `json:"id"`
 	Content   string    `json:"content"`
-	Channel   string    `json:"channel"`
 	CreatedAt time.Time `json:"created_at"`
-	User      UserInfo  `json:"user"`
 }
 
 type UserInfo struct {
@@ -31,61 +101,202 @@ type UserInfo struct {
 	Username string `json:"username"`
 }
 
+// MessagesListResponse is a page of history, newest first. HasMore reports
+// older messages, reachable with NextCursor; HasNewer reports newer
+// messages, reachable with PrevCursor. Total is only counted on request.
 type MessagesListResponse struct {
-	Messages []MessageResponse `json:"messages"`
-	Total    int64             `json:"total"`
-	Page     int               `json:"page"`
-	Limit    int               `json:"limit"`
-	HasMore  bool              `json:"has_more"`
+	Messages   []MessageResponse `json:"messages"`
+	Total      *int64            `json:"total,omitempty"`
+	Page       int               `json:"page,omitempty"`
+	Limit      int               `json:"limit"`
+	HasMore    bool              `json:"has_more"`
+	HasNewer   bool              `json:"has_newer"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	PrevCursor string            `json:"prev_cursor,omitempty"`
+}
+
+// MessagePageRequest selects a page of channel history. Cursor, BeforeID,
+// AfterID and AroundID are checked in that order and only the first one set
+// is used; without any the newest messages are returned.
+type MessagePageRequest struct {
+	Cursor         string
+	BeforeID       uint
+	AfterID        uint
+	AroundID       uint
+	Limit          int
+	IncludeTotal   bool
+	IncludeDeleted bool
 }
 
 type ChannelInfo struct {
+	ID           uint   `json:"id"`
 	Name         string `json:"name"`
+	Topic        string `json:"topic"`
+	Description  string `json:"description"`
+	Visibility   string `json:"visibility"`
+	IsArchived   bool   `json:"is_archived"`
 	MessageCount int64  `json:"message_count"`
 	LastMessage  *MessageResponse `json:"last_message,omitempty"`
+
+	// 認証済みユーザーの既読位置と未読数
+	LastReadMessageID uint  `json:"last_read_message_id"`
+	UnreadCount       int64 `json:"unread_count"`
+	MentionCount      int64 `json:"mention_count"`
+}
+
+// ReadPosition is a user's read position in a channel with the counts left unread
+type ReadPosition struct {
+	Channel           string `json:"channel"`
+	ChannelID         uint   `json:"channel_id"`
+	LastReadMessageID uint   `json:"last_read_message_id"`
+	UnreadCount       int64  `json:"unread_count"`
+	MentionCount      int64  `json:"mention_count"`
 }
 
-func NewMessageService(messageRepo *repo.MessageRepository, userRepo *repo.UserRepository) *MessageService {
+func NewMessageService(messageRepo *repo.MessageRepository, userRepo *repo.UserRepository, channelRepo *repo.ChannelRepository, conversationRepo *repo.ConversationRepository, reactionRepo *repo.ReactionRepository) *MessageService {
 	return &MessageService{
-		messageRepo: messageRepo,
-		userRepo:    userRepo,
+		messageRepo:      messageRepo,
+		userRepo:         userRepo,
+		channelRepo:      channelRepo,
+		conversationRepo: conversationRepo,
+		reactionRepo:     reactionRepo,
 	}
 }
 
-// CreateMessage creates a new message
-func (s *MessageService) CreateMessage(userID uint, req CreateMessageRequest) (*MessageResponse, error) {
+// CreateMessage stores a channel message or thread reply. When the request
+// carries a client_msg_id the user already sent, the earlier message is
+// returned instead and created is false.
+func (s *MessageService) CreateMessage(userID uint, req CreateMessageRequest) (response *MessageResponse, created bool, err error) {
+	if existing, found, err := s.FindSentMessage(userID, req); found {
+		return existing, false, err
+	}
+
 	// Validate user exists
 	user, err := s.userRepo.GetByID(userID)
 	if err != nil {
-		return nil, errors.New("user not found")
+		return nil, false, errors.New("user not found")
+	}
+
+	// Validate channel exists and is open for posting
+	channel, err := s.channelRepo.GetByName(req.Channel)
+	if err != nil {
+		return nil, false, errors.New("channel not found")
+	}
+	if err := checkChannelAccess(s.channelRepo, channel, userID); err != nil {
+		return nil, false, err
+	}
+	if channel.IsArchived {
+		return nil, false, errors.New("channel is archived")
 	}
 
 	// Create message
 	message := models.Message{
-		UserID:  userID,
-		Content: req.Content,
-		Channel: req.Channel,
+		UserID:    userID,
+		Content:   req.Content,
+		Channel:   channel.Name,
+		ChannelID: &channel.ID,
+	}
+	if req.ClientMsgID != "" {
+		message.ClientMsgID = &req.ClientMsgID
 	}
 
-	if err := s.messageRepo.Create(&message); err != nil {
+	if req.ParentID != nil {
+		response, err = s.createReply(&message, *req.ParentID, user)
+	} else {
+		err = s.messageRepo.Create(&message)
+		if err == nil {
+			message.User = *user
+			resp := toMessageResponse(&message)
+			response = &resp
+		}
+	}
+
+	if err != nil {
+		// A concurrent send with the same client_msg_id won; return its message
+		if req.ClientMsgID != "" {
+			if existing, lookupErr := s.messageRepo.GetByClientMsgID(userID, req.ClientMsgID); lookupErr == nil {
+				response, err := s.existingMessage(userID, existing, req)
+				return response, false, err
+			}
+		}
+		return nil, false, err
+	}
+	return response, true, nil
+}
+
+// FindSentMessage looks up the message an earlier send with the request's
+// client_msg_id created, so a retry is answered without posting again. found
+// is false when the request has no client_msg_id or it was not used yet.
+func (s *MessageService) FindSentMessage(userID uint, req CreateMessageRequest) (response *MessageResponse, found bool, err error) {
+	if req.ClientMsgID == "" {
+		return nil, false, nil
+	}
+
+	existing, err := s.messageRepo.GetByClientMsgID(userID, req.ClientMsgID)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	response, err = s.existingMessage(userID, existing, req)
+	return response, true, err
+}
+
+// existingMessage returns the message an earlier send with the same
+// client_msg_id created, if the user may still read the channel. Reusing the
+// ID for a different message is an error.
+func (s *MessageService) existingMessage(userID uint, existing *models.Message, req CreateMessageRequest) (*MessageResponse, error) {
+	channel, err := s.accessibleChannel(req.Channel, userID)
+	if err != nil {
 		return nil, err
 	}
 
-	// Return response with user info
-	return &MessageResponse{
-		ID:        message.ID,
-		Content:   message.Content,
-		Channel:   message.Channel,
-		CreatedAt: message.CreatedAt,
-		User: UserInfo{
-			ID:       user.ID,
-			Username: user.Username,
-		},
-	}, nil
+	if existing.ChannelID == nil || *existing.ChannelID != channel.ID || !sameParent(existing.ParentID, req.ParentID) {
+		return nil, errors.New("client_msg_id already used for another message")
+	}
+
+	response := toMessageResponse(existing)
+	return &response, nil
+}
+
+// sameParent reports whether two optional parent message IDs are equal
+func sameParent(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
 }
 
-// GetMessagesByChannel retrieves messages for a specific channel with pagination
-func (s *MessageService) GetMessagesByChannel(channel string, page, limit int) (*MessagesListResponse, error) {
+// createReply stores a message as a reply in the thread of a top-level message of the same channel
+func (s *MessageService) createReply(message *models.Message, parentID uint, user *models.User) (*MessageResponse, error) {
+	parent, err := s.messageRepo.GetByID(parentID)
+	if err != nil || parent.ChannelID == nil || *parent.ChannelID != *message.ChannelID || parent.ConversationID != nil {
+		return nil, errors.New("parent message not found")
+	}
+
+	// Threads are a single level deep
+	if parent.ParentID != nil {
+		return nil, errors.New("cannot reply to a thread reply")
+	}
+
+	message.ParentID = &parent.ID
+	replyCount, err := s.messageRepo.CreateReply(message)
+	if err != nil {
+		return nil, err
+	}
+
+	message.User = *user
+	response := toMessageResponse(message)
+	response.Thread = &ThreadUpdate{
+		ParentID:    parent.ID,
+		ReplyCount:  replyCount,
+		LastReplyAt: message.CreatedAt,
+	}
+
+	return &response, nil
+}
+
+// GetThread retrieves a message and a page of its replies in chronological order
+func (s *MessageService) GetThread(messageID, userID uint, page, limit int, includeDeleted bool) (*ThreadResponse, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -93,140 +304,776 @@ func (s *MessageService) GetMessagesByChannel(channel string, page, limit int) (
 		limit = 50
 	}
 
+	parent, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
+
+	if err := s.checkMessageAccess(parent, userID); err != nil {
+		return nil, err
+	}
+
 	offset := (page - 1) * limit
 
-	// Get messages
-	messages, err := s.messageRepo.GetByChannel(channel, offset, limit)
+	replies, err := s.messageRepo.GetReplies(parent.ID, offset, limit, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get total count
-	total, err := s.messageRepo.CountByChannel(channel)
+	total, err := s.messageRepo.CountReplies(parent.ID, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
 
-	// Convert to response format
-	messageResponses := make([]MessageResponse, len(messages))
-	for i, msg := range messages {
-		messageResponses[i] = MessageResponse{
-			ID:        msg.ID,
-			Content:   msg.Content,
-			Channel:   msg.Channel,
-			CreatedAt: msg.CreatedAt,
-			User: UserInfo{
-				ID:       msg.User.ID,
-				Username: msg.User.Username,
-			},
+	replyResponses := make([]MessageResponse, len(replies))
+	for i := range replies {
+		replyResponses[i] = toMessageResponse(&replies[i])
+	}
+
+	if err := s.attachReactions(replyResponses, userID); err != nil {
+		return nil, err
+	}
+
+	return &ThreadResponse{
+		Parent:  toMessageResponse(parent),
+		Replies: replyResponses,
+		Total:   total,
+		Page:    page,
+		Limit:   limit,
+		HasMore: int64(offset+limit) < total,
+	}, nil
+}
+
+// GetMessagesByChannel retrieves a page of top-level messages of a channel
+// using keyset pagination on the message ID
+func (s *MessageService) GetMessagesByChannel(name string, userID uint, req MessagePageRequest) (*MessagesListResponse, error) {
+	ch, err := s.accessibleChannel(name, userID)
+	if err != nil {
+		return nil, err
+	}
+	channel := ch.ID
+
+	limit := req.Limit
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	if req.Cursor != "" {
+		direction, id, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return nil, err
 		}
+		req.BeforeID, req.AfterID, req.AroundID = 0, 0, 0
+		if direction == cursorBefore {
+			req.BeforeID = id
+		} else {
+			req.AfterID = id
+		}
+	}
+
+	var messages []models.Message // newest first
+	var hasOlder, hasNewer bool
+
+	switch {
+	case req.BeforeID != 0:
+		messages, hasOlder, err = s.pageBefore(channel, req.BeforeID, limit, req.IncludeDeleted)
+		hasNewer = true
+
+	case req.AfterID != 0:
+		messages, hasNewer, err = s.pageAfter(channel, req.AfterID, limit, req.IncludeDeleted)
+		hasOlder = true
+
+	case req.AroundID != 0:
+		// The anchor message is part of the older half
+		newerLimit := limit / 2
+		var newer []models.Message
+		newer, hasNewer, err = s.pageAfter(channel, req.AroundID, newerLimit, req.IncludeDeleted)
+		if err == nil {
+			messages, hasOlder, err = s.pageBefore(channel, req.AroundID+1, limit-newerLimit, req.IncludeDeleted)
+			messages = append(newer, messages...)
+		}
+
+	default:
+		messages, hasOlder, err = s.pageBefore(channel, 0, limit, req.IncludeDeleted)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messageResponses := make([]MessageResponse, len(messages))
+	for i := range messages {
+		messageResponses[i] = toMessageResponse(&messages[i])
 	}
 
-	// Calculate if there are more messages
-	hasMore := int64(offset+limit) < total
+	if err := s.attachReactions(messageResponses, userID); err != nil {
+		return nil, err
+	}
 
-	return &MessagesListResponse{
+	response := &MessagesListResponse{
 		Messages: messageResponses,
-		Total:    total,
-		Page:     page,
 		Limit:    limit,
-		HasMore:  hasMore,
-	}, nil
+		HasMore:  hasOlder,
+		HasNewer: hasNewer,
+	}
+	if len(messages) > 0 {
+		if hasOlder {
+			response.NextCursor = encodeCursor(cursorBefore, messages[len(messages)-1].ID)
+		}
+		if hasNewer {
+			response.PrevCursor = encodeCursor(cursorAfter, messages[0].ID)
+		}
+	}
+
+	if req.IncludeTotal {
+		total, err := s.messageRepo.CountByChannel(channel, req.IncludeDeleted)
+		if err != nil {
+			return nil, err
+		}
+		response.Total = &total
+	}
+
+	return response, nil
+}
+
+// MissedMessages is what a client missed in a channel while disconnected
+type MissedMessages struct {
+	// Messages posted after the last seen one, thread replies included, in
+	// the order they were created
+	Messages []MessageResponse
+
+	// Messages up to the last seen one that were edited, deleted or reacted
+	// to since it was posted, deleted ones as tombstones
+	Updated []MessageResponse
+
+	// Whether more messages were left out of either list
+	Truncated bool
+}
+
+// GetMissedMessages returns up to limit messages of a channel posted after
+// the given message ID, and up to limit older messages changed since that
+// message was posted.
+func (s *MessageService) GetMissedMessages(name string, userID, afterID uint, limit int) (*MissedMessages, error) {
+	channel, err := s.accessibleChannel(name, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.messageRepo.GetSinceID(channel.ID, afterID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	missed := &MissedMessages{Truncated: len(messages) > limit}
+	if missed.Truncated {
+		messages = messages[:limit]
+	}
+
+	// Changes to older messages are looked up from when the last seen message
+	// was posted; the client may have seen some of them live already
+	var since time.Time
+	if afterID != 0 {
+		if since, err = s.messageRepo.GetCreatedAt(afterID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	changed, err := s.messageRepo.GetChangedSince(channel.ID, afterID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) > limit {
+		changed = changed[:limit]
+		missed.Truncated = true
+	}
+
+	missed.Messages = toMessageResponses(messages)
+	missed.Updated = toMessageResponses(changed)
+	if err := s.attachReactions(missed.Messages, userID); err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(missed.Updated, userID); err != nil {
+		return nil, err
+	}
+	return missed, nil
+}
+
+// toMessageResponses converts messages with their preloaded users to the API format
+func toMessageResponses(messages []models.Message) []MessageResponse {
+	responses := make([]MessageResponse, len(messages))
+	for i := range messages {
+		responses[i] = toMessageResponse(&messages[i])
+	}
+	return responses
+}
+
+// pageBefore loads up to limit messages older than beforeID, newest first,
+// and reports whether more older messages exist
+func (s *MessageService) pageBefore(channelID uint, beforeID uint, limit int, includeDeleted bool) ([]models.Message, bool, error) {
+	messages, err := s.messageRepo.GetBeforeID(channelID, beforeID, limit+1, includeDeleted)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
+}
+
+// pageAfter loads up to limit messages newer than afterID, newest first, and
+// reports whether more newer messages exist
+func (s *MessageService) pageAfter(channelID uint, afterID uint, limit int, includeDeleted bool) ([]models.Message, bool, error) {
+	if limit == 0 {
+		return nil, false, nil
+	}
+
+	messages, err := s.messageRepo.GetAfterID(channelID, afterID, limit+1, includeDeleted)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, hasMore, nil
 }
 
 // GetRecentMessagesByChannel retrieves recent messages for a channel
-func (s *MessageService) GetRecentMessagesByChannel(channel string, limit int) ([]MessageResponse, error) {
+func (s *MessageService) GetRecentMessagesByChannel(name string, userID uint, limit int, includeDeleted bool) ([]MessageResponse, error) {
+	channel, err := s.accessibleChannel(name, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	if limit < 1 || limit > 100 {
 		limit = 50
 	}
 
-	messages, err := s.messageRepo.GetRecentByChannel(channel, limit)
+	messages, err := s.messageRepo.GetRecentByChannel(channel.ID, limit, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert to response format
 	messageResponses := make([]MessageResponse, len(messages))
-	for i, msg := range messages {
-		messageResponses[i] = MessageResponse{
-			ID:        msg.ID,
-			Content:   msg.Content,
-			Channel:   msg.Channel,
-			CreatedAt: msg.CreatedAt,
-			User: UserInfo{
-				ID:       msg.User.ID,
-				Username: msg.User.Username,
-			},
-		}
+	for i := range messages {
+		messageResponses[i] = toMessageResponse(&messages[i])
+	}
+
+	if err := s.attachReactions(messageResponses, userID); err != nil {
+		return nil, err
 	}
 
 	return messageResponses, nil
 }
 
 // GetChannelInfo retrieves information about a channel
-func (s *MessageService) GetChannelInfo(channel string) (*ChannelInfo, error) {
-	// Get message count
-	count, err := s.messageRepo.CountByChannel(channel)
+func (s *MessageService) GetChannelInfo(name string, userID uint) (*ChannelInfo, error) {
+	channel, err := s.channelRepo.GetByName(name)
 	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if err := checkChannelAccess(s.channelRepo, channel, userID); err != nil {
 		return nil, err
 	}
 
-	channelInfo := &ChannelInfo{
-		Name:         channel,
-		MessageCount: count,
+	infos, err := s.buildChannelInfos([]models.Channel{*channel})
+	if err != nil {
+		return nil, err
 	}
 
-	// Get last message if exists
-	if count > 0 {
-		messages, err := s.messageRepo.GetRecentByChannel(channel, 1)
-		if err == nil && len(messages) > 0 {
-			msg := messages[0]
-			channelInfo.LastMessage = &MessageResponse{
-				ID:        msg.ID,
-				Content:   msg.Content,
-				Channel:   msg.Channel,
-				CreatedAt: msg.CreatedAt,
-				User: UserInfo{
-					ID:       msg.User.ID,
-					Username: msg.User.Username,
-				},
-			}
+	if err := s.attachUnreadCounts(infos, userID); err != nil {
+		return nil, err
+	}
+	return &infos[0], nil
+}
+
+// CheckChannelAccess verifies the channel exists and the user may read it
+func (s *MessageService) CheckChannelAccess(name string, userID uint) error {
+	_, err := s.accessibleChannel(name, userID)
+	return err
+}
+
+// accessibleChannel returns the channel with the given name if the user may
+// read it. History is looked up by its ID: a deleted channel's messages stay
+// with it even when a new channel reuses the name.
+func (s *MessageService) accessibleChannel(name string, userID uint) (*models.Channel, error) {
+	channel, err := s.channelRepo.GetByName(name)
+	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if err := checkChannelAccess(s.channelRepo, channel, userID); err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// buildChannelInfos assembles message statistics for channels with one
+// grouped query for the counts and one for the last messages
+func (s *MessageService) buildChannelInfos(channels []models.Channel) ([]ChannelInfo, error) {
+	channelIDs := make([]uint, len(channels))
+	for i, ch := range channels {
+		channelIDs[i] = ch.ID
+	}
+
+	stats, err := s.messageRepo.GetChannelStats(channelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	byChannel := make(map[uint]repo.ChannelStats, len(stats))
+	lastIDs := make([]uint, 0, len(stats))
+	for _, stat := range stats {
+		byChannel[stat.ChannelID] = stat
+		lastIDs = append(lastIDs, stat.LastMessageID)
+	}
+
+	lastMessages, err := s.messageRepo.GetByIDs(lastIDs)
+	if err != nil {
+		return nil, err
+	}
+	lastByChannel := make(map[uint]*models.Message, len(lastMessages))
+	for i := range lastMessages {
+		if lastMessages[i].ChannelID != nil {
+			lastByChannel[*lastMessages[i].ChannelID] = &lastMessages[i]
+		}
+	}
+
+	infos := make([]ChannelInfo, len(channels))
+	for i, ch := range channels {
+		infos[i] = ChannelInfo{
+			ID:           ch.ID,
+			Name:         ch.Name,
+			Topic:        ch.Topic,
+			Description:  ch.Description,
+			Visibility:   ch.Visibility,
+			IsArchived:   ch.IsArchived,
+			MessageCount: byChannel[ch.ID].Count,
+		}
+		if last, ok := lastByChannel[ch.ID]; ok {
+			lastMessage := toMessageResponse(last)
+			infos[i].LastMessage = &lastMessage
 		}
 	}
 
-	return channelInfo, nil
+	return infos, nil
 }
 
-// GetAvailableChannels returns list of available channels
-func (s *MessageService) GetAvailableChannels() ([]ChannelInfo, error) {
-	// For MVP, we only support "general" channel
-	// In the future, this could be expanded to support multiple channels
-	channels := []string{"general"}
-	
-	channelInfos := make([]ChannelInfo, len(channels))
-	for i, channel := range channels {
-		info, err := s.GetChannelInfo(channel)
+// GetAvailableChannels returns the public channels plus the private channels the user belongs to
+func (s *MessageService) GetAvailableChannels(userID uint, includeArchived bool) ([]ChannelInfo, error) {
+	channels, err := s.channelRepo.ListVisible(userID, includeArchived)
+	if err != nil {
+		return nil, err
+	}
+
+	channelInfos, err := s.buildChannelInfos(channels)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.attachUnreadCounts(channelInfos, userID); err != nil {
+		return nil, err
+	}
+
+	return channelInfos, nil
+}
+
+// MarkChannelRead moves the user's read position in a channel to the given
+// message, or to the newest message when messageID is 0
+func (s *MessageService) MarkChannelRead(channelName string, userID, messageID uint) (*ReadPosition, error) {
+	channel, err := s.channelRepo.GetByName(channelName)
+	if err != nil {
+		return nil, errors.New("channel not found")
+	}
+
+	if err := checkChannelAccess(s.channelRepo, channel, userID); err != nil {
+		return nil, err
+	}
+
+	if messageID == 0 {
+		messageID, err = s.messageRepo.GetLatestIDByChannel(channel.ID)
 		if err != nil {
 			return nil, err
 		}
-		channelInfos[i] = *info
+	} else {
+		message, err := s.messageRepo.GetByID(messageID)
+		if err != nil || message.ChannelID == nil || *message.ChannelID != channel.ID {
+			return nil, errors.New("message not found")
+		}
 	}
 
-	return channelInfos, nil
+	if messageID != 0 {
+		if err := s.channelRepo.UpdateLastRead(channel.ID, userID, messageID); err != nil {
+			return nil, err
+		}
+	}
+
+	infos := []ChannelInfo{{ID: channel.ID, Name: channel.Name}}
+	if err := s.attachUnreadCounts(infos, userID); err != nil {
+		return nil, err
+	}
+
+	return &ReadPosition{
+		Channel:           channel.Name,
+		ChannelID:         channel.ID,
+		LastReadMessageID: infos[0].LastReadMessageID,
+		UnreadCount:       infos[0].UnreadCount,
+		MentionCount:      infos[0].MentionCount,
+	}, nil
+}
+
+// attachUnreadCounts fills in the user's read position and unread counts.
+// Anonymous users have no read positions.
+func (s *MessageService) attachUnreadCounts(infos []ChannelInfo, userID uint) error {
+	if userID == 0 || len(infos) == 0 {
+		return nil
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	channelIDs := make([]uint, len(infos))
+	for i, info := range infos {
+		channelIDs[i] = info.ID
+	}
+
+	lastReads, err := s.channelRepo.GetLastReads(userID, channelIDs)
+	if err != nil {
+		return err
+	}
+
+	counts, err := s.messageRepo.CountUnreadByChannels(channelIDs, userID, mentionPattern(user.Username))
+	if err != nil {
+		return err
+	}
+
+	byChannel := make(map[uint]repo.UnreadCount, len(counts))
+	for _, count := range counts {
+		byChannel[count.ChannelID] = count
+	}
+
+	for i := range infos {
+		infos[i].LastReadMessageID = lastReads[infos[i].ID]
+		infos[i].UnreadCount = byChannel[infos[i].ID].Unread
+		infos[i].MentionCount = byChannel[infos[i].ID].Mentions
+	}
+	return nil
+}
+
+// mentionPattern matches "@username" as a whole word
+func mentionPattern(username string) string {
+	return `(^|[^[:alnum:]_])@` + regexp.QuoteMeta(username) + `([^[:alnum:]_]|$)`
+}
+
+// EditMessage replaces the content of a message (only by the author), keeping
+// the previous content as a revision
+func (s *MessageService) EditMessage(messageID, userID uint, req EditMessageRequest) (*MessageResponse, error) {
+	message, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
+
+	if message.UserID != userID {
+		return nil, errors.New("unauthorized: can only edit your own messages")
+	}
+
+	// The author must still be able to post where the message lives
+	channel, err := s.accessibleMessageChannel(message, userID)
+	if err != nil {
+		return nil, err
+	}
+	if channel != nil && channel.IsArchived {
+		return nil, errors.New("channel is archived")
+	}
+
+	if message.Content != req.Content {
+		if err := s.messageRepo.UpdateContent(message, req.Content, time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+
+	response := toMessageResponse(message)
+	return &response, nil
+}
+
+// GetMessageRevisions returns the previous contents of a message the user can read
+func (s *MessageService) GetMessageRevisions(messageID, userID uint) ([]MessageRevisionResponse, error) {
+	message, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
+
+	if err := s.checkMessageAccess(message, userID); err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.messageRepo.GetRevisions(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]MessageRevisionResponse, len(revisions))
+	for i, revision := range revisions {
+		responses[i] = MessageRevisionResponse{
+			ID:        revision.ID,
+			Content:   revision.Content,
+			CreatedAt: revision.CreatedAt,
+		}
+	}
+
+	return responses, nil
 }
 
-// DeleteMessage deletes a message (only by the author)
-func (s *MessageService) DeleteMessage(messageID, userID uint) error {
+// AddReaction adds the user's emoji reaction to a message they can read
+func (s *MessageService) AddReaction(messageID, userID uint, req ReactionRequest) (*MessageResponse, *ReactionUpdate, error) {
+	message, user, err := s.getReactableMessage(messageID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reaction := models.Reaction{
+		MessageID: message.ID,
+		UserID:    userID,
+		Emoji:     req.Emoji,
+	}
+	if err := s.reactionRepo.Add(&reaction); err != nil {
+		return nil, nil, err
+	}
+
+	return s.reactionResult(message, user, req.Emoji)
+}
+
+// RemoveReaction removes the user's emoji reaction from a message
+func (s *MessageService) RemoveReaction(messageID, userID uint, req ReactionRequest) (*MessageResponse, *ReactionUpdate, error) {
+	message, user, err := s.getReactableMessage(messageID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.reactionRepo.Remove(message.ID, userID, req.Emoji); err != nil {
+		return nil, nil, err
+	}
+
+	return s.reactionResult(message, user, req.Emoji)
+}
+
+// getReactableMessage loads a message the user may react to
+func (s *MessageService) getReactableMessage(messageID, userID uint) (*models.Message, *models.User, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, nil, errors.New("user not found")
+	}
+
+	message, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return nil, nil, errors.New("message not found")
+	}
+
+	if err := s.checkMessageAccess(message, userID); err != nil {
+		return nil, nil, err
+	}
+
+	return message, user, nil
+}
+
+// reactionResult builds the outcome of a reaction change with the emoji's new total
+func (s *MessageService) reactionResult(message *models.Message, user *models.User, emoji string) (*MessageResponse, *ReactionUpdate, error) {
+	count, err := s.reactionRepo.CountByEmoji(message.ID, emoji)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response := toMessageResponse(message)
+	return &response, &ReactionUpdate{
+		MessageID: message.ID,
+		Emoji:     emoji,
+		Count:     count,
+		User: UserInfo{
+			ID:       user.ID,
+			Username: user.Username,
+		},
+	}, nil
+}
+
+// attachReactions fills in the aggregated reactions of each message
+func (s *MessageService) attachReactions(messages []MessageResponse, userID uint) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(messages))
+	index := make(map[uint]int, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+		index[msg.ID] = i
+	}
+
+	counts, err := s.reactionRepo.CountsByMessages(ids, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, count := range counts {
+		i := index[count.MessageID]
+		messages[i].Reactions = append(messages[i].Reactions, ReactionSummary{
+			Emoji:   count.Emoji,
+			Count:   count.Count,
+			Reacted: count.Reacted,
+		})
+	}
+
+	return nil
+}
+
+// GetMessageChannel returns the name of the channel, or the key of the
+// conversation, a message was posted to
+func (s *MessageService) GetMessageChannel(messageID uint) (string, error) {
+	message, err := s.messageRepo.GetByID(messageID)
+	if err != nil {
+		return "", errors.New("message not found")
+	}
+	return message.Channel, nil
+}
+
+// checkMessageAccess verifies the user may read the channel or conversation a message belongs to
+func (s *MessageService) checkMessageAccess(message *models.Message, userID uint) error {
+	_, err := s.accessibleMessageChannel(message, userID)
+	return err
+}
+
+// accessibleMessageChannel verifies the user may read the channel or
+// conversation a message belongs to and returns the channel, which is nil
+// for conversation messages
+func (s *MessageService) accessibleMessageChannel(message *models.Message, userID uint) (*models.Channel, error) {
+	if message.ConversationID != nil {
+		if _, err := s.conversationRepo.GetParticipant(*message.ConversationID, userID); err != nil {
+			return nil, errors.New("message not found")
+		}
+		return nil, nil
+	}
+
+	// The message's own channel, which is gone if it was deleted
+	if message.ChannelID == nil {
+		return nil, errors.New("message not found")
+	}
+	channel, err := s.channelRepo.GetByID(*message.ChannelID)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
+	if err := checkChannelAccess(s.channelRepo, channel, userID); err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// DeleteMessage deletes a message (only by the author) and returns its tombstone
+func (s *MessageService) DeleteMessage(messageID, userID uint) (*MessageResponse, error) {
 	// Get message to verify ownership
 	message, err := s.messageRepo.GetByID(messageID)
 	if err != nil {
-		return errors.New("message not found")
+		return nil, errors.New("message not found")
 	}
 
 	// Check if user is the author
 	if message.UserID != userID {
-		return errors.New("unauthorized: can only delete your own messages")
+		return nil, errors.New("unauthorized: can only delete your own messages")
+	}
+
+	if err := s.messageRepo.Delete(messageID); err != nil {
+		return nil, err
+	}
+
+	if message.ParentID != nil {
+		if err := s.messageRepo.DecrementReplyCount(*message.ParentID); err != nil {
+			return nil, err
+		}
+	}
+
+	message.DeletedAt = gorm.DeletedAt{Time: time.Now().UTC(), Valid: true}
+	tombstone := toMessageResponse(message)
+	return &tombstone, nil
+}
+
+// toMessageResponse converts a message with its preloaded user to the API format.
+// Deleted messages become tombstones without content.
+func toMessageResponse(msg *models.Message) MessageResponse {
+	if msg.DeletedAt.Valid {
+		deletedAt := msg.DeletedAt.Time
+		return MessageResponse{
+			ID:             msg.ID,
+			Channel:        msg.Channel,
+			ConversationID: msg.ConversationID,
+			CreatedAt:      msg.CreatedAt,
+			DeletedAt:      &deletedAt,
+			IsDeleted:      true,
+			ParentID:       msg.ParentID,
+			ReplyCount:     msg.ReplyCount,
+			LastReplyAt:    msg.LastReplyAt,
+			ClientMsgID:    clientMsgID(msg),
+			User: UserInfo{
+				ID:       msg.User.ID,
+				Username: msg.User.Username,
+			},
+		}
 	}
 
-	return s.messageRepo.Delete(messageID)
-}
\ No newline at end of file
+	return MessageResponse{
+		ID:             msg.ID,
+		Content:        msg.Content,
+		Channel:        msg.Channel,
+		ConversationID: msg.ConversationID,
+		CreatedAt:      msg.CreatedAt,
+		EditedAt:       msg.EditedAt,
+		ParentID:       msg.ParentID,
+		ReplyCount:     msg.ReplyCount,
+		LastReplyAt:    msg.LastReplyAt,
+		ClientMsgID:    clientMsgID(msg),
+		User: UserInfo{
+			ID:       msg.User.ID,
+			Username: msg.User.Username,
+		},
+	}
+}
+
+// clientMsgID returns the client generated ID of a message, if any
+func clientMsgID(msg *models.Message) string {
+	if msg.ClientMsgID == nil {
+		return ""
+	}
+	return *msg.ClientMsgID
+}
+
+// Directions of a history cursor
+const (
+	cursorBefore = "before"
+	cursorAfter  = "after"
+)
+
+// encodeCursor builds the opaque cursor pointing before or after a message
+func encodeCursor(direction string, messageID uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", direction, messageID)))
+}
+
+// decodeCursor parses a cursor created by encodeCursor
+func decodeCursor(cursor string) (string, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, errors.New("invalid cursor")
+	}
+
+	direction, id, found := strings.Cut(string(raw), ":")
+	if !found || (direction != cursorBefore && direction != cursorAfter) {
+		return "", 0, errors.New("invalid cursor")
+	}
+
+	messageID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || messageID == 0 {
+		return "", 0, errors.New("invalid cursor")
+	}
+	return direction, uint(messageID), nil
+}
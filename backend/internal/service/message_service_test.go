@@ -0,0 +1,534 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"chatapp/internal/database"
+	"chatapp/internal/models"
+	"chatapp/internal/repo"
+)
+
+// newTestMessageService creates a message service on a test database
+func newTestMessageService(t *testing.T) *MessageService {
+	t.Helper()
+
+	setupTestDB(t)
+	return NewMessageService(repo.NewMessageRepository(), repo.NewUserRepository(), repo.NewChannelRepository(), repo.NewConversationRepository(), repo.NewReactionRepository())
+}
+
+func createTestUser(t *testing.T, username string) *models.User {
+	t.Helper()
+
+	user := models.User{Username: username, Email: username + "@example.com", Password: "x"}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return &user
+}
+
+// postMessages posts count messages to a channel and returns their IDs
+func postMessages(t *testing.T, s *MessageService, userID uint, channel string, count int) []uint {
+	t.Helper()
+
+	ids := make([]uint, count)
+	for i := range ids {
+		msg, _, err := s.CreateMessage(userID, CreateMessageRequest{Content: "message", Channel: channel})
+		if err != nil {
+			t.Fatalf("CreateMessage: %v", err)
+		}
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+func responseIDs(messages []MessageResponse) []uint {
+	ids := make([]uint, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+func equalIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGetMissedMessages(t *testing.T) {
+	// Messages 1-5; while the client was away 1 was edited, 2 deleted, 3
+	// reacted to, and 4 and 5 posted
+	tests := []struct {
+		name          string
+		lastSeen      int // index of the last seen message, -1 for none
+		limit         int
+		wantMessages  []int
+		wantUpdated   []int
+		wantTruncated bool
+	}{
+		{name: "gap", lastSeen: 2, limit: 10, wantMessages: []int{3, 4}, wantUpdated: []int{0, 1, 2}},
+		{name: "caught up", lastSeen: 4, limit: 10, wantUpdated: []int{0, 1, 2}},
+		{name: "nothing seen", lastSeen: -1, limit: 10, wantMessages: []int{0, 2, 3, 4}},
+		{name: "truncated", lastSeen: 2, limit: 1, wantMessages: []int{3}, wantUpdated: []int{0}, wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestMessageService(t)
+			alice := createTestUser(t, "alice")
+			bob := createTestUser(t, "bob")
+			ids := postMessages(t, s, alice.ID, "general", 5)
+
+			// Messages 1-3 were posted an hour ago, 4 and 5 during the gap
+			base := time.Now().Add(-time.Hour)
+			for i, id := range ids[:3] {
+				at := base.Add(time.Duration(i) * time.Second)
+				database.DB.Model(&models.Message{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"created_at": at, "updated_at": at})
+			}
+
+			if _, err := s.EditMessage(ids[0], alice.ID, EditMessageRequest{Content: "edited"}); err != nil {
+				t.Fatalf("EditMessage: %v", err)
+			}
+			if _, err := s.DeleteMessage(ids[1], alice.ID); err != nil {
+				t.Fatalf("DeleteMessage: %v", err)
+			}
+			if _, _, err := s.AddReaction(ids[2], bob.ID, ReactionRequest{Emoji: "👍"}); err != nil {
+				t.Fatalf("AddReaction: %v", err)
+			}
+
+			var lastSeenID uint
+			if tt.lastSeen >= 0 {
+				lastSeenID = ids[tt.lastSeen]
+			}
+			missed, err := s.GetMissedMessages("general", bob.ID, lastSeenID, tt.limit)
+			if err != nil {
+				t.Fatalf("GetMissedMessages: %v", err)
+			}
+
+			pick := func(indexes []int) []uint {
+				want := make([]uint, len(indexes))
+				for i, index := range indexes {
+					want[i] = ids[index]
+				}
+				return want
+			}
+			if got, want := responseIDs(missed.Messages), pick(tt.wantMessages); !equalIDs(got, want) {
+				t.Errorf("messages = %v, want %v", got, want)
+			}
+			if got, want := responseIDs(missed.Updated), pick(tt.wantUpdated); !equalIDs(got, want) {
+				t.Errorf("updated = %v, want %v", got, want)
+			}
+			if missed.Truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", missed.Truncated, tt.wantTruncated)
+			}
+
+			for _, msg := range missed.Updated {
+				switch msg.ID {
+				case ids[0]:
+					if msg.Content != "edited" || msg.EditedAt == nil {
+						t.Errorf("edited message = %+v, want the new content", msg)
+					}
+				case ids[1]:
+					if msg.DeletedAt == nil || msg.Content != "" {
+						t.Errorf("deleted message = %+v, want a tombstone", msg)
+					}
+				case ids[2]:
+					if len(msg.Reactions) != 1 || msg.Reactions[0].Count != 1 || !msg.Reactions[0].Reacted {
+						t.Errorf("reactions = %+v, want bob's reaction", msg.Reactions)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEditMessageAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel func(channelRepo *repo.ChannelRepository, channel *models.Channel, author uint)
+		editor  string
+		wantErr string
+	}{
+		{name: "author in a public channel"},
+		{name: "other user", editor: "bob", wantErr: "unauthorized: can only edit your own messages"},
+		{
+			name: "author left the private channel",
+			channel: func(channelRepo *repo.ChannelRepository, channel *models.Channel, author uint) {
+				channel.Visibility = models.ChannelVisibilityPrivate
+				channelRepo.Update(channel)
+				channelRepo.RemoveMember(channel.ID, author)
+			},
+			wantErr: "access denied: not a member of this channel",
+		},
+		{
+			name: "archived channel",
+			channel: func(channelRepo *repo.ChannelRepository, channel *models.Channel, author uint) {
+				channel.IsArchived = true
+				channelRepo.Update(channel)
+			},
+			wantErr: "channel is archived",
+		},
+		{
+			name: "deleted channel",
+			channel: func(channelRepo *repo.ChannelRepository, channel *models.Channel, author uint) {
+				channelRepo.Delete(channel.ID)
+			},
+			wantErr: "message not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestMessageService(t)
+			channelRepo := repo.NewChannelRepository()
+			users := map[string]*models.User{
+				"alice": createTestUser(t, "alice"),
+				"bob":   createTestUser(t, "bob"),
+			}
+
+			channel := models.Channel{Name: "team", Visibility: models.ChannelVisibilityPublic}
+			if err := channelRepo.Create(&channel); err != nil {
+				t.Fatalf("failed to create channel: %v", err)
+			}
+			channelRepo.AddMember(&models.ChannelMember{ChannelID: channel.ID, UserID: users["alice"].ID})
+			id := postMessages(t, s, users["alice"].ID, "team", 1)[0]
+
+			if tt.channel != nil {
+				tt.channel(channelRepo, &channel, users["alice"].ID)
+			}
+			editor := users["alice"]
+			if tt.editor != "" {
+				editor = users[tt.editor]
+			}
+
+			edited, err := s.EditMessage(id, editor.ID, EditMessageRequest{Content: "edited"})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("EditMessage error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EditMessage: %v", err)
+			}
+			if edited.Content != "edited" {
+				t.Errorf("content = %q, want %q", edited.Content, "edited")
+			}
+		})
+	}
+}
+
+func TestEditConversationMessage(t *testing.T) {
+	s := newTestMessageService(t)
+	conversations := NewConversationService(repo.NewConversationRepository(), repo.NewMessageRepository(), repo.NewUserRepository())
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	sent, err := conversations.SendDirectMessage(alice.ID, bob.ID, SendConversationMessageRequest{Content: "hi"})
+	if err != nil {
+		t.Fatalf("SendDirectMessage: %v", err)
+	}
+
+	if _, err := s.EditMessage(sent.Message.ID, alice.ID, EditMessageRequest{Content: "hello"}); err != nil {
+		t.Errorf("participant EditMessage: %v", err)
+	}
+}
+
+func TestGetMessagesByChannelKeyset(t *testing.T) {
+	s := newTestMessageService(t)
+	alice := createTestUser(t, "alice")
+	ids := postMessages(t, s, alice.ID, "general", 7) // ids[0] oldest
+
+	// A reply is never part of the channel pages
+	if _, _, err := s.CreateMessage(alice.ID, CreateMessageRequest{Content: "reply", Channel: "general", ParentID: &ids[3]}); err != nil {
+		t.Fatalf("CreateMessage reply: %v", err)
+	}
+
+	pick := func(indexes ...int) []uint {
+		want := make([]uint, len(indexes))
+		for i, index := range indexes {
+			want[i] = ids[index]
+		}
+		return want
+	}
+
+	tests := []struct {
+		name      string
+		req       MessagePageRequest
+		want      []uint
+		wantOlder bool
+		wantNewer bool
+		wantErr   string
+	}{
+		{name: "newest page", req: MessagePageRequest{Limit: 3}, want: pick(6, 5, 4), wantOlder: true},
+		{name: "before", req: MessagePageRequest{BeforeID: ids[4], Limit: 3}, want: pick(3, 2, 1), wantOlder: true, wantNewer: true},
+		{name: "before the oldest page", req: MessagePageRequest{BeforeID: ids[2], Limit: 3}, want: pick(1, 0), wantNewer: true},
+		{name: "after", req: MessagePageRequest{AfterID: ids[1], Limit: 3}, want: pick(4, 3, 2), wantOlder: true, wantNewer: true},
+		{name: "after to the newest", req: MessagePageRequest{AfterID: ids[3], Limit: 3}, want: pick(6, 5, 4), wantOlder: true},
+		{name: "around", req: MessagePageRequest{AroundID: ids[3], Limit: 4}, want: pick(5, 4, 3, 2), wantOlder: true, wantNewer: true},
+		{name: "cursor wins over IDs", req: MessagePageRequest{Cursor: encodeCursor(cursorBefore, ids[1]), BeforeID: ids[6], Limit: 3}, want: pick(0), wantNewer: true},
+		{name: "invalid cursor", req: MessagePageRequest{Cursor: "not-a-cursor", Limit: 3}, wantErr: "invalid cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := s.GetMessagesByChannel("general", alice.ID, tt.req)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("GetMessagesByChannel error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetMessagesByChannel: %v", err)
+			}
+
+			if got := responseIDs(page.Messages); !equalIDs(got, tt.want) {
+				t.Errorf("messages = %v, want %v", got, tt.want)
+			}
+			if page.HasMore != tt.wantOlder || page.HasNewer != tt.wantNewer {
+				t.Errorf("has_more = %v, has_newer = %v, want %v, %v", page.HasMore, page.HasNewer, tt.wantOlder, tt.wantNewer)
+			}
+			if (page.NextCursor != "") != tt.wantOlder || (page.PrevCursor != "") != tt.wantNewer {
+				t.Errorf("next_cursor = %q, prev_cursor = %q, want cursors only where more messages exist", page.NextCursor, page.PrevCursor)
+			}
+		})
+	}
+
+	// Following the cursors walks the whole history both ways
+	var walked []uint
+	req := MessagePageRequest{Limit: 3}
+	for {
+		page, err := s.GetMessagesByChannel("general", alice.ID, req)
+		if err != nil {
+			t.Fatalf("GetMessagesByChannel: %v", err)
+		}
+		walked = append(walked, responseIDs(page.Messages)...)
+		if page.NextCursor == "" {
+			break
+		}
+		req = MessagePageRequest{Cursor: page.NextCursor, Limit: 3}
+	}
+	if want := pick(6, 5, 4, 3, 2, 1, 0); !equalIDs(walked, want) {
+		t.Errorf("walked older = %v, want %v", walked, want)
+	}
+
+	walked = nil
+	req = MessagePageRequest{AfterID: ids[0], Limit: 3}
+	for {
+		page, err := s.GetMessagesByChannel("general", alice.ID, req)
+		if err != nil {
+			t.Fatalf("GetMessagesByChannel: %v", err)
+		}
+		walked = append(responseIDs(page.Messages), walked...)
+		if page.PrevCursor == "" {
+			break
+		}
+		req = MessagePageRequest{Cursor: page.PrevCursor, Limit: 3}
+	}
+	if want := pick(6, 5, 4, 3, 2, 1); !equalIDs(walked, want) {
+		t.Errorf("walked newer = %v, want %v", walked, want)
+	}
+}
+
+func TestGetAvailableChannelsStats(t *testing.T) {
+	s := newTestMessageService(t)
+	channelRepo := repo.NewChannelRepository()
+	alice := createTestUser(t, "alice")
+
+	for _, name := range []string{"busy", "quiet"} {
+		if err := channelRepo.Create(&models.Channel{Name: name, Visibility: models.ChannelVisibilityPublic}); err != nil {
+			t.Fatalf("failed to create channel: %v", err)
+		}
+	}
+
+	// busy: three top-level messages, the newest deleted, plus a reply
+	ids := postMessages(t, s, alice.ID, "busy", 3)
+	if _, _, err := s.CreateMessage(alice.ID, CreateMessageRequest{Content: "reply", Channel: "busy", ParentID: &ids[0]}); err != nil {
+		t.Fatalf("CreateMessage reply: %v", err)
+	}
+	if _, err := s.DeleteMessage(ids[2], alice.ID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	generalIDs := postMessages(t, s, alice.ID, "general", 1)
+
+	infos, err := s.GetAvailableChannels(0, false)
+	if err != nil {
+		t.Fatalf("GetAvailableChannels: %v", err)
+	}
+
+	tests := []struct {
+		channel   string
+		wantCount int64
+		wantLast  uint
+	}{
+		{channel: "busy", wantCount: 2, wantLast: ids[1]},
+		{channel: "general", wantCount: 1, wantLast: generalIDs[0]},
+		{channel: "quiet", wantCount: 0},
+	}
+
+	byName := make(map[string]ChannelInfo, len(infos))
+	for _, info := range infos {
+		byName[info.Name] = info
+	}
+	for _, tt := range tests {
+		info, ok := byName[tt.channel]
+		if !ok {
+			t.Errorf("channel %s missing from %v", tt.channel, infos)
+			continue
+		}
+		if info.MessageCount != tt.wantCount {
+			t.Errorf("%s message_count = %d, want %d", tt.channel, info.MessageCount, tt.wantCount)
+		}
+		var last uint
+		if info.LastMessage != nil {
+			last = info.LastMessage.ID
+			if info.LastMessage.User.Username != "alice" {
+				t.Errorf("%s last message user = %q, want alice", tt.channel, info.LastMessage.User.Username)
+			}
+		}
+		if last != tt.wantLast {
+			t.Errorf("%s last message = %d, want %d", tt.channel, last, tt.wantLast)
+		}
+	}
+}
+
+func TestCreateMessageDeduplicatesRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		retry       func(parentID uint) CreateMessageRequest
+		sender      string
+		wantCreated bool
+		wantSame    bool
+		wantErr     string
+	}{
+		{
+			name: "retry returns the saved message",
+			retry: func(uint) CreateMessageRequest {
+				return CreateMessageRequest{Content: "hi", Channel: "general", ClientMsgID: "m1"}
+			},
+			sender:   "alice",
+			wantSame: true,
+		},
+		{
+			name: "retry with edited content still returns the saved message",
+			retry: func(uint) CreateMessageRequest {
+				return CreateMessageRequest{Content: "hi!", Channel: "general", ClientMsgID: "m1"}
+			},
+			sender:   "alice",
+			wantSame: true,
+		},
+		{
+			name: "ID reused in another channel",
+			retry: func(uint) CreateMessageRequest {
+				return CreateMessageRequest{Content: "hi", Channel: "random", ClientMsgID: "m1"}
+			},
+			sender:  "alice",
+			wantErr: "client_msg_id already used for another message",
+		},
+		{
+			name: "ID reused for a reply",
+			retry: func(parentID uint) CreateMessageRequest {
+				return CreateMessageRequest{Content: "hi", Channel: "general", ClientMsgID: "m1", ParentID: &parentID}
+			},
+			sender:  "alice",
+			wantErr: "client_msg_id already used for another message",
+		},
+		{
+			name: "same ID from another user",
+			retry: func(uint) CreateMessageRequest {
+				return CreateMessageRequest{Content: "hi", Channel: "general", ClientMsgID: "m1"}
+			},
+			sender:      "bob",
+			wantCreated: true,
+		},
+		{
+			name:        "no ID always posts",
+			retry:       func(uint) CreateMessageRequest { return CreateMessageRequest{Content: "hi", Channel: "general"} },
+			sender:      "alice",
+			wantCreated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestMessageService(t)
+			users := map[string]*models.User{
+				"alice": createTestUser(t, "alice"),
+				"bob":   createTestUser(t, "bob"),
+			}
+			if err := repo.NewChannelRepository().Create(&models.Channel{Name: "random", Visibility: models.ChannelVisibilityPublic}); err != nil {
+				t.Fatalf("failed to create channel: %v", err)
+			}
+
+			parentID := postMessages(t, s, users["alice"].ID, "general", 1)[0]
+			first, created, err := s.CreateMessage(users["alice"].ID, CreateMessageRequest{Content: "hi", Channel: "general", ClientMsgID: "m1"})
+			if err != nil || !created {
+				t.Fatalf("first send: created = %v, err = %v", created, err)
+			}
+
+			req := tt.retry(parentID)
+
+			// FindSentMessage answers retries before the rate limit is applied
+			_, found, _ := s.FindSentMessage(users[tt.sender].ID, req)
+			if wantFound := !tt.wantCreated; found != wantFound {
+				t.Errorf("FindSentMessage found = %v, want %v", found, wantFound)
+			}
+
+			msg, created, err := s.CreateMessage(users[tt.sender].ID, req)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("CreateMessage error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateMessage: %v", err)
+			}
+			if created != tt.wantCreated {
+				t.Errorf("created = %v, want %v", created, tt.wantCreated)
+			}
+			if same := msg.ID == first.ID; same != tt.wantSame {
+				t.Errorf("message %d, first %d: same = %v, want %v", msg.ID, first.ID, same, tt.wantSame)
+			}
+			if tt.wantSame && (msg.Content != "hi" || msg.ClientMsgID != "m1") {
+				t.Errorf("retry returned %+v, want the saved message", msg)
+			}
+		})
+	}
+}
+
+func TestRetryAfterLosingAccess(t *testing.T) {
+	s := newTestMessageService(t)
+	channelRepo := repo.NewChannelRepository()
+	alice := createTestUser(t, "alice")
+
+	channel := models.Channel{Name: "team", Visibility: models.ChannelVisibilityPrivate}
+	if err := channelRepo.Create(&channel); err != nil {
+		t.Fatalf("failed to create channel: %v", err)
+	}
+	if err := channelRepo.AddMember(&models.ChannelMember{ChannelID: channel.ID, UserID: alice.ID}); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+
+	req := CreateMessageRequest{Content: "hi", Channel: "team", ClientMsgID: "m1"}
+	if _, _, err := s.CreateMessage(alice.ID, req); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	if err := channelRepo.RemoveMember(channel.ID, alice.ID); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+
+	// A retry must not reveal the message once the sender left the channel
+	_, found, err := s.FindSentMessage(alice.ID, req)
+	if !found || err == nil || err.Error() != "access denied: not a member of this channel" {
+		t.Errorf("FindSentMessage found = %v, err = %v, want access denied", found, err)
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"chatapp/internal/database"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB points database.DB at a migrated in-memory SQLite database of
+// its own for the test. Repositories must be created after it.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+
+	dsn := "file:" + url.PathEscape(t.Name()) + "?mode=memory&cache=shared"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get test database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+}
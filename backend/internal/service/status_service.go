@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"chatapp/internal/models"
+	"chatapp/internal/repo"
+)
+
+type StatusService struct {
+	statusRepo *repo.UserStatusRepository
+	userRepo   *repo.UserRepository
+}
+
+type SetStatusRequest struct {
+	Status    string     `json:"status" binding:"omitempty,oneof=active away dnd"`
+	Text      string     `json:"text" binding:"max=100"`
+	Emoji     string     `json:"emoji" binding:"max=64"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// StatusResponse is the status of a user as other users see it. Status is
+// the effective status: away while idle unless the user chose dnd.
+type StatusResponse struct {
+	User      UserInfo   `json:"user"`
+	Status    string     `json:"status"`
+	Text      string     `json:"text,omitempty"`
+	Emoji     string     `json:"emoji,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Idle      bool       `json:"idle"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// StatusChange is a changed status to notify every connected user of. Like
+// presence it is public: every user can be seen in the public channels, which
+// have no member rows to narrow the audience down.
+type StatusChange struct {
+	Status *StatusResponse
+}
+
+func NewStatusService(statusRepo *repo.UserStatusRepository, userRepo *repo.UserRepository) *StatusService {
+	return &StatusService{
+		statusRepo: statusRepo,
+		userRepo:   userRepo,
+	}
+}
+
+// GetStatus returns a user's current status
+func (s *StatusService) GetStatus(userID uint) (*StatusResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	status, err := s.statusRepo.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	return toStatusResponse(status, user), nil
+}
+
+// SetStatus stores the status and custom text a user chose
+func (s *StatusService) SetStatus(userID uint, req SetStatusRequest) (*StatusChange, error) {
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	status := models.UserStatus{
+		UserID:    userID,
+		Status:    req.Status,
+		Text:      req.Text,
+		Emoji:     req.Emoji,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if status.Status == "" {
+		status.Status = models.UserStatusActive
+	}
+
+	if err := s.statusRepo.Save(&status); err != nil {
+		return nil, err
+	}
+	return s.buildChange(userID)
+}
+
+// ClearStatus resets a user's status to active without custom text
+func (s *StatusService) ClearStatus(userID uint) (*StatusChange, error) {
+	if _, err := s.statusRepo.Reset(userID, false, time.Now()); err != nil {
+		return nil, err
+	}
+	return s.buildChange(userID)
+}
+
+// SetIdle marks a user idle or active again. It returns nil when the flag
+// did not change, for example because another instance already updated it.
+func (s *StatusService) SetIdle(userID uint, idle bool) (*StatusChange, error) {
+	changed, err := s.statusRepo.SetIdle(userID, idle)
+	if err != nil || !changed {
+		return nil, err
+	}
+	return s.buildChange(userID)
+}
+
+// ExpireStatuses resets the statuses whose expiry passed and returns the
+// resulting changes. A status reset by another instance is skipped.
+func (s *StatusService) ExpireStatuses() ([]*StatusChange, error) {
+	now := time.Now()
+	userIDs, err := s.statusRepo.ListExpiredUserIDs(now)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]*StatusChange, 0, len(userIDs))
+	for _, userID := range userIDs {
+		reset, err := s.statusRepo.Reset(userID, true, now)
+		if err != nil {
+			return changes, err
+		}
+		if !reset {
+			continue
+		}
+
+		change, err := s.buildChange(userID)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// buildChange loads a user's changed status
+func (s *StatusService) buildChange(userID uint) (*StatusChange, error) {
+	status, err := s.GetStatus(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatusChange{Status: status}, nil
+}
+
+// toStatusResponse converts a stored status to its API representation. An
+// expired status that was not reset yet is shown as active.
+func toStatusResponse(status *models.UserStatus, user *models.User) *StatusResponse {
+	if status.IsExpired(time.Now()) {
+		status = &models.UserStatus{
+			UserID:    status.UserID,
+			Status:    models.UserStatusActive,
+			Idle:      status.Idle,
+			UpdatedAt: status.UpdatedAt,
+		}
+	}
+
+	return &StatusResponse{
+		User: UserInfo{
+			ID:       user.ID,
+			Username: user.Username,
+		},
+		Status:    status.Effective(),
+		Text:      status.Text,
+		Emoji:     status.Emoji,
+		ExpiresAt: status.ExpiresAt,
+		Idle:      status.Idle,
+		UpdatedAt: status.UpdatedAt,
+	}
+}
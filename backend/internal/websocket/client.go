@@ -1,14 +1,19 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
+	"chatapp/internal/models"
 	"chatapp/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"golang.org/x/time/rate"
 	"nhooyr.io/websocket"
 )
 
@@ -24,6 +29,16 @@ const (
 
 	// Maximum message size allowed from peer
 	maxMessageSize = 512
+
+	// Channel joined on connect when the client does not request any
+	defaultChannel = "general"
+
+	// Maximum length of a client generated message ID
+	maxClientMsgIDLength = 64
+
+	// Frames a connection may send per second, and in a burst
+	frameRate  = 20
+	frameBurst = 40
 )
 
 // Client is a middleman between the websocket connection and the hub
@@ -45,35 +60,87 @@ type Client struct {
 	Username string
 	Email    string
 
+	// Login session the connection was authenticated with
+	SessionID string
+
+	// Closed by shutdown to make the write pump close the connection;
+	// closeStatus and closeReason are set before it is closed
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeStatus websocket.StatusCode
+	closeReason string
+
+	// Channels this client has joined (guarded by the hub mutex)
+	channels map[string]bool
+
+	// Live messages held back per channel while missed messages are
+	// replayed (guarded by the hub mutex)
+	replaying map[string][][]byte
+
+	// Limits how fast this connection may send frames
+	frames *rate.Limiter
+
 	// Gin context for request handling
 	ctx *gin.Context
 }
 
 // IncomingMessage represents a message received from the client
 type IncomingMessage struct {
-	Type    string `json:"type"`
-	Channel string `json:"channel"`
-	Content string `json:"content"`
+	Version        int    `json:"v,omitempty"`
+	Type           string `json:"type"`
+	RequestID      string `json:"request_id,omitempty"`
+	Channel        string `json:"channel"`
+	Content        string `json:"content"`
+	RecipientID    uint   `json:"recipient_id,omitempty"`
+	ConversationID uint   `json:"conversation_id,omitempty"`
+	MessageID      uint   `json:"message_id,omitempty"`
+	ParentID       uint   `json:"parent_id,omitempty"`
+	Emoji          string `json:"emoji,omitempty"`
+	ClientMsgID    string `json:"client_msg_id,omitempty"`
 }
 
 // NewClient creates a new WebSocket client
-func NewClient(hub *Hub, conn *websocket.Conn, userID uint, username, email string, ctx *gin.Context) *Client {
+func NewClient(hub *Hub, conn *websocket.Conn, userID uint, username, email, sessionID string, ctx *gin.Context) *Client {
 	return &Client{
-		conn:     conn,
-		send:     make(chan []byte, 256),
-		hub:      hub,
-		ID:       uuid.New().String(),
-		UserID:   userID,
-		Username: username,
-		Email:    email,
-		ctx:      ctx,
+		conn:      conn,
+		send:      make(chan []byte, 256),
+		done:      make(chan struct{}),
+		hub:       hub,
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Username:  username,
+		Email:     email,
+		SessionID: sessionID,
+		channels:  make(map[string]bool),
+		replaying: make(map[string][][]byte),
+		frames:    rate.NewLimiter(frameRate, frameBurst),
+		ctx:       ctx,
+	}
+}
+
+// initialChannels returns the channels requested with the "channel" query
+// parameter (comma separated), falling back to the default channel
+func (c *Client) initialChannels() []string {
+	var channels []string
+	if c.ctx != nil {
+		for _, name := range strings.Split(c.ctx.Query("channel"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				channels = append(channels, name)
+			}
+		}
+	}
+
+	if len(channels) == 0 {
+		channels = []string{defaultChannel}
 	}
+	return channels
 }
 
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
+		c.hub.presence.disconnect(c)
 		c.conn.Close(websocket.StatusInternalError, "Connection closed")
 	}()
 
@@ -100,11 +167,25 @@ func (c *Client) readPump() {
 		if err := json.Unmarshal(message, &incomingMsg); err != nil {
 			log.Printf("❌ Error parsing message from client %s: %v", c.ID, err)
 			log.Printf("❌ Raw message that failed to parse: %s", string(message))
+			c.sendError(incomingMsg, validationError("invalid JSON: %v", err))
 			continue
 		}
 
 		log.Printf("✅ Successfully parsed message from client %s: %+v", c.ID, incomingMsg)
 
+		// 接続ごとのフレーム数制限（ユーザー単位のメッセージ制限とは別）
+		if now := time.Now(); !c.frames.AllowN(now, 1) {
+			log.Printf("🚦 Client %s is sending too fast, dropping %s frame", c.ID, incomingMsg.Type)
+			retryAfter := time.Duration((1 - c.frames.TokensAt(now)) / frameRate * float64(time.Second))
+			c.sendError(incomingMsg, rateLimitedError(retryAfter))
+			continue
+		}
+
+		// Anything but a keepalive counts as user activity for away detection
+		if incomingMsg.Type != "ping" {
+			c.hub.status.touch(c.UserID, false)
+		}
+
 		// Handle different message types
 		c.handleMessage(incomingMsg)
 	}
@@ -135,6 +216,12 @@ func (c *Client) writePump() {
 				return
 			}
 
+		case <-c.done:
+			// Deliver what was queued first, such as the reason for the shutdown
+			c.flush()
+			c.conn.Close(c.closeStatus, c.closeReason)
+			return
+
 		case <-ticker.C:
 			// Send ping
 			if err := c.conn.Ping(ctx); err != nil {
@@ -145,74 +232,399 @@ func (c *Client) writePump() {
 	}
 }
 
-// handleMessage handles incoming messages from the client
+// flush writes the messages already queued, giving up after writeWait
+func (c *Client) flush() {
+	ctx, cancel := context.WithTimeout(c.ctx.Request.Context(), writeWait)
+	defer cancel()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if err := c.conn.Write(ctx, websocket.MessageText, message); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// shutdown makes the write pump close the connection with the given status.
+// The read pump then fails and unregisters the client, which is what removes
+// it from the hub and closes send. It is safe to call more than once and from
+// any goroutine.
+func (c *Client) shutdown(status websocket.StatusCode, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeStatus = status
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+// closeSlow shuts down a client that does not keep up with its messages
+func (c *Client) closeSlow() {
+	c.shutdown(websocket.StatusTryAgainLater, "Send buffer full")
+}
+
+// handleMessage handles incoming messages from the client and answers them
+// with an ack or error frame (see protocol.go)
 func (c *Client) handleMessage(msg IncomingMessage) {
+	if msg.Version > ProtocolVersion {
+		c.sendError(msg, validationError("unsupported protocol version %d", msg.Version))
+		return
+	}
+
+	var ack *AckData
+	var err error
+
 	switch msg.Type {
 	case "chat_message":
-		c.handleChatMessage(msg)
+		ack, err = c.handleChatMessage(msg)
+	case "direct_message":
+		ack, err = c.handleDirectMessage(msg)
+	case "group_message":
+		ack, err = c.handleGroupMessage(msg)
+	case "edit_message":
+		err = c.handleEditMessage(msg)
+	case "delete_message":
+		err = c.handleDeleteMessage(msg)
+	case "add_reaction":
+		err = c.handleReaction(msg, true)
+	case "remove_reaction":
+		err = c.handleReaction(msg, false)
+	case "mark_read":
+		err = c.handleMarkRead(msg)
+	case "typing_start":
+		err = c.handleTyping(msg, true)
+	case "typing_stop":
+		err = c.handleTyping(msg, false)
+	case "subscribe":
+		err = c.handleSubscribe(msg)
+	case "resume":
+		err = c.handleResume(msg)
+	case "unsubscribe":
+		err = c.handleUnsubscribe(msg)
 	case "ping":
 		c.handlePing()
 	case "get_users":
 		c.handleGetUsers()
 	default:
 		log.Printf("Unknown message type from client %s: %s", c.ID, msg.Type)
+		err = validationError("unknown message type %q", msg.Type)
+	}
+
+	if err != nil {
+		c.sendError(msg, err)
+		return
+	}
+	if ack != nil || msg.RequestID != "" {
+		c.sendAck(msg, ack)
 	}
 }
 
-// handleChatMessage handles chat messages
-func (c *Client) handleChatMessage(msg IncomingMessage) {
+// handleChatMessage handles chat messages. The sender is always acknowledged
+// with the ID of the stored message.
+func (c *Client) handleChatMessage(msg IncomingMessage) (*AckData, error) {
 	log.Printf("🔍 handleChatMessage called by client %s (UserID: %d)", c.ID, c.UserID)
 	log.Printf("🔍 Message details: Type=%s, Channel=%s, Content=%s", msg.Type, msg.Channel, msg.Content)
 
 	if msg.Content == "" || msg.Channel == "" {
 		log.Printf("❌ Invalid chat message from client %s: empty content or channel", c.ID)
-		return
+		return nil, validationError("content and channel are required")
+	}
+	if len(msg.ClientMsgID) > maxClientMsgIDLength {
+		log.Printf("❌ Invalid chat message from client %s: client_msg_id too long", c.ID)
+		return nil, validationError("client_msg_id is too long")
 	}
 
-	// Save message to database first
-	log.Printf("💾 Saving message to database via MessageService...")
 	messageReq := service.CreateMessageRequest{
-		Content: msg.Content,
-		Channel: msg.Channel,
+		Content:     msg.Content,
+		Channel:     msg.Channel,
+		ClientMsgID: msg.ClientMsgID,
+	}
+	if msg.ParentID != 0 {
+		messageReq.ParentID = &msg.ParentID
+	}
+
+	// Answer a retried send with the saved message without using up the rate limit
+	if savedMessage, found, err := c.hub.messageService.FindSentMessage(c.UserID, messageReq); found {
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("🔁 Duplicate send of message %d (client_msg_id %s) from client %s", savedMessage.ID, msg.ClientMsgID, c.ID)
+		return messageAck(savedMessage, true), nil
+	}
+
+	if err := c.allowWrite(msg.Channel); err != nil {
+		return nil, err
 	}
 
-	savedMessage, err := c.hub.messageService.CreateMessage(c.UserID, messageReq)
+	// Save message to database first
+	log.Printf("💾 Saving message to database via MessageService...")
+	savedMessage, created, err := c.hub.messageService.CreateMessage(c.UserID, messageReq)
 	if err != nil {
 		log.Printf("❌ Failed to save message to database: %v", err)
-		return
+		return nil, err
 	}
 
-	log.Printf("✅ Message saved to database with ID: %d", savedMessage.ID)
-
-	// Create message for broadcasting using the saved message data
-	chatMsg := Message{
-		Type:    "chat_message",
-		Channel: msg.Channel,
-		Data: ChatMessage{
-			ID:        savedMessage.ID,
-			Content:   savedMessage.Content,
-			Channel:   savedMessage.Channel,
-			CreatedAt: savedMessage.CreatedAt.Format(time.RFC3339),
-			User: UserInfo{
-				ID:       savedMessage.User.ID,
-				Username: savedMessage.User.Username,
-			},
-		},
-		UserID: c.UserID,
-		User: UserInfo{
-			ID:       c.UserID,
-			Username: c.Username,
-		},
+	// A retried message was already delivered, so do not broadcast it again
+	if !created {
+		log.Printf("🔁 Duplicate send of message %d (client_msg_id %s) from client %s", savedMessage.ID, msg.ClientMsgID, c.ID)
+		return messageAck(savedMessage, true), nil
 	}
 
-	log.Printf("📤 Created chat message for broadcast: %+v", chatMsg)
+	log.Printf("✅ Message saved to database with ID: %d", savedMessage.ID)
 
 	// Publish to Redis for distribution to all instances
-	if err := c.hub.PublishMessage(msg.Channel, chatMsg); err != nil {
+	if err := c.hub.PublishChatMessage(savedMessage); err != nil {
 		log.Printf("❌ Error publishing message to Redis: %v", err)
 	} else {
 		log.Printf("✅ Message published to Redis successfully")
 	}
+
+	return messageAck(savedMessage, false), nil
+}
+
+// allowWrite takes a token for a write of the user in a channel or
+// conversation, returning a rate_limited error when the bucket is empty
+func (c *Client) allowWrite(channel string) error {
+	if result := c.hub.messageLimiter.AllowMessage(c.hub.ctx, c.UserID, channel); !result.Allowed {
+		return rateLimitedError(result.RetryAfter)
+	}
+	return nil
+}
+
+// allowMessageWrite takes a token for a write to an existing message from the
+// bucket of the message's channel or conversation. Unknown messages pass so
+// the write itself reports them.
+func (c *Client) allowMessageWrite(messageID uint) error {
+	channel, err := c.hub.messageService.GetMessageChannel(messageID)
+	if err != nil {
+		return nil
+	}
+	return c.allowWrite(channel)
+}
+
+// handleDirectMessage handles 1:1 messages addressed to another user
+func (c *Client) handleDirectMessage(msg IncomingMessage) (*AckData, error) {
+	if msg.Content == "" || msg.RecipientID == 0 {
+		log.Printf("❌ Invalid direct message from client %s: empty content or recipient", c.ID)
+		return nil, validationError("content and recipient_id are required")
+	}
+
+	if err := c.allowWrite(models.DirectConversationKey(c.UserID, msg.RecipientID)); err != nil {
+		return nil, err
+	}
+
+	sent, err := c.hub.conversationService.SendDirectMessage(c.UserID, msg.RecipientID, service.SendConversationMessageRequest{
+		Content: msg.Content,
+	})
+	if err != nil {
+		log.Printf("❌ Failed to save direct message: %v", err)
+		return nil, err
+	}
+
+	if err := c.hub.PublishConversationMessage(sent); err != nil {
+		log.Printf("❌ Error publishing direct message to Redis: %v", err)
+	}
+	return messageAck(sent.Message, false), nil
+}
+
+// handleGroupMessage handles messages sent to a group conversation
+func (c *Client) handleGroupMessage(msg IncomingMessage) (*AckData, error) {
+	if msg.Content == "" || msg.ConversationID == 0 {
+		log.Printf("❌ Invalid group message from client %s: empty content or conversation", c.ID)
+		return nil, validationError("content and conversation_id are required")
+	}
+
+	key, err := c.hub.conversationService.GetConversationKey(msg.ConversationID, c.UserID)
+	if err != nil {
+		log.Printf("❌ Client %s cannot send to conversation %d: %v", c.ID, msg.ConversationID, err)
+		return nil, err
+	}
+	if err := c.allowWrite(key); err != nil {
+		return nil, err
+	}
+
+	sent, err := c.hub.conversationService.SendConversationMessage(msg.ConversationID, c.UserID, service.SendConversationMessageRequest{
+		Content: msg.Content,
+	})
+	if err != nil {
+		log.Printf("❌ Failed to save group message: %v", err)
+		return nil, err
+	}
+
+	if err := c.hub.PublishConversationMessage(sent); err != nil {
+		log.Printf("❌ Error publishing group message to Redis: %v", err)
+	}
+	return messageAck(sent.Message, false), nil
+}
+
+// handleEditMessage handles edits of the client's own messages
+func (c *Client) handleEditMessage(msg IncomingMessage) error {
+	if msg.Content == "" || msg.MessageID == 0 {
+		log.Printf("❌ Invalid edit message from client %s: empty content or message ID", c.ID)
+		return validationError("content and message_id are required")
+	}
+
+	if err := c.allowMessageWrite(msg.MessageID); err != nil {
+		return err
+	}
+
+	updated, err := c.hub.messageService.EditMessage(msg.MessageID, c.UserID, service.EditMessageRequest{
+		Content: msg.Content,
+	})
+	if err != nil {
+		log.Printf("❌ Failed to edit message %d: %v", msg.MessageID, err)
+		return err
+	}
+
+	if err := c.hub.PublishMessageEvent("message_updated", updated); err != nil {
+		log.Printf("❌ Error publishing message update to Redis: %v", err)
+	}
+	return nil
+}
+
+// handleDeleteMessage handles deletion of the client's own messages
+func (c *Client) handleDeleteMessage(msg IncomingMessage) error {
+	if msg.MessageID == 0 {
+		log.Printf("❌ Invalid delete message from client %s: empty message ID", c.ID)
+		return validationError("message_id is required")
+	}
+
+	tombstone, err := c.hub.messageService.DeleteMessage(msg.MessageID, c.UserID)
+	if err != nil {
+		log.Printf("❌ Failed to delete message %d: %v", msg.MessageID, err)
+		return err
+	}
+
+	if err := c.hub.PublishMessageEvent("message_deleted", tombstone); err != nil {
+		log.Printf("❌ Error publishing message deletion to Redis: %v", err)
+	}
+	return nil
+}
+
+// handleReaction handles adding or removing the client's emoji reaction on a message
+func (c *Client) handleReaction(msg IncomingMessage, add bool) error {
+	if msg.MessageID == 0 || msg.Emoji == "" || len(msg.Emoji) > 64 {
+		log.Printf("❌ Invalid reaction from client %s: empty message ID or invalid emoji", c.ID)
+		return validationError("message_id and an emoji of at most 64 bytes are required")
+	}
+
+	req := service.ReactionRequest{Emoji: msg.Emoji}
+	eventType := "reaction_added"
+	reactFunc := c.hub.messageService.AddReaction
+	if !add {
+		eventType = "reaction_removed"
+		reactFunc = c.hub.messageService.RemoveReaction
+	}
+
+	if err := c.allowMessageWrite(msg.MessageID); err != nil {
+		return err
+	}
+
+	message, update, err := reactFunc(msg.MessageID, c.UserID, req)
+	if err != nil {
+		log.Printf("❌ Failed to update reaction on message %d: %v", msg.MessageID, err)
+		return err
+	}
+
+	if err := c.hub.PublishReactionEvent(eventType, message, update); err != nil {
+		log.Printf("❌ Error publishing reaction to Redis: %v", err)
+	}
+	return nil
+}
+
+// handleMarkRead moves the user's read position in a channel and syncs it to
+// the user's other clients
+func (c *Client) handleMarkRead(msg IncomingMessage) error {
+	if msg.Channel == "" {
+		log.Printf("❌ Invalid mark_read message from client %s: empty channel", c.ID)
+		return validationError("channel is required")
+	}
+
+	position, err := c.hub.messageService.MarkChannelRead(msg.Channel, c.UserID, msg.MessageID)
+	if err != nil {
+		log.Printf("❌ Client %s failed to mark channel %s read: %v", c.ID, msg.Channel, err)
+		return err
+	}
+
+	if err := c.hub.PublishReadPosition(c.UserID, position); err != nil {
+		log.Printf("❌ Error publishing channel_read to Redis: %v", err)
+	}
+	return nil
+}
+
+// handleTyping handles typing indicators for a channel the client has joined
+func (c *Client) handleTyping(msg IncomingMessage, typing bool) error {
+	if msg.Channel == "" || !c.hub.IsSubscribed(c, msg.Channel) {
+		log.Printf("❌ Invalid typing message from client %s: not subscribed to channel %q", c.ID, msg.Channel)
+		return validationError("not subscribed to channel %q", msg.Channel)
+	}
+
+	if typing {
+		c.hub.typing.start(c, msg.Channel)
+	} else {
+		c.hub.typing.stop(c, msg.Channel)
+	}
+	return nil
+}
+
+// handleSubscribe joins the client to a channel
+func (c *Client) handleSubscribe(msg IncomingMessage) error {
+	if msg.Channel == "" {
+		log.Printf("❌ Invalid subscribe message from client %s: empty channel", c.ID)
+		return validationError("channel is required")
+	}
+
+	if err := c.hub.messageService.CheckChannelAccess(msg.Channel, c.UserID); err != nil {
+		log.Printf("❌ Client %s may not subscribe to channel %s: %v", c.ID, msg.Channel, err)
+		return err
+	}
+
+	c.hub.Subscribe(c, msg.Channel)
+
+	c.sendMessage(Message{
+		Type:    "subscribed",
+		Channel: msg.Channel,
+	})
+	return nil
+}
+
+// handleUnsubscribe removes the client from a channel
+func (c *Client) handleUnsubscribe(msg IncomingMessage) error {
+	if msg.Channel == "" {
+		log.Printf("❌ Invalid unsubscribe message from client %s: empty channel", c.ID)
+		return validationError("channel is required")
+	}
+
+	c.hub.typing.stop(c, msg.Channel)
+	c.hub.Unsubscribe(c, msg.Channel)
+
+	c.sendMessage(Message{
+		Type:    "unsubscribed",
+		Channel: msg.Channel,
+	})
+	return nil
+}
+
+// sendMessage queues a message for this client only, dropping it if the
+// send buffer is full
+func (c *Client) sendMessage(msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("❌ Failed to marshal message for client %s: %v", c.ID, err)
+		return
+	}
+
+	select {
+	case c.send <- data:
+	default:
+		log.Printf("❌ Send buffer full for client %s, dropping %s message", c.ID, msg.Type)
+	}
 }
 
 // handlePing handles ping messages
@@ -228,7 +640,7 @@ func (c *Client) handlePing() {
 		select {
 		case c.send <- data:
 		default:
-			close(c.send)
+			c.closeSlow()
 		}
 	}
 }
@@ -249,15 +661,69 @@ func (c *Client) handleGetUsers() {
 		select {
 		case c.send <- data:
 		default:
-			close(c.send)
+			c.closeSlow()
 		}
 	}
 }
 
+// NewChatMessage converts a saved message to its WebSocket representation
+func NewChatMessage(saved *service.MessageResponse) ChatMessage {
+	chatMsg := ChatMessage{
+		ID:         saved.ID,
+		Content:    saved.Content,
+		Channel:    saved.Channel,
+		ReplyCount: saved.ReplyCount,
+		CreatedAt:  saved.CreatedAt.Format(time.RFC3339),
+		User: UserInfo{
+			ID:       saved.User.ID,
+			Username: saved.User.Username,
+		},
+	}
+	if saved.ConversationID != nil {
+		chatMsg.ConversationID = *saved.ConversationID
+	}
+	if saved.EditedAt != nil {
+		chatMsg.EditedAt = saved.EditedAt.Format(time.RFC3339)
+	}
+	if saved.DeletedAt != nil {
+		chatMsg.DeletedAt = saved.DeletedAt.Format(time.RFC3339)
+	}
+	if saved.ParentID != nil {
+		chatMsg.ParentID = *saved.ParentID
+	}
+	if saved.LastReplyAt != nil {
+		chatMsg.LastReplyAt = saved.LastReplyAt.Format(time.RFC3339)
+	}
+	chatMsg.ClientMsgID = saved.ClientMsgID
+	chatMsg.Reactions = saved.Reactions
+	return chatMsg
+}
+
 // Run starts the client's read and write pumps
 func (c *Client) Run() {
 	// Register client with hub
 	c.hub.register <- c
+	c.hub.presence.connect(c)
+	c.hub.status.connect(c)
+
+	// Join the channels requested on connect that the user may read,
+	// replaying what was missed where the client sent its last seen message
+	lastSeen := c.resumeRequests()
+	for _, channel := range c.initialChannels() {
+		if lastSeenID, ok := lastSeen[channel]; ok {
+			delete(lastSeen, channel)
+			c.resume(channel, lastSeenID)
+			continue
+		}
+		if err := c.hub.messageService.CheckChannelAccess(channel, c.UserID); err != nil {
+			log.Printf("❌ Client %s may not join channel %s: %v", c.ID, channel, err)
+			continue
+		}
+		c.hub.Subscribe(c, channel)
+	}
+	for channel, lastSeenID := range lastSeen {
+		c.resume(channel, lastSeenID)
+	}
 
 	// Start pumps
 	go c.writePump()
@@ -3,12 +3,20 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"chatapp/internal/broker"
+	"chatapp/internal/models"
+	"chatapp/internal/ratelimit"
 	"chatapp/internal/service"
 
 	"github.com/go-redis/redis/v8"
+	"nhooyr.io/websocket"
 )
 
 // Hub maintains the set of active clients and broadcasts messages to the clients
@@ -16,8 +24,14 @@ type Hub struct {
 	// Registered clients
 	clients map[*Client]bool
 
-	// Inbound messages from the clients
-	broadcast chan []byte
+	// Clients indexed by the channels they have joined
+	channels map[string]map[*Client]bool
+
+	// Clients indexed by user ID, for messages addressed to users
+	users map[uint]map[*Client]bool
+
+	// Inbound messages from the broker, tagged with their chat channel or user
+	broadcast chan *channelMessage
 
 	// Register requests from the clients
 	register chan *Client
@@ -25,15 +39,40 @@ type Hub struct {
 	// Unregister requests from clients
 	unregister chan *Client
 
-	// Redis client for pub/sub
+	// Channel subscribe requests from clients
+	subscribe chan *subscription
+
+	// Channel unsubscribe requests from clients
+	unsubscribe chan *subscription
+
+	// Carries messages between server instances
+	broker broker.Broker
+
+	// Redis client for cluster-wide presence and activity; nil in
+	// single-node mode, where both are tracked in memory
 	redisClient *redis.Client
 
-	// Redis subscriber client (separate instance for subscribe operations)
-	redisSubscriber *redis.Client
+	// Limits how fast users may post chat messages
+	messageLimiter *ratelimit.MessageLimiter
 
 	// Message service for database operations
 	messageService *service.MessageService
 
+	// Conversation service for direct messages
+	conversationService *service.ConversationService
+
+	// Typing indicators of local clients
+	typing *typingTracker
+
+	// Cluster-wide presence of users
+	presence *presenceTracker
+
+	// Status service for away/dnd and custom status text
+	statusService *service.StatusService
+
+	// Idle detection and status expiry
+	status *statusTracker
+
 	// Mutex for thread-safe operations
 	mutex sync.RWMutex
 
@@ -41,13 +80,30 @@ type Hub struct {
 	ctx context.Context
 }
 
+// channelMessage is a raw payload received from the broker for a single chat
+// channel, for a single user when userID is set, or for every client when
+// everyone is set
+type channelMessage struct {
+	channel  string
+	userID   uint
+	everyone bool
+	payload  []byte
+}
+
+// subscription is a request to add or remove a client from a chat channel
+type subscription struct {
+	client  *Client
+	channel string
+}
+
 // Message represents a WebSocket message
 type Message struct {
-	Type    string      `json:"type"`
-	Channel string      `json:"channel,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	UserID  uint        `json:"user_id,omitempty"`
-	User    UserInfo    `json:"user,omitempty"`
+	Type      string      `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
+	Channel   string      `json:"channel,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	UserID    uint        `json:"user_id,omitempty"`
+	User      UserInfo    `json:"user,omitempty"`
 }
 
 // UserInfo represents user information in messages
@@ -58,31 +114,59 @@ type UserInfo struct {
 
 // ChatMessage represents a chat message
 type ChatMessage struct {
-	ID        uint     `json:"id"`
-	Content   string   `json:"content"`
-	Channel   string   `json:"channel"`
-	CreatedAt string   `json:"created_at"`
-	User      UserInfo `json:"user"`
+	ID             uint     `json:"id"`
+	Content        string   `json:"content"`
+	Channel        string   `json:"channel"`
+	ConversationID uint     `json:"conversation_id,omitempty"`
+	CreatedAt      string   `json:"created_at"`
+	EditedAt       string   `json:"edited_at,omitempty"`
+	DeletedAt      string   `json:"deleted_at,omitempty"`
+	ParentID       uint     `json:"parent_id,omitempty"`
+	ReplyCount     int      `json:"reply_count"`
+	LastReplyAt    string   `json:"last_reply_at,omitempty"`
+	ClientMsgID    string   `json:"client_msg_id,omitempty"`
+	User           UserInfo `json:"user"`
+
+	// Aggregated reactions, sent with replayed messages only
+	Reactions []service.ReactionSummary `json:"reactions,omitempty"`
 }
 
-// NewHub creates a new WebSocket hub
-func NewHub(redisClient *redis.Client, redisSubscriber *redis.Client, messageService *service.MessageService) *Hub {
-	return &Hub{
-		clients:         make(map[*Client]bool),
-		broadcast:       make(chan []byte),
-		register:        make(chan *Client),
-		unregister:      make(chan *Client),
-		redisClient:     redisClient,
-		redisSubscriber: redisSubscriber,
-		messageService:  messageService,
-		ctx:             context.Background(),
+// NewHub creates a new WebSocket hub. redisClient may be nil for a single
+// node. instanceID identifies this server instance in the cluster-wide
+// presence data and must be unique per process.
+func NewHub(messageBroker broker.Broker, redisClient *redis.Client, messageLimiter *ratelimit.MessageLimiter, messageService *service.MessageService, conversationService *service.ConversationService, statusService *service.StatusService, instanceID string) *Hub {
+	hub := &Hub{
+		clients:             make(map[*Client]bool),
+		channels:            make(map[string]map[*Client]bool),
+		users:               make(map[uint]map[*Client]bool),
+		broadcast:           make(chan *channelMessage),
+		register:            make(chan *Client),
+		unregister:          make(chan *Client),
+		subscribe:           make(chan *subscription),
+		unsubscribe:         make(chan *subscription),
+		broker:              messageBroker,
+		redisClient:         redisClient,
+		messageLimiter:      messageLimiter,
+		messageService:      messageService,
+		conversationService: conversationService,
+		statusService:       statusService,
+		ctx:                 context.Background(),
 	}
+	hub.typing = newTypingTracker(hub)
+	hub.presence = newPresenceTracker(hub, instanceID)
+	hub.status = newStatusTracker(hub)
+	return hub
 }
 
 // Run starts the hub
 func (h *Hub) Run() {
-	// Start Redis subscriber in a separate goroutine
-	go h.subscribeToRedis()
+	// Start receiving messages from all instances in a separate goroutine
+	go h.receive()
+
+	// Clear presence left by a crashed run before accepting clients
+	h.presence.start()
+	go h.presence.run()
+	go h.status.run()
 
 	for {
 		select {
@@ -92,8 +176,20 @@ func (h *Hub) Run() {
 		case client := <-h.unregister:
 			h.unregisterClient(client)
 
+		case sub := <-h.subscribe:
+			h.subscribeClient(sub.client, sub.channel)
+
+		case sub := <-h.unsubscribe:
+			h.unsubscribeClient(sub.client, sub.channel)
+
 		case message := <-h.broadcast:
-			h.broadcastMessage(message)
+			if message.userID != 0 {
+				h.sendToUser(message.userID, message.payload)
+			} else if message.everyone {
+				h.sendToAll(message.payload)
+			} else {
+				h.broadcastMessage(message.channel, message.payload)
+			}
 		}
 	}
 }
@@ -104,6 +200,11 @@ func (h *Hub) registerClient(client *Client) {
 	defer h.mutex.Unlock()
 
 	h.clients[client] = true
+	if h.users[client.UserID] == nil {
+		h.users[client.UserID] = make(map[*Client]bool)
+		h.refreshInterest()
+	}
+	h.users[client.UserID][client] = true
 	log.Printf("Client registered: %s (User ID: %d)", client.ID, client.UserID)
 
 	// Send welcome message
@@ -119,25 +220,9 @@ func (h *Hub) registerClient(client *Client) {
 		select {
 		case client.send <- data:
 		default:
-			close(client.send)
-			delete(h.clients, client)
+			client.closeSlow()
 		}
 	}
-
-	// Notify other clients about user joining
-	joinMsg := Message{
-		Type:    "user_joined",
-		Channel: "general",
-		Data: map[string]interface{}{
-			"message": client.Username + " joined the chat",
-		},
-		User: UserInfo{
-			ID:       client.UserID,
-			Username: client.Username,
-		},
-	}
-
-	h.publishToRedis("chat:general", joinMsg)
 }
 
 // unregisterClient unregisters a client
@@ -146,120 +231,523 @@ func (h *Hub) unregisterClient(client *Client) {
 	defer h.mutex.Unlock()
 
 	if _, ok := h.clients[client]; ok {
-		delete(h.clients, client)
-		close(client.send)
+		h.removeClient(client)
+
+		// Do not leave a stuck typing indicator behind
+		go h.typing.clear(client)
+
 		log.Printf("Client unregistered: %s (User ID: %d)", client.ID, client.UserID)
+	}
+}
 
-		// Notify other clients about user leaving
-		leaveMsg := Message{
-			Type:    "user_left",
-			Channel: "general",
-			Data: map[string]interface{}{
-				"message": client.Username + " left the chat",
-			},
-			User: UserInfo{
-				ID:       client.UserID,
-				Username: client.Username,
-			},
+// removeClient drops an unregistered client from every index and closes its
+// send channel. Callers must hold the write lock. Live clients are ended with
+// shutdown instead, so nothing sends on a closed channel.
+func (h *Hub) removeClient(client *Client) {
+	for channel := range client.channels {
+		h.removeFromChannel(client, channel)
+	}
+	if userClients, ok := h.users[client.UserID]; ok {
+		delete(userClients, client)
+		if len(userClients) == 0 {
+			delete(h.users, client.UserID)
 		}
+	}
+	delete(h.clients, client)
+	close(client.send)
+}
 
-		h.publishToRedis("chat:general", leaveMsg)
+// addToChannel adds a client to a channel index. Callers must hold the write lock.
+func (h *Hub) addToChannel(client *Client, channel string) {
+	members, ok := h.channels[channel]
+	if !ok {
+		members = make(map[*Client]bool)
+		h.channels[channel] = members
+		h.refreshInterest()
 	}
+	members[client] = true
+	client.channels[channel] = true
 }
 
-// broadcastMessage broadcasts a message to all clients
-func (h *Hub) broadcastMessage(message []byte) {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
+// removeFromChannel removes a client from a channel index. Callers must hold the write lock.
+func (h *Hub) removeFromChannel(client *Client, channel string) {
+	if members, ok := h.channels[channel]; ok {
+		delete(members, client)
+		if len(members) == 0 {
+			delete(h.channels, channel)
+		}
+	}
+	delete(client.channels, channel)
+}
 
-	log.Printf("📢 Broadcasting message to %d clients: %s", len(h.clients), string(message))
+// subscribeClient joins a registered client to a channel
+func (h *Hub) subscribeClient(client *Client, channel string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	h.addToChannel(client, channel)
+	log.Printf("Client %s subscribed to channel %s", client.ID, channel)
+}
+
+// unsubscribeClient removes a registered client from a channel
+func (h *Hub) unsubscribeClient(client *Client, channel string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	h.removeFromChannel(client, channel)
+	log.Printf("Client %s unsubscribed from channel %s", client.ID, channel)
+}
+
+// broadcastMessage sends a message to the clients that joined the given channel
+func (h *Hub) broadcastMessage(channel string, message []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	members := h.channels[channel]
+	log.Printf("📢 Broadcasting message to %d clients in channel %s: %s", len(members), channel, string(message))
+
+	// Peek at the event to apply per-type delivery rules
+	var event struct {
+		Type   string `json:"type"`
+		UserID uint   `json:"user_id"`
+		Data   struct {
+			UserID uint `json:"user_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(message, &event); err != nil {
+		event.Type = ""
+	}
+
+	// Typing users do not see their own indicator
+	excludeUserID := uint(0)
+	if event.Type == "typing_start" || event.Type == "typing_stop" {
+		excludeUserID = event.UserID
+	}
 
 	successCount := 0
 	failureCount := 0
 
-	for client := range h.clients {
+	for client := range members {
+		if excludeUserID != 0 && client.UserID == excludeUserID {
+			continue
+		}
+
+		// Hold back live messages while the client replays the channel
+		if held, replaying := client.replaying[channel]; replaying {
+			client.replaying[channel] = append(held, message)
+			continue
+		}
+
 		select {
 		case client.send <- message:
 			successCount++
 		default:
 			log.Printf("❌ Failed to send message to client %s, closing connection", client.ID)
-			close(client.send)
-			delete(h.clients, client)
+			client.closeSlow()
 			failureCount++
 		}
 	}
 
 	log.Printf("📢 Broadcast complete - Success: %d, Failed: %d", successCount, failureCount)
+
+	// A removed member must stop receiving the channel on every instance
+	if event.Type == "member_removed" {
+		h.evictUser(event.Data.UserID, channel)
+	}
+
+	// A deleted channel must stop fanning out on every instance
+	if event.Type == "channel_deleted" {
+		h.evictChannel(channel)
+	}
+}
+
+// sendToUser sends a message to every local client of a user
+func (h *Hub) sendToUser(userID uint, message []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// A revoked session only concerns the clients connected with it
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			SessionID string `json:"session_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(message, &event); err == nil && event.Type == "session_revoked" {
+		h.disconnectSession(userID, event.Data.SessionID, message)
+		return
+	}
+
+	for client := range h.users[userID] {
+		select {
+		case client.send <- message:
+		default:
+			log.Printf("❌ Failed to send message to client %s, closing connection", client.ID)
+			client.closeSlow()
+		}
+	}
+}
+
+// disconnectSession tells the local clients of a revoked session why they
+// are disconnected, then shuts them down. Callers must hold the write lock.
+func (h *Hub) disconnectSession(userID uint, sessionID string, message []byte) {
+	for client := range h.users[userID] {
+		if client.SessionID != sessionID {
+			continue
+		}
+
+		select {
+		case client.send <- message:
+		default:
+		}
+		client.shutdown(websocket.StatusPolicyViolation, "Session revoked")
+
+		log.Printf("🔒 Client %s disconnected, session %s revoked (User ID: %d)", client.ID, sessionID, userID)
+	}
+}
+
+// sendToAll sends a message to every local client
+func (h *Hub) sendToAll(message []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for client := range h.clients {
+		select {
+		case client.send <- message:
+		default:
+			log.Printf("❌ Failed to send message to client %s, closing connection", client.ID)
+			client.closeSlow()
+		}
+	}
 }
 
-// PublishMessage publishes a message to Redis for distribution
+// evictUser removes every local client of a user from a channel. Callers must hold the write lock.
+func (h *Hub) evictUser(userID uint, channel string) {
+	for client := range h.channels[channel] {
+		if client.UserID == userID {
+			h.removeFromChannel(client, channel)
+			log.Printf("Client %s evicted from channel %s", client.ID, channel)
+		}
+	}
+}
+
+// evictChannel removes every local client from a channel. Callers must hold the write lock.
+func (h *Hub) evictChannel(channel string) {
+	for client := range h.channels[channel] {
+		h.removeFromChannel(client, channel)
+	}
+	log.Printf("Channel %s closed", channel)
+}
+
+// Subscribe joins a client to a channel so it receives the channel's messages
+func (h *Hub) Subscribe(client *Client, channel string) {
+	h.subscribe <- &subscription{client: client, channel: channel}
+}
+
+// Unsubscribe removes a client from a channel
+func (h *Hub) Unsubscribe(client *Client, channel string) {
+	h.unsubscribe <- &subscription{client: client, channel: channel}
+}
+
+// PublishMessage publishes a message to a channel on every instance
 func (h *Hub) PublishMessage(channel string, msg Message) error {
-	return h.publishToRedis("chat:"+channel, msg)
+	return h.publish("chat:"+channel, msg)
+}
+
+// PublishToUsers publishes a message to every connected client of the given
+// users on all server instances
+func (h *Hub) PublishToUsers(userIDs []uint, msg Message) error {
+	for _, userID := range userIDs {
+		if err := h.publish(userTopic(userID), msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisconnectSession closes the connections of a revoked session on every
+// server instance
+func (h *Hub) DisconnectSession(userID uint, sessionID string) error {
+	return h.publish(userTopic(userID), Message{
+		Type: "session_revoked",
+		Data: map[string]interface{}{
+			"session_id": sessionID,
+		},
+	})
+}
+
+// PublishConversationMessage delivers a conversation message to its participants only
+func (h *Hub) PublishConversationMessage(sent *service.ConversationMessage) error {
+	msgType := "direct_message"
+	if sent.Kind == models.ConversationKindGroup {
+		msgType = "group_message"
+	}
+
+	msg := Message{
+		Type:    msgType,
+		Channel: sent.Message.Channel,
+		Data:    NewChatMessage(sent.Message),
+		UserID:  sent.Message.User.ID,
+		User: UserInfo{
+			ID:       sent.Message.User.ID,
+			Username: sent.Message.User.Username,
+		},
+	}
+
+	return h.PublishToUsers(sent.ParticipantIDs, msg)
+}
+
+// PublishChatMessage publishes a newly created channel message. Top-level
+// messages go out as chat_message; thread replies go out as thread_reply
+// together with the parent's updated reply statistics.
+func (h *Hub) PublishChatMessage(saved *service.MessageResponse) error {
+	msg := Message{
+		Type:    "chat_message",
+		Channel: saved.Channel,
+		Data:    NewChatMessage(saved),
+		UserID:  saved.User.ID,
+		User: UserInfo{
+			ID:       saved.User.ID,
+			Username: saved.User.Username,
+		},
+	}
+
+	if saved.Thread != nil {
+		msg.Type = "thread_reply"
+		msg.Data = map[string]interface{}{
+			"parent_id":     saved.Thread.ParentID,
+			"reply_count":   saved.Thread.ReplyCount,
+			"last_reply_at": saved.Thread.LastReplyAt.Format(time.RFC3339),
+			"reply":         NewChatMessage(saved),
+		}
+	}
+
+	log.Printf("📤 Created %s for broadcast: %+v", msg.Type, msg)
+
+	return h.PublishMessage(saved.Channel, msg)
+}
+
+// PublishMessageEvent publishes an event about an existing message (for
+// example message_updated) to everyone who can see that message: the channel's
+// subscribers, or the participants of its conversation
+func (h *Hub) PublishMessageEvent(eventType string, message *service.MessageResponse) error {
+	msg := Message{
+		Type:    eventType,
+		Channel: message.Channel,
+		Data:    NewChatMessage(message),
+		UserID:  message.User.ID,
+		User: UserInfo{
+			ID:       message.User.ID,
+			Username: message.User.Username,
+		},
+	}
+
+	return h.publishForMessage(message, msg)
+}
+
+// PublishReactionEvent publishes reaction_added or reaction_removed to everyone who can see the message
+func (h *Hub) PublishReactionEvent(eventType string, message *service.MessageResponse, update *service.ReactionUpdate) error {
+	msg := Message{
+		Type:    eventType,
+		Channel: message.Channel,
+		Data:    update,
+		UserID:  update.User.ID,
+		User: UserInfo{
+			ID:       update.User.ID,
+			Username: update.User.Username,
+		},
+	}
+
+	return h.publishForMessage(message, msg)
+}
+
+// publishForMessage routes a WebSocket message to the audience of an existing message
+func (h *Hub) publishForMessage(message *service.MessageResponse, msg Message) error {
+	if message.ConversationID == nil {
+		return h.PublishMessage(message.Channel, msg)
+	}
+
+	participantIDs, err := h.conversationService.GetParticipantIDs(*message.ConversationID)
+	if err != nil {
+		return err
+	}
+	return h.PublishToUsers(participantIDs, msg)
+}
+
+// PublishParticipantEvent notifies the participants of a conversation that a
+// user was added (participant_added) or removed (participant_left)
+func (h *Hub) PublishParticipantEvent(eventType string, change *service.ParticipantChange) error {
+	msg := Message{
+		Type:    eventType,
+		Channel: change.ConversationKey,
+		Data: map[string]interface{}{
+			"conversation_id": change.ConversationID,
+			"changed_by":      change.ChangedBy,
+		},
+		UserID: change.User.ID,
+		User: UserInfo{
+			ID:       change.User.ID,
+			Username: change.User.Username,
+		},
+	}
+
+	return h.PublishToUsers(change.ParticipantIDs, msg)
 }
 
-// publishToRedis publishes a message to Redis
-func (h *Hub) publishToRedis(channel string, msg Message) error {
-	log.Printf("📡 Publishing message to Redis channel: %s", channel)
+// PublishStatusChange notifies every connected user, on all server
+// instances, that a user's status changed
+func (h *Hub) PublishStatusChange(change *service.StatusChange) error {
+	msg := Message{
+		Type:   "status_changed",
+		Data:   change.Status,
+		UserID: change.Status.User.ID,
+		User: UserInfo{
+			ID:       change.Status.User.ID,
+			Username: change.Status.User.Username,
+		},
+	}
+
+	return h.publish(presenceTopic, msg)
+}
+
+// PublishReadPosition syncs a user's read position in a channel to all of
+// their connected clients
+func (h *Hub) PublishReadPosition(userID uint, position *service.ReadPosition) error {
+	msg := Message{
+		Type:    "channel_read",
+		Channel: position.Channel,
+		Data:    position,
+		UserID:  userID,
+	}
+
+	return h.PublishToUsers([]uint{userID}, msg)
+}
+
+// userTopic returns the broker topic carrying messages addressed to a user
+func userTopic(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// publish publishes a message on a broker topic for distribution to all instances
+func (h *Hub) publish(topic string, msg Message) error {
+	log.Printf("📡 Publishing message to topic: %s", topic)
 	log.Printf("📡 Message content: %+v", msg)
 
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("❌ Failed to marshal message for Redis: %v", err)
+		log.Printf("❌ Failed to marshal message for broker: %v", err)
 		return err
 	}
 
 	log.Printf("📡 Marshaled message data: %s", string(data))
 
-	err = h.redisClient.Publish(h.ctx, channel, data).Err()
+	err = h.broker.Publish(h.ctx, topic, data)
 	if err != nil {
-		log.Printf("❌ Failed to publish to Redis: %v", err)
+		log.Printf("❌ Failed to publish to broker: %v", err)
 	} else {
-		log.Printf("✅ Message published to Redis successfully")
+		log.Printf("✅ Message published successfully")
 	}
 
 	return err
 }
 
-// subscribeToRedis subscribes to Redis channels for message distribution
-func (h *Hub) subscribeToRedis() {
-	log.Printf("🔔 Starting Redis subscription to chat:* channels")
+// receive subscribes to the chat, user and presence topics and dispatches
+// what arrives until the subscription ends
+func (h *Hub) receive() {
+	log.Printf("🔔 Subscribing to chat:*, user:* and presence topics")
+
+	if filter, ok := h.broker.(broker.InterestFilter); ok {
+		filter.SetInterest(h.wantsTopic)
+	}
 
-	// Redis接続をテスト (Subscribe用のクライアントを使用)
-	ctx := context.Background()
-	if err := h.redisSubscriber.Ping(ctx).Err(); err != nil {
-		log.Printf("❌ Redis subscriber ping failed: %v", err)
+	messages, err := h.broker.PSubscribe(h.ctx, "chat:*", "user:*", presenceTopic)
+	if err != nil {
+		log.Printf("❌ Failed to subscribe to broker topics: %v", err)
 		return
 	}
-	log.Printf("✅ Redis subscriber ping successful")
 
-	// サブスクリプションを開始 (Subscribe専用クライアントを使用)
-	// パターンマッチングにはPSubscribeを使用
-	pubsub := h.redisSubscriber.PSubscribe(h.ctx, "chat:*")
-	defer pubsub.Close()
+	log.Printf("📻 Listening for broker messages...")
+	for msg := range messages {
+		log.Printf("📨 Received message on topic %s: %s", msg.Topic, string(msg.Payload))
+		h.dispatch(msg.Topic, msg.Payload)
+	}
+
+	log.Printf("⚠️ Broker subscription closed")
+}
 
-	log.Printf("✅ Redis pattern subscription created successfully with subscriber client")
+// wantsTopic reports whether a broker topic has local recipients: a channel
+// joined by a local client or a user with a local connection
+func (h *Hub) wantsTopic(topic string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
 
-	// サブスクリプションが正常に作成されたかテスト
-	if _, err := pubsub.Receive(ctx); err != nil {
-		log.Printf("❌ Failed to receive pattern subscription confirmation: %v", err)
-		return
+	if name, ok := strings.CutPrefix(topic, "chat:"); ok {
+		return len(h.channels[name]) > 0
 	}
-	log.Printf("✅ Redis pattern subscription confirmed")
+	if id, ok := strings.CutPrefix(topic, "user:"); ok {
+		userID, err := strconv.ParseUint(id, 10, 32)
+		return err == nil && len(h.users[uint(userID)]) > 0
+	}
+	return true
+}
 
-	ch := pubsub.Channel()
-	log.Printf("📻 Listening for Redis messages on channel...")
+// refreshInterest tells the broker that a topic gained local recipients
+func (h *Hub) refreshInterest() {
+	if filter, ok := h.broker.(broker.InterestFilter); ok {
+		filter.RefreshInterest()
+	}
+}
 
-	for msg := range ch {
-		log.Printf("📨 Received Redis message on channel %s: %s", msg.Channel, msg.Payload)
-		log.Printf("📨 Broadcasting to %d connected clients", len(h.clients))
-		h.broadcast <- []byte(msg.Payload)
+// dispatch hands a message received from another instance (or this one) to
+// the hub loop, routing it by topic
+func (h *Hub) dispatch(topic string, payload []byte) {
+	if topic == presenceTopic {
+		h.broadcast <- &channelMessage{
+			everyone: true,
+			payload:  payload,
+		}
+		return
 	}
 
-	log.Printf("⚠️ Redis subscription channel closed")
+	if strings.HasPrefix(topic, "user:") {
+		userID, err := strconv.ParseUint(strings.TrimPrefix(topic, "user:"), 10, 32)
+		if err != nil {
+			log.Printf("❌ Invalid user channel %s: %v", topic, err)
+			return
+		}
+		h.broadcast <- &channelMessage{
+			userID:  uint(userID),
+			payload: payload,
+		}
+		return
+	}
+
+	h.broadcast <- &channelMessage{
+		channel: strings.TrimPrefix(topic, "chat:"),
+		payload: payload,
+	}
 }
 
-// GetConnectedUsers returns the list of connected users
+// GetConnectedUsers returns the users connected to any instance of the
+// cluster. In single-node mode, or if Redis is unavailable, it returns the
+// users of the local clients.
 func (h *Hub) GetConnectedUsers() []UserInfo {
+	if h.redisClient != nil {
+		users, err := h.presence.onlineUsers()
+		if err == nil {
+			return users
+		}
+		log.Printf("❌ Failed to load presence from Redis, using local clients: %v", err)
+	}
+
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
@@ -279,6 +767,20 @@ func (h *Hub) GetConnectedUsers() []UserInfo {
 	return users
 }
 
+// IsSubscribed reports whether a client has joined a channel
+func (h *Hub) IsSubscribed(client *Client, channel string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return client.channels[channel]
+}
+
+// GetChannelClientCount returns the number of local clients joined to a channel
+func (h *Hub) GetChannelClientCount(channel string) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.channels[channel])
+}
+
 // GetClientCount returns the number of connected clients
 func (h *Hub) GetClientCount() int {
 	h.mutex.RLock()
@@ -0,0 +1,261 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	"chatapp/internal/broker"
+
+	"nhooyr.io/websocket"
+)
+
+// newTestHub returns a hub without Redis or services, for driving the hub
+// loop handlers directly
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	return NewHub(b, nil, nil, nil, nil, nil, "test")
+}
+
+// addTestClient registers a client of a user and session and joins it to the channels
+func addTestClient(h *Hub, userID uint, sessionID string, channels ...string) *Client {
+	client := NewClient(h, nil, userID, "user", "user@example.com", sessionID, nil)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.clients[client] = true
+	if h.users[userID] == nil {
+		h.users[userID] = make(map[*Client]bool)
+	}
+	h.users[userID][client] = true
+	for _, channel := range channels {
+		h.addToChannel(client, channel)
+	}
+	return client
+}
+
+// received returns the types of the messages queued for a client
+func received(t *testing.T, client *Client) []string {
+	t.Helper()
+
+	var types []string
+	for {
+		select {
+		case data := <-client.send:
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("failed to decode queued message: %v", err)
+			}
+			types = append(types, msg.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func isShutdown(client *Client) bool {
+	select {
+	case <-client.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func encode(t *testing.T, msg Message) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("failed to encode message: %v", err)
+	}
+	return data
+}
+
+func TestSendToUserSessionRevoked(t *testing.T) {
+	tests := []struct {
+		name         string
+		message      Message
+		wantRevoked  []string
+		wantReceived map[string][]string
+	}{
+		{
+			name:         "ordinary message reaches every session",
+			message:      Message{Type: "read_position"},
+			wantReceived: map[string][]string{"a": {"read_position"}, "b": {"read_position"}},
+		},
+		{
+			name:         "revocation closes only the revoked session",
+			message:      Message{Type: "session_revoked", Data: map[string]interface{}{"session_id": "a"}},
+			wantRevoked:  []string{"a"},
+			wantReceived: map[string][]string{"a": {"session_revoked"}, "b": nil},
+		},
+		{
+			name:         "revocation of an unknown session closes nothing",
+			message:      Message{Type: "session_revoked", Data: map[string]interface{}{"session_id": "c"}},
+			wantReceived: map[string][]string{"a": nil, "b": nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHub(t)
+			clients := map[string]*Client{
+				"a": addTestClient(h, 1, "a", "general"),
+				"b": addTestClient(h, 1, "b", "general"),
+			}
+
+			h.sendToUser(1, encode(t, tt.message))
+
+			revoked := make(map[string]bool)
+			for _, sessionID := range tt.wantRevoked {
+				revoked[sessionID] = true
+			}
+			for sessionID, client := range clients {
+				if got := isShutdown(client); got != revoked[sessionID] {
+					t.Errorf("session %s shut down = %v, want %v", sessionID, got, revoked[sessionID])
+				}
+				if revoked[sessionID] && client.closeStatus != websocket.StatusPolicyViolation {
+					t.Errorf("session %s close status = %v, want %v", sessionID, client.closeStatus, websocket.StatusPolicyViolation)
+				}
+				if got := received(t, client); !equalStrings(got, tt.wantReceived[sessionID]) {
+					t.Errorf("session %s received %v, want %v", sessionID, got, tt.wantReceived[sessionID])
+				}
+
+				// Index cleanup is left to the unregister path
+				if !h.clients[client] {
+					t.Errorf("session %s was removed from the hub before unregistering", sessionID)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokedClientUnregisters(t *testing.T) {
+	h := newTestHub(t)
+	client := addTestClient(h, 1, "a", "general")
+
+	h.sendToUser(1, encode(t, Message{Type: "session_revoked", Data: map[string]interface{}{"session_id": "a"}}))
+
+	// Sending after the shutdown, as the read pump may still do, must not panic
+	client.sendMessage(Message{Type: "pong"})
+	client.closeSlow()
+
+	h.unregisterClient(client)
+	if h.clients[client] || h.users[1][client] || h.channels["general"][client] {
+		t.Fatal("unregistered client is still indexed")
+	}
+	if client.closeStatus != websocket.StatusPolicyViolation {
+		t.Errorf("close status = %v, want %v", client.closeStatus, websocket.StatusPolicyViolation)
+	}
+
+	// Unregistering twice must not close send twice
+	h.unregisterClient(client)
+}
+
+func TestChannelEviction(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   Message
+		wantKept  []string
+		wantEvict []string
+	}{
+		{
+			name:     "ordinary message keeps everyone",
+			message:  Message{Type: "message", Channel: "general"},
+			wantKept: []string{"alice-1", "alice-2", "bob"},
+		},
+		{
+			name:      "removed member loses every connection",
+			message:   Message{Type: "member_removed", Channel: "general", Data: map[string]interface{}{"user_id": 1}},
+			wantKept:  []string{"bob"},
+			wantEvict: []string{"alice-1", "alice-2"},
+		},
+		{
+			name:      "deleted channel evicts everyone",
+			message:   Message{Type: "channel_deleted", Channel: "general"},
+			wantEvict: []string{"alice-1", "alice-2", "bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHub(t)
+			clients := map[string]*Client{
+				"alice-1": addTestClient(h, 1, "a1", "general", "random"),
+				"alice-2": addTestClient(h, 1, "a2", "general"),
+				"bob":     addTestClient(h, 2, "b", "general"),
+			}
+
+			h.broadcastMessage("general", encode(t, tt.message))
+
+			// The event itself still reaches every member
+			for name, client := range clients {
+				if got := received(t, client); !equalStrings(got, []string{tt.message.Type}) {
+					t.Errorf("%s received %v, want %v", name, got, []string{tt.message.Type})
+				}
+			}
+			for _, name := range tt.wantKept {
+				if !h.channels["general"][clients[name]] || !clients[name].channels["general"] {
+					t.Errorf("%s was evicted", name)
+				}
+			}
+			for _, name := range tt.wantEvict {
+				if h.channels["general"][clients[name]] || clients[name].channels["general"] {
+					t.Errorf("%s was not evicted", name)
+				}
+			}
+
+			// Other channels are not affected
+			if !h.channels["random"][clients["alice-1"]] {
+				t.Error("eviction from general removed alice from random")
+			}
+
+			// Later messages only reach the remaining members
+			h.broadcastMessage("general", encode(t, Message{Type: "message", Channel: "general"}))
+			for _, name := range tt.wantEvict {
+				if got := received(t, clients[name]); got != nil {
+					t.Errorf("evicted %s received %v", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWantsTopic(t *testing.T) {
+	h := newTestHub(t)
+	addTestClient(h, 1, "a", "general")
+
+	tests := []struct {
+		topic string
+		want  bool
+	}{
+		{topic: "chat:general", want: true},
+		{topic: "chat:random", want: false},
+		{topic: "user:1", want: true},
+		{topic: "user:2", want: false},
+		{topic: "user:x", want: false},
+		{topic: presenceTopic, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := h.wantsTopic(tt.topic); got != tt.want {
+			t.Errorf("wantsTopic(%q) = %v, want %v", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,397 @@
+package websocket
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// presenceHeartbeatInterval is how often an instance refreshes its liveness key
+	presenceHeartbeatInterval = 10 * time.Second
+
+	// presenceInstanceTTL is how long an instance counts as alive without a heartbeat
+	presenceInstanceTTL = 30 * time.Second
+
+	// presenceTopic is the Redis channel carrying presence_changed and
+	// status_changed events to every client
+	presenceTopic = "presence"
+)
+
+// Redis keys used by the presence tracker
+const (
+	presenceUserKeyPrefix     = "presence:user:"           // HASH instance ID -> connection count
+	presenceInstanceKeyPrefix = "presence:instance:"       // STRING heartbeat with TTL
+	presenceInstanceUsersKey  = "presence:instance_users:" // SET of user IDs with connections on the instance
+	presenceInstancesKey      = "presence:instances"       // SET of known instance IDs
+	presenceOnlineKey         = "presence:online"          // SET of online user IDs
+	presenceUsernamesKey      = "presence:usernames"       // HASH online user ID -> username
+)
+
+// presenceHeartbeatScript refreshes the liveness key of an instance and
+// returns 1 when it had expired, meaning other instances may have dropped the
+// instance's connections.
+var presenceHeartbeatScript = redis.NewScript(`
+local missed = 1 - redis.call('EXISTS', KEYS[1])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+redis.call('SADD', KEYS[2], ARGV[3])
+return missed
+`)
+
+// presenceConnectScript adds a connection of a user on an instance and
+// returns the user's total number of connections across the cluster.
+var presenceConnectScript = redis.NewScript(`
+redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+redis.call('SADD', KEYS[2], ARGV[2])
+redis.call('SADD', KEYS[3], ARGV[2])
+redis.call('HSET', KEYS[4], ARGV[2], ARGV[3])
+local total = 0
+for _, count in ipairs(redis.call('HVALS', KEYS[1])) do
+	total = total + tonumber(count)
+end
+return total
+`)
+
+// presenceDisconnectScript removes a connection of a user on an instance and
+// returns the user's remaining connections, or -1 when the instance held no
+// connection for the user (it was already cleaned up as dead).
+var presenceDisconnectScript = redis.NewScript(`
+local count = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+if count < 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	return -1
+end
+if count == 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	redis.call('SREM', KEYS[2], ARGV[2])
+end
+local total = 0
+for _, c in ipairs(redis.call('HVALS', KEYS[1])) do
+	total = total + tonumber(c)
+end
+if total == 0 then
+	redis.call('DEL', KEYS[1])
+	redis.call('SREM', KEYS[3], ARGV[2])
+	redis.call('HDEL', KEYS[4], ARGV[2])
+end
+return total
+`)
+
+// presenceRegisterScript sets the number of connections of a user on an
+// instance and returns 1 when the user was offline before.
+var presenceRegisterScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+redis.call('SADD', KEYS[2], ARGV[2])
+redis.call('HSET', KEYS[4], ARGV[2], ARGV[4])
+return redis.call('SADD', KEYS[3], ARGV[2])
+`)
+
+// presenceCleanupScript drops every connection recorded for an instance and
+// returns the IDs and usernames of the users that went offline because of
+// it, as pairs. Unless forced it does nothing when the instance sent a
+// heartbeat since it was found dead.
+var presenceCleanupScript = redis.NewScript(`
+if ARGV[3] ~= '1' and redis.call('EXISTS', KEYS[4]) == 1 then
+	return {}
+end
+local offline = {}
+for _, userID in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+	local key = ARGV[2] .. userID
+	redis.call('HDEL', key, ARGV[1])
+	if redis.call('HLEN', key) == 0 then
+		redis.call('SREM', KEYS[3], userID)
+		table.insert(offline, userID)
+		table.insert(offline, redis.call('HGET', KEYS[5], userID) or '')
+		redis.call('HDEL', KEYS[5], userID)
+	end
+end
+redis.call('DEL', KEYS[1])
+redis.call('SREM', KEYS[2], ARGV[1])
+return offline
+`)
+
+// presenceTracker records how many connections each user has on every
+// instance in Redis. Users go online with their first connection anywhere in
+// the cluster and offline with their last one; connections of instances whose
+// heartbeat expired are removed by the surviving instances, and an instance
+// that missed its heartbeat registers its connections again. Without Redis
+// (single-node mode) the connections are only counted in memory.
+type presenceTracker struct {
+	hub        *Hub
+	instanceID string
+
+	// Local connections per user. The mutex is held while Redis is updated
+	// so the counts there never drift from these.
+	mutex     sync.Mutex
+	counts    map[uint]int
+	usernames map[uint]string
+}
+
+func newPresenceTracker(hub *Hub, instanceID string) *presenceTracker {
+	return &presenceTracker{
+		hub:        hub,
+		instanceID: instanceID,
+		counts:     make(map[uint]int),
+		usernames:  make(map[uint]string),
+	}
+}
+
+// start drops stale connections left by a previous run with the same
+// instance ID and announces the instance. It must finish before clients connect.
+func (p *presenceTracker) start() {
+	if p.hub.redisClient == nil {
+		return
+	}
+	p.cleanupInstance(p.instanceID, true)
+	p.heartbeat()
+}
+
+// run sends heartbeats and removes dead instances until the process exits
+func (p *presenceTracker) run() {
+	if p.hub.redisClient == nil {
+		return
+	}
+
+	ticker := time.NewTicker(presenceHeartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.heartbeat()
+		p.cleanupDeadInstances()
+	}
+}
+
+// heartbeat marks this instance alive for presenceInstanceTTL. When the
+// previous heartbeat expired, the local connections are registered again in
+// case another instance cleaned them up.
+func (p *presenceTracker) heartbeat() {
+	ctx := p.hub.ctx
+	keys := []string{presenceInstanceKeyPrefix + p.instanceID, presenceInstancesKey}
+	missed, err := presenceHeartbeatScript.Run(ctx, p.hub.redisClient, keys,
+		time.Now().Unix(), presenceInstanceTTL.Milliseconds(), p.instanceID).Int()
+	if err != nil {
+		log.Printf("❌ Presence heartbeat failed: %v", err)
+		return
+	}
+	if missed == 1 {
+		p.reregister()
+	}
+}
+
+// reregister writes the local connections of every user to Redis again and
+// announces the users that were shown offline meanwhile
+func (p *presenceTracker) reregister() {
+	ctx := p.hub.ctx
+
+	p.mutex.Lock()
+	var online []UserInfo
+	for userID, count := range p.counts {
+		id := strconv.FormatUint(uint64(userID), 10)
+		added, err := presenceRegisterScript.Run(ctx, p.hub.redisClient, p.keys(userID),
+			p.instanceID, id, count, p.usernames[userID]).Int()
+		if err != nil {
+			log.Printf("❌ Failed to register presence of user %d again: %v", userID, err)
+			continue
+		}
+		if added == 1 {
+			online = append(online, UserInfo{ID: userID, Username: p.usernames[userID]})
+		}
+	}
+	users := len(p.counts)
+	p.mutex.Unlock()
+
+	if users > 0 {
+		log.Printf("💓 Missed presence heartbeat, registered connections of %d users again", users)
+	}
+	for _, user := range online {
+		p.publish(user, true)
+	}
+}
+
+// cleanupDeadInstances removes the connections of instances whose heartbeat expired
+func (p *presenceTracker) cleanupDeadInstances() {
+	ctx := p.hub.ctx
+	instances, err := p.hub.redisClient.SMembers(ctx, presenceInstancesKey).Result()
+	if err != nil {
+		log.Printf("❌ Failed to list presence instances: %v", err)
+		return
+	}
+
+	for _, instanceID := range instances {
+		if instanceID == p.instanceID {
+			continue
+		}
+		alive, err := p.hub.redisClient.Exists(ctx, presenceInstanceKeyPrefix+instanceID).Result()
+		if err != nil {
+			log.Printf("❌ Failed to check presence instance %s: %v", instanceID, err)
+			continue
+		}
+		if alive == 0 {
+			log.Printf("🧹 Cleaning up presence of dead instance %s", instanceID)
+			p.cleanupInstance(instanceID, false)
+		}
+	}
+}
+
+// cleanupInstance drops every connection of an instance and announces the
+// users that went offline. The script runs atomically, so only one instance
+// announces each user, and skips instances that came back unless forced.
+func (p *presenceTracker) cleanupInstance(instanceID string, force bool) {
+	ctx := p.hub.ctx
+	keys := []string{
+		presenceInstanceUsersKey + instanceID,
+		presenceInstancesKey,
+		presenceOnlineKey,
+		presenceInstanceKeyPrefix + instanceID,
+		presenceUsernamesKey,
+	}
+	forceArg := "0"
+	if force {
+		forceArg = "1"
+	}
+	offline, err := presenceCleanupScript.Run(ctx, p.hub.redisClient, keys, instanceID, presenceUserKeyPrefix, forceArg).StringSlice()
+	if err != nil {
+		log.Printf("❌ Failed to clean up presence of instance %s: %v", instanceID, err)
+		return
+	}
+
+	for i := 0; i+1 < len(offline); i += 2 {
+		userID, err := strconv.ParseUint(offline[i], 10, 32)
+		if err != nil {
+			continue
+		}
+		p.publish(UserInfo{ID: uint(userID), Username: offline[i+1]}, false)
+	}
+}
+
+// connect records a new connection of a client and announces the user when it
+// is their first connection in the cluster
+func (p *presenceTracker) connect(client *Client) {
+	p.mutex.Lock()
+	p.counts[client.UserID]++
+	p.usernames[client.UserID] = client.Username
+	first := p.counts[client.UserID] == 1
+
+	if p.hub.redisClient != nil {
+		userID := strconv.FormatUint(uint64(client.UserID), 10)
+		total, err := presenceConnectScript.Run(p.hub.ctx, p.hub.redisClient, p.keys(client.UserID),
+			p.instanceID, userID, client.Username).Int()
+		if err != nil {
+			log.Printf("❌ Failed to record presence of user %d: %v", client.UserID, err)
+		}
+		first = err == nil && total == 1
+	}
+	p.mutex.Unlock()
+
+	if first {
+		p.publish(UserInfo{ID: client.UserID, Username: client.Username}, true)
+	}
+}
+
+// disconnect removes a connection of a client and announces the user when it
+// was their last connection in the cluster
+func (p *presenceTracker) disconnect(client *Client) {
+	p.mutex.Lock()
+	p.counts[client.UserID]--
+	last := p.counts[client.UserID] == 0
+	if last {
+		delete(p.counts, client.UserID)
+		delete(p.usernames, client.UserID)
+	}
+
+	if p.hub.redisClient != nil {
+		userID := strconv.FormatUint(uint64(client.UserID), 10)
+		total, err := presenceDisconnectScript.Run(p.hub.ctx, p.hub.redisClient, p.keys(client.UserID),
+			p.instanceID, userID).Int()
+		if err != nil {
+			log.Printf("❌ Failed to record presence of user %d: %v", client.UserID, err)
+		}
+		last = err == nil && total == 0
+	}
+	p.mutex.Unlock()
+
+	if last {
+		p.publish(UserInfo{ID: client.UserID, Username: client.Username}, false)
+	}
+}
+
+// keys returns the Redis keys touched by the connect, disconnect and register scripts
+func (p *presenceTracker) keys(userID uint) []string {
+	return []string{
+		fmt.Sprintf("%s%d", presenceUserKeyPrefix, userID),
+		presenceInstanceUsersKey + p.instanceID,
+		presenceOnlineKey,
+		presenceUsernamesKey,
+	}
+}
+
+// onlineUsers returns every user with at least one connection in the cluster
+func (p *presenceTracker) onlineUsers() ([]UserInfo, error) {
+	ctx := p.hub.ctx
+	ids, err := p.hub.redisClient.SMembers(ctx, presenceOnlineKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]UserInfo, 0, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	usernames, err := p.hub.redisClient.HMGet(ctx, presenceUsernamesKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		userID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			continue
+		}
+		username, _ := usernames[i].(string)
+		users = append(users, UserInfo{ID: uint(userID), Username: username})
+	}
+	return users, nil
+}
+
+// publish announces a user going online or offline. Every client receives
+// presence_changed; the general channel keeps receiving user_joined and
+// user_left, now once per user instead of once per connection.
+func (p *presenceTracker) publish(user UserInfo, online bool) {
+	status := "offline"
+	legacyType := "user_left"
+	text := user.Username + " left the chat"
+	if online {
+		status = "online"
+		legacyType = "user_joined"
+		text = user.Username + " joined the chat"
+	}
+
+	presenceMsg := Message{
+		Type: "presence_changed",
+		Data: map[string]interface{}{
+			"status": status,
+		},
+		UserID: user.ID,
+		User:   user,
+	}
+	if err := p.hub.publish(presenceTopic, presenceMsg); err != nil {
+		log.Printf("❌ Error publishing presence_changed to Redis: %v", err)
+	}
+
+	legacyMsg := Message{
+		Type:    legacyType,
+		Channel: "general",
+		Data: map[string]interface{}{
+			"message": text,
+		},
+		User: user,
+	}
+	if err := p.hub.PublishMessage("general", legacyMsg); err != nil {
+		log.Printf("❌ Error publishing %s to Redis: %v", legacyType, err)
+	}
+}
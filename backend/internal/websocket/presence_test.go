@@ -0,0 +1,164 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"chatapp/internal/broker"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newClusterHubs returns hubs for the given instances sharing an in-process
+// Redis and broker, and a subscription to their presence events
+func newClusterHubs(t *testing.T, instanceIDs ...string) (*miniredis.Miniredis, <-chan broker.Message, []*Hub) {
+	t.Helper()
+
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	events, err := b.PSubscribe(context.Background(), presenceTopic)
+	if err != nil {
+		t.Fatalf("PSubscribe: %v", err)
+	}
+
+	hubs := make([]*Hub, len(instanceIDs))
+	for i, instanceID := range instanceIDs {
+		hubs[i] = NewHub(b, client, nil, nil, nil, nil, instanceID)
+		hubs[i].presence.start()
+	}
+	return srv, events, hubs
+}
+
+// presenceEvents returns the presence changes published so far as "status:user ID"
+func presenceEvents(t *testing.T, events <-chan broker.Message) []string {
+	t.Helper()
+
+	var changes []string
+	for {
+		select {
+		case event := <-events:
+			var msg Message
+			if err := json.Unmarshal(event.Payload, &msg); err != nil {
+				t.Fatalf("failed to decode presence event: %v", err)
+			}
+			status, _ := msg.Data.(map[string]interface{})["status"].(string)
+			changes = append(changes, status+":"+strconv.FormatUint(uint64(msg.UserID), 10))
+		case <-time.After(50 * time.Millisecond):
+			return changes
+		}
+	}
+}
+
+func TestPresenceAcrossInstances(t *testing.T) {
+	srv, events, hubs := newClusterHubs(t, "a", "b")
+	a, b := hubs[0], hubs[1]
+
+	alice := NewClient(a, nil, 1, "alice", "alice@example.com", "s1", nil)
+	aliceOnB := NewClient(b, nil, 1, "alice", "alice@example.com", "s2", nil)
+
+	a.presence.connect(alice)
+	b.presence.connect(aliceOnB)
+	if got := presenceEvents(t, events); !equalStrings(got, []string{"online:1"}) {
+		t.Fatalf("events after connecting twice = %v, want one online", got)
+	}
+	if name := srv.HGet(presenceUsernamesKey, "1"); name != "alice" {
+		t.Errorf("username = %q, want alice", name)
+	}
+
+	a.presence.disconnect(alice)
+	if got := presenceEvents(t, events); got != nil {
+		t.Fatalf("events after closing one of two connections = %v, want none", got)
+	}
+
+	b.presence.disconnect(aliceOnB)
+	if got := presenceEvents(t, events); !equalStrings(got, []string{"offline:1"}) {
+		t.Fatalf("events after the last disconnect = %v, want one offline", got)
+	}
+
+	// Offline users leave nothing behind
+	for _, key := range []string{presenceUserKeyPrefix + "1", presenceOnlineKey, presenceUsernamesKey} {
+		if srv.Exists(key) {
+			t.Errorf("%s still exists after the user went offline", key)
+		}
+	}
+}
+
+func TestPresenceMissedHeartbeat(t *testing.T) {
+	tests := []struct {
+		name         string
+		cleanedUp    bool
+		wantEvents   []string
+		wantUsername string
+	}{
+		{name: "instance cleaned up while away", cleanedUp: true, wantEvents: []string{"offline:1", "online:1"}, wantUsername: "alice"},
+		{name: "instance back before the cleanup", wantEvents: nil, wantUsername: "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, events, hubs := newClusterHubs(t, "a", "b")
+			a, b := hubs[0], hubs[1]
+
+			alice := NewClient(a, nil, 1, "alice", "alice@example.com", "s1", nil)
+			a.presence.connect(alice)
+			a.presence.connect(NewClient(a, nil, 1, "alice", "alice@example.com", "s2", nil))
+			presenceEvents(t, events)
+
+			// Instance a stalls past its TTL
+			srv.FastForward(presenceInstanceTTL + time.Second)
+			if tt.cleanedUp {
+				b.presence.cleanupDeadInstances()
+				if srv.Exists(presenceUsernamesKey) {
+					t.Error("username kept for a user dropped by the cleanup")
+				}
+			}
+			a.presence.heartbeat()
+
+			// A cleanup that comes too late must not drop the connections again
+			b.presence.cleanupInstance("a", false)
+
+			if got := presenceEvents(t, events); !equalStrings(got, tt.wantEvents) {
+				t.Fatalf("events = %v, want %v", got, tt.wantEvents)
+			}
+			if count := srv.HGet(presenceUserKeyPrefix+"1", "a"); count != "2" {
+				t.Errorf("connections of instance a = %q, want 2", count)
+			}
+			if online, _ := srv.SIsMember(presenceOnlineKey, "1"); !online {
+				t.Error("user is not online after the heartbeat")
+			}
+			if name := srv.HGet(presenceUsernamesKey, "1"); name != tt.wantUsername {
+				t.Errorf("username = %q, want %q", name, tt.wantUsername)
+			}
+
+			// Counts match the local connections, so the user still goes offline
+			a.presence.disconnect(alice)
+			a.presence.disconnect(alice)
+			if got := presenceEvents(t, events); !equalStrings(got, []string{"offline:1"}) {
+				t.Fatalf("events after disconnecting = %v, want one offline", got)
+			}
+		})
+	}
+}
+
+func TestStatusActivityExpires(t *testing.T) {
+	srv, _, hubs := newClusterHubs(t, "a")
+
+	hubs[0].status.touch(1, true)
+	key := statusActivityKeyPrefix + "1"
+	if ttl := srv.TTL(key); ttl != statusActivityTTL {
+		t.Fatalf("activity TTL = %v, want %v", ttl, statusActivityTTL)
+	}
+
+	srv.FastForward(statusActivityTTL)
+	if srv.Exists(key) {
+		t.Error("activity of an inactive user was kept")
+	}
+}
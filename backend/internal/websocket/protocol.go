@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"chatapp/internal/service"
+)
+
+// WebSocket protocol
+//
+// Clients send IncomingMessage frames and receive Message frames. A client
+// may set "request_id" on any frame; the server answers it with exactly one
+// "ack" or "error" frame carrying the same request_id. Frames without a
+// request_id are only answered on failure, except chat_message, which is
+// always acknowledged so optimistic messages can be reconciled.
+//
+//	→ {"v":1,"type":"chat_message","request_id":"r1","channel":"general","content":"hi","client_msg_id":"c1"}
+//	← {"type":"ack","request_id":"r1","channel":"general","data":{"client_msg_id":"c1","message_id":42,...}}
+//	← {"type":"error","request_id":"r1","data":{"code":"forbidden","message":"channel is archived"}}
+//
+// The version is negotiated with the Subprotocol; frames may also carry it in
+// "v". Clients using the unversioned "chat" subprotocol get version 1.
+const (
+	// ProtocolVersion is the current version of the WebSocket protocol
+	ProtocolVersion = 1
+
+	// Subprotocol is the WebSocket subprotocol of the current version
+	Subprotocol = "chat.v1"
+
+	// LegacySubprotocol is accepted from clients written before versioning
+	LegacySubprotocol = "chat"
+)
+
+// Frame types answering a request
+const (
+	FrameAck   = "ack"
+	FrameError = "error"
+)
+
+// Machine-readable codes of error frames
+const (
+	// The frame was malformed or referred to something that does not exist
+	CodeValidationFailed = "validation_failed"
+
+	// The client sent too many frames and must slow down
+	CodeRateLimited = "rate_limited"
+
+	// The user may not perform the action
+	CodeForbidden = "forbidden"
+
+	// The server failed; the request may be retried
+	CodeInternal = "internal"
+)
+
+// AckData is the payload of an ack frame. Message fields are only set when
+// a chat message was sent.
+type AckData struct {
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	MessageID   uint   `json:"message_id,omitempty"`
+	CreatedAt   string `json:"created_at,omitempty"`
+	Duplicate   bool   `json:"duplicate,omitempty"`
+}
+
+// ErrorData is the payload of an error frame. RetryAfterMs is set on
+// rate_limited errors.
+type ErrorData struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	ClientMsgID  string `json:"client_msg_id,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+}
+
+// ProtocolError is an error with the code reported to the client
+type ProtocolError struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+// validationError returns a validation_failed error with a formatted message
+func validationError(format string, args ...interface{}) error {
+	return &ProtocolError{Code: CodeValidationFailed, Message: fmt.Sprintf(format, args...)}
+}
+
+// rateLimitedError returns a rate_limited error telling the client when to retry
+func rateLimitedError(retryAfter time.Duration) error {
+	return &ProtocolError{Code: CodeRateLimited, Message: "rate limit exceeded", RetryAfter: retryAfter}
+}
+
+// toProtocolError maps an error from a handler or service to the error
+// reported to the client. Unknown errors are internal; their details are
+// only logged.
+func toProtocolError(err error) *ProtocolError {
+	var protocolErr *ProtocolError
+	if errors.As(err, &protocolErr) {
+		return protocolErr
+	}
+
+	message := err.Error()
+	switch {
+	case strings.HasPrefix(message, "unauthorized:"),
+		strings.HasPrefix(message, "access denied:"),
+		message == "channel is archived",
+		message == "user is not a participant":
+		return &ProtocolError{Code: CodeForbidden, Message: message}
+	case strings.HasSuffix(message, " not found"),
+		message == "cannot reply to a thread reply",
+		message == "cannot start a conversation with yourself",
+		message == "client_msg_id already used for another message":
+		return &ProtocolError{Code: CodeValidationFailed, Message: message}
+	}
+	return &ProtocolError{Code: CodeInternal, Message: "internal error"}
+}
+
+// messageAck returns the ack payload for a sent chat message
+func messageAck(saved *service.MessageResponse, duplicate bool) *AckData {
+	return &AckData{
+		ClientMsgID: saved.ClientMsgID,
+		MessageID:   saved.ID,
+		CreatedAt:   saved.CreatedAt.Format(time.RFC3339),
+		Duplicate:   duplicate,
+	}
+}
+
+// sendAck answers a request that succeeded
+func (c *Client) sendAck(msg IncomingMessage, data *AckData) {
+	frame := Message{
+		Type:      FrameAck,
+		RequestID: msg.RequestID,
+		Channel:   msg.Channel,
+	}
+	if data != nil {
+		frame.Data = data
+	}
+	c.sendMessage(frame)
+}
+
+// sendError answers a request that failed
+func (c *Client) sendError(msg IncomingMessage, err error) {
+	protocolErr := toProtocolError(err)
+	c.sendMessage(Message{
+		Type:      FrameError,
+		RequestID: msg.RequestID,
+		Channel:   msg.Channel,
+		Data: ErrorData{
+			Code:         protocolErr.Code,
+			Message:      protocolErr.Message,
+			ClientMsgID:  msg.ClientMsgID,
+			RetryAfterMs: protocolErr.RetryAfter.Milliseconds(),
+		},
+	})
+}
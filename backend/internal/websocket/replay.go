@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// maxReplayMessages caps how many missed messages are replayed on resume.
+// Clients told that the replay was truncated page the rest over REST.
+const maxReplayMessages = 500
+
+// resumeRequests returns the last seen message IDs sent with the "last_seen"
+// query parameter as comma separated channel:message_id pairs
+func (c *Client) resumeRequests() map[string]uint {
+	lastSeen := make(map[string]uint)
+	if c.ctx == nil {
+		return lastSeen
+	}
+
+	for _, pair := range strings.Split(c.ctx.Query("last_seen"), ",") {
+		channel, id, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || channel == "" {
+			continue
+		}
+		messageID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			continue
+		}
+		lastSeen[channel] = uint(messageID)
+	}
+	return lastSeen
+}
+
+// handleResume replays what the client missed in a channel since the given message
+func (c *Client) handleResume(msg IncomingMessage) error {
+	if msg.Channel == "" {
+		log.Printf("❌ Invalid resume message from client %s: empty channel", c.ID)
+		return validationError("channel is required")
+	}
+
+	return c.resume(msg.Channel, msg.MessageID)
+}
+
+// resume joins the client to a channel and replays the messages after
+// lastSeenID from the database before live delivery continues, along with
+// the older messages edited, deleted or reacted to meanwhile. Live messages
+// arriving during the replay are held back and delivered after it, skipping
+// the ones the replay already contained, so nothing is duplicated or lost.
+func (c *Client) resume(channel string, lastSeenID uint) error {
+	if err := c.hub.messageService.CheckChannelAccess(channel, c.UserID); err != nil {
+		log.Printf("❌ Client %s may not resume channel %s: %v", c.ID, channel, err)
+		return err
+	}
+
+	if !c.hub.beginReplay(c, channel) {
+		return nil
+	}
+
+	missed, err := c.hub.messageService.GetMissedMessages(channel, c.UserID, lastSeenID, maxReplayMessages)
+	if err != nil {
+		log.Printf("❌ Failed to load missed messages of channel %s for client %s: %v", channel, c.ID, err)
+		c.hub.endReplay(c, channel, nil, nil)
+		return err
+	}
+
+	messages := make([]ChatMessage, len(missed.Messages))
+	replayed := make(map[uint]bool, len(missed.Messages))
+	lastID := lastSeenID
+	for i := range missed.Messages {
+		messages[i] = NewChatMessage(&missed.Messages[i])
+		replayed[missed.Messages[i].ID] = true
+		if missed.Messages[i].ID > lastID {
+			lastID = missed.Messages[i].ID
+		}
+	}
+	updated := make([]ChatMessage, len(missed.Updated))
+	for i := range missed.Updated {
+		updated[i] = NewChatMessage(&missed.Updated[i])
+	}
+
+	replay := Message{
+		Type:    "replay",
+		Channel: channel,
+		Data: map[string]interface{}{
+			"messages":        messages,
+			"updated":         updated,
+			"truncated":       missed.Truncated,
+			"last_message_id": lastID,
+		},
+	}
+	data, err := json.Marshal(replay)
+	if err != nil {
+		c.hub.endReplay(c, channel, nil, nil)
+		return err
+	}
+
+	c.hub.endReplay(c, channel, data, replayed)
+	log.Printf("⏪ Replayed %d messages and %d updates of channel %s to client %s", len(missed.Messages), len(missed.Updated), channel, c.ID)
+	return nil
+}
+
+// beginReplay joins a client to a channel and starts holding back the
+// channel's live messages for it. It reports false if the client is gone.
+func (h *Hub) beginReplay(client *Client, channel string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+
+	h.addToChannel(client, channel)
+	if _, ok := client.replaying[channel]; !ok {
+		client.replaying[channel] = [][]byte{}
+	}
+	return true
+}
+
+// endReplay sends the replay frame followed by the live messages held back
+// during the replay, dropping the new messages the replay contained. IDs are
+// not committed in order, so a held back message with a lower ID than the
+// replayed ones may still be missing from it. A nil frame only releases the
+// held back messages.
+func (h *Hub) endReplay(client *Client, channel string, frame []byte, replayed map[uint]bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	held := client.replaying[channel]
+	delete(client.replaying, channel)
+
+	// The client left or was removed from the channel meanwhile
+	if _, ok := h.clients[client]; !ok || !client.channels[channel] {
+		return
+	}
+
+	send := func(message []byte) bool {
+		select {
+		case client.send <- message:
+			return true
+		default:
+			log.Printf("❌ Failed to send replay to client %s, closing connection", client.ID)
+			client.closeSlow()
+			return false
+		}
+	}
+
+	if frame != nil && !send(frame) {
+		return
+	}
+	for _, message := range held {
+		if id := chatMessageID(message); id != 0 && replayed[id] {
+			continue
+		}
+		if !send(message) {
+			return
+		}
+	}
+}
+
+// chatMessageID returns the ID of the message carried by a chat_message or
+// thread_reply frame, or 0 for other frames
+func chatMessageID(frame []byte) uint {
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			ID    uint `json:"id"`
+			Reply struct {
+				ID uint `json:"id"`
+			} `json:"reply"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(frame, &event); err != nil {
+		return 0
+	}
+
+	switch event.Type {
+	case "chat_message":
+		return event.Data.ID
+	case "thread_reply":
+		return event.Data.Reply.ID
+	}
+	return 0
+}
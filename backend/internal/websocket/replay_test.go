@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// chatFrame encodes a live chat_message frame
+func chatFrame(t *testing.T, id uint) []byte {
+	t.Helper()
+	return encode(t, Message{Type: "chat_message", Channel: "general", Data: ChatMessage{ID: id}})
+}
+
+// frameIDs describes the frames queued for a client as type:id strings
+func frameIDs(t *testing.T, client *Client) []string {
+	t.Helper()
+
+	var frames []string
+	for {
+		select {
+		case data := <-client.send:
+			var msg struct {
+				Type string `json:"type"`
+				Data struct {
+					ID uint `json:"id"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("failed to decode queued message: %v", err)
+			}
+			frames = append(frames, msg.Type+":"+strconv.FormatUint(uint64(msg.Data.ID), 10))
+		default:
+			return frames
+		}
+	}
+}
+
+func TestEndReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		// live frames published while the replay was loaded
+		held     []Message
+		replayed []uint
+		leave    bool
+		want     []string
+	}{
+		{
+			name:     "replayed messages are not repeated",
+			held:     []Message{{Type: "chat_message", Data: ChatMessage{ID: 11}}, {Type: "chat_message", Data: ChatMessage{ID: 12}}},
+			replayed: []uint{10, 11},
+			want:     []string{"replay:0", "chat_message:12"},
+		},
+		{
+			name:     "a lower ID committed after the replay query is kept",
+			held:     []Message{{Type: "chat_message", Data: ChatMessage{ID: 9}}, {Type: "chat_message", Data: ChatMessage{ID: 11}}},
+			replayed: []uint{10, 11},
+			want:     []string{"replay:0", "chat_message:9"},
+		},
+		{
+			name:     "edits and reactions are delivered in order",
+			held:     []Message{{Type: "message_edited", Data: ChatMessage{ID: 10}}, {Type: "chat_message", Data: ChatMessage{ID: 12}}, {Type: "reaction_added", Data: ChatMessage{ID: 10}}},
+			replayed: []uint{10},
+			want:     []string{"replay:0", "message_edited:10", "chat_message:12", "reaction_added:10"},
+		},
+		{
+			name:     "client that left the channel gets nothing",
+			held:     []Message{{Type: "chat_message", Data: ChatMessage{ID: 12}}},
+			replayed: []uint{10},
+			leave:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHub(t)
+			client := addTestClient(h, 1, "a")
+			if !h.beginReplay(client, "general") {
+				t.Fatal("beginReplay failed for a registered client")
+			}
+
+			// Live messages are held back while the replay is loaded
+			for _, msg := range tt.held {
+				h.broadcastMessage("general", encode(t, msg))
+			}
+			if got := frameIDs(t, client); len(got) != 0 {
+				t.Fatalf("delivered %v during the replay", got)
+			}
+
+			if tt.leave {
+				h.unsubscribeClient(client, "general")
+			}
+			replayed := make(map[uint]bool)
+			for _, id := range tt.replayed {
+				replayed[id] = true
+			}
+			h.endReplay(client, "general", encode(t, Message{Type: "replay", Channel: "general"}), replayed)
+
+			if got := frameIDs(t, client); !equalStrings(got, tt.want) {
+				t.Errorf("delivered %v, want %v", got, tt.want)
+			}
+
+			// Live delivery continues after the replay
+			if !tt.leave {
+				h.broadcastMessage("general", chatFrame(t, 13))
+				if got := frameIDs(t, client); !equalStrings(got, []string{"chat_message:13"}) {
+					t.Errorf("after the replay delivered %v, want chat_message:13", got)
+				}
+			}
+		})
+	}
+}
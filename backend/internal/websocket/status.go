@@ -0,0 +1,241 @@
+package websocket
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"chatapp/internal/service"
+)
+
+const (
+	// awayAfter is how long a user may be inactive on every connection before
+	// they are shown as away
+	awayAfter = 5 * time.Minute
+
+	// statusCheckInterval is how often idle users and expired statuses are
+	// looked for, and how often activity is written to Redis at most
+	statusCheckInterval = 30 * time.Second
+
+	// statusActivityTTL is how long the last activity of a user is kept in
+	// Redis. A connected user whose activity expired has been inactive for
+	// longer than awayAfter.
+	statusActivityTTL = awayAfter + 2*statusCheckInterval
+)
+
+// Redis keys used by the status tracker
+const (
+	statusActivityKeyPrefix = "status:activity:" // STRING unix time of last activity with TTL
+	statusIdleKey           = "status:idle"      // SET of user IDs marked idle
+)
+
+// statusTracker detects idle users from WebSocket activity and announces
+// status changes. Activity is shared through Redis so a user who is active on
+// one instance is not marked away by another; the idle set makes sure only
+// one instance announces each transition. Without Redis (single-node mode)
+// activity and idle flags are kept in memory.
+type statusTracker struct {
+	hub       *Hub
+	mutex     sync.Mutex
+	lastWrite map[uint]time.Time
+
+	// Activity and idle users in single-node mode
+	activity map[uint]time.Time
+	idle     map[uint]bool
+}
+
+func newStatusTracker(hub *Hub) *statusTracker {
+	return &statusTracker{
+		hub:       hub,
+		lastWrite: make(map[uint]time.Time),
+		activity:  make(map[uint]time.Time),
+		idle:      make(map[uint]bool),
+	}
+}
+
+// run looks for idle users and expired statuses until the process exits
+func (t *statusTracker) run() {
+	ticker := time.NewTicker(statusCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.markIdleUsers()
+		t.expireStatuses()
+	}
+}
+
+// connect records activity for a newly connected client and clears an idle
+// flag left over from the user's previous session
+func (t *statusTracker) connect(client *Client) {
+	t.touch(client.UserID, true)
+	t.setIdle(client.UserID, false)
+}
+
+// touch records activity of a user. Writes to Redis are throttled unless
+// forced; a user marked idle becomes active again.
+func (t *statusTracker) touch(userID uint, force bool) {
+	now := time.Now()
+
+	if t.hub.redisClient == nil {
+		t.mutex.Lock()
+		t.activity[userID] = now
+		wasIdle := t.idle[userID]
+		delete(t.idle, userID)
+		t.mutex.Unlock()
+
+		if wasIdle {
+			t.setIdle(userID, false)
+		}
+		return
+	}
+
+	t.mutex.Lock()
+	if !force && now.Sub(t.lastWrite[userID]) < statusCheckInterval {
+		t.mutex.Unlock()
+		return
+	}
+	t.lastWrite[userID] = now
+	t.mutex.Unlock()
+
+	ctx := t.hub.ctx
+	id := strconv.FormatUint(uint64(userID), 10)
+
+	pipe := t.hub.redisClient.TxPipeline()
+	pipe.Set(ctx, statusActivityKeyPrefix+id, now.Unix(), statusActivityTTL)
+	removed := pipe.SRem(ctx, statusIdleKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("❌ Failed to record activity of user %d: %v", userID, err)
+		return
+	}
+
+	if removed.Val() == 1 {
+		t.setIdle(userID, false)
+	}
+}
+
+// markIdleUsers marks the local users without recent activity as idle
+func (t *statusTracker) markIdleUsers() {
+	if t.hub.redisClient == nil {
+		t.markIdleUsersLocal()
+		return
+	}
+
+	t.hub.mutex.RLock()
+	ids := make([]string, 0, len(t.hub.users))
+	for userID := range t.hub.users {
+		ids = append(ids, strconv.FormatUint(uint64(userID), 10))
+	}
+	t.hub.mutex.RUnlock()
+
+	// 切断済みユーザーのスロットリング情報を捨てる
+	connected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		connected[id] = true
+	}
+	t.mutex.Lock()
+	for userID := range t.lastWrite {
+		if !connected[strconv.FormatUint(uint64(userID), 10)] {
+			delete(t.lastWrite, userID)
+		}
+	}
+	t.mutex.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	ctx := t.hub.ctx
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = statusActivityKeyPrefix + id
+	}
+	activity, err := t.hub.redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Printf("❌ Failed to load user activity: %v", err)
+		return
+	}
+
+	// Expired activity counts as idle
+	cutoff := time.Now().Add(-awayAfter).Unix()
+	for i, id := range ids {
+		if value, ok := activity[i].(string); ok {
+			lastActive, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || lastActive > cutoff {
+				continue
+			}
+		}
+
+		added, err := t.hub.redisClient.SAdd(ctx, statusIdleKey, id).Result()
+		if err != nil {
+			log.Printf("❌ Failed to mark user %s idle: %v", id, err)
+			continue
+		}
+		if added == 1 {
+			userID, _ := strconv.ParseUint(id, 10, 32)
+			t.setIdle(uint(userID), true)
+		}
+	}
+}
+
+// markIdleUsersLocal marks idle users from the in-memory activity in
+// single-node mode
+func (t *statusTracker) markIdleUsersLocal() {
+	t.hub.mutex.RLock()
+	connected := make(map[uint]bool, len(t.hub.users))
+	for userID := range t.hub.users {
+		connected[userID] = true
+	}
+	t.hub.mutex.RUnlock()
+
+	cutoff := time.Now().Add(-awayAfter)
+	var idle []uint
+
+	t.mutex.Lock()
+	for userID, lastActive := range t.activity {
+		if !connected[userID] {
+			delete(t.activity, userID)
+			delete(t.idle, userID)
+			continue
+		}
+		if !t.idle[userID] && lastActive.Before(cutoff) {
+			t.idle[userID] = true
+			idle = append(idle, userID)
+		}
+	}
+	t.mutex.Unlock()
+
+	for _, userID := range idle {
+		t.setIdle(userID, true)
+	}
+}
+
+// expireStatuses resets expired statuses and announces them
+func (t *statusTracker) expireStatuses() {
+	changes, err := t.hub.statusService.ExpireStatuses()
+	if err != nil {
+		log.Printf("❌ Failed to expire statuses: %v", err)
+	}
+	for _, change := range changes {
+		t.publish(change)
+	}
+}
+
+// setIdle stores the idle flag and announces the change if there was one
+func (t *statusTracker) setIdle(userID uint, idle bool) {
+	change, err := t.hub.statusService.SetIdle(userID, idle)
+	if err != nil {
+		log.Printf("❌ Failed to update idle state of user %d: %v", userID, err)
+		return
+	}
+	if change != nil {
+		t.publish(change)
+	}
+}
+
+// publish sends a status change to the user's audience
+func (t *statusTracker) publish(change *service.StatusChange) {
+	if err := t.hub.PublishStatusChange(change); err != nil {
+		log.Printf("❌ Error publishing status_changed: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// typingTimeout is how long a typing indicator stays active without a refresh
+const typingTimeout = 6 * time.Second
+
+// typingTracker keeps the typing state of local clients and stops indicators
+// that were not refreshed or whose client went away. Only typing_start and
+// typing_stop events are published; nothing is persisted.
+type typingTracker struct {
+	hub    *Hub
+	mutex  sync.Mutex
+	timers map[*Client]map[string]*time.Timer
+}
+
+func newTypingTracker(hub *Hub) *typingTracker {
+	return &typingTracker{
+		hub:    hub,
+		timers: make(map[*Client]map[string]*time.Timer),
+	}
+}
+
+// start marks the client as typing in a channel. Repeated starts only extend
+// the expiry; typing_start is published once per typing session.
+func (t *typingTracker) start(client *Client, channel string) {
+	t.mutex.Lock()
+	channels, ok := t.timers[client]
+	if !ok {
+		channels = make(map[string]*time.Timer)
+		t.timers[client] = channels
+	}
+
+	if timer, active := channels[channel]; active {
+		timer.Reset(typingTimeout)
+		t.mutex.Unlock()
+		return
+	}
+
+	channels[channel] = time.AfterFunc(typingTimeout, func() {
+		t.stop(client, channel)
+	})
+	t.mutex.Unlock()
+
+	t.publish("typing_start", client, channel)
+}
+
+// stop clears the client's typing state in a channel and publishes typing_stop
+func (t *typingTracker) stop(client *Client, channel string) {
+	t.mutex.Lock()
+	timer, active := t.timers[client][channel]
+	if active {
+		timer.Stop()
+		delete(t.timers[client], channel)
+		if len(t.timers[client]) == 0 {
+			delete(t.timers, client)
+		}
+	}
+	t.mutex.Unlock()
+
+	if active {
+		t.publish("typing_stop", client, channel)
+	}
+}
+
+// clear stops every typing indicator of a client, used when it disconnects
+func (t *typingTracker) clear(client *Client) {
+	t.mutex.Lock()
+	channels := make([]string, 0, len(t.timers[client]))
+	for channel := range t.timers[client] {
+		channels = append(channels, channel)
+	}
+	t.mutex.Unlock()
+
+	for _, channel := range channels {
+		t.stop(client, channel)
+	}
+}
+
+// publish sends a typing event to the channel on every instance. Hubs skip
+// the typing user's own clients when delivering it.
+func (t *typingTracker) publish(eventType string, client *Client, channel string) {
+	msg := Message{
+		Type:    eventType,
+		Channel: channel,
+		UserID:  client.UserID,
+		User: UserInfo{
+			ID:       client.UserID,
+			Username: client.Username,
+		},
+	}
+	if eventType == "typing_start" {
+		msg.Data = map[string]interface{}{
+			"expires_in": int(typingTimeout.Seconds()),
+		}
+	}
+
+	if err := t.hub.PublishMessage(channel, msg); err != nil {
+		log.Printf("❌ Error publishing %s to Redis: %v", eventType, err)
+	}
+}
@@ -3,6 +3,7 @@
 import { useEffect, useState } from 'react';
 import { useRouter } from 'next/navigation';
 import { useAuthStore } from '@/store/authStore';
+import { logout } from '@/lib/api';
 
 export default function ChannelsPage() {
   const { isAuthenticated, user, initializeAuth } = useAuthStore();
@@ -44,8 +45,8 @@ export default function ChannelsPage() {
             <div className="flex items-center space-x-4">
               <span className="text-sm text-gray-500">Welcome, {user?.username}</span>
               <button
-                onClick={() => {
-                  useAuthStore.getState().clearAuth();
+                onClick={async () => {
+                  await logout();
                   router.push('/login');
                 }}
                 className="bg-red-600 hover:bg-red-700 text-white px-4 py-2 rounded-md text-sm font-medium"
@@ -3,6 +3,7 @@
 import { useState, useEffect } from 'react';
 import { useRouter } from 'next/navigation';
 import { useAuthStore } from '@/store/authStore';
+import { logout } from '@/lib/api';
 import { useChatStore } from '@/store/chatStore';
 import { MessageInput } from '@/components/MessageInput';
 import { MessageList } from '@/components/MessageList';
@@ -130,8 +131,8 @@ export default function GeneralChatPage() {
             <div className="flex items-center space-x-4">
               <span className="text-sm text-gray-500">Welcome, {user?.username}</span>
               <button
-                onClick={() => {
-                  useAuthStore.getState().clearAuth();
+                onClick={async () => {
+                  await logout();
                   router.push('/login');
                 }}
                 className="bg-red-600 hover:bg-red-700 text-white px-3 py-1 rounded text-sm"
@@ -38,7 +38,7 @@ export default function LoginPage() {
         });
       }
 
-      setAuth(response.user, response.token);
+      setAuth(response.user, response.token, response.refresh_token);
       router.push('/channels');
     } catch (err: unknown) {
       const error = err as { response?: { data?: { error?: string } } };
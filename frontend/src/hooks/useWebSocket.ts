@@ -1,5 +1,5 @@
 import { useRef, useCallback } from 'react';
-import { useAuthStore } from '@/store/authStore';
+import { getAccessToken } from '@/lib/api';
 
 interface UseWebSocketOptions {
   onMessage?: (data: unknown) => void;
@@ -10,10 +10,11 @@ interface UseWebSocketOptions {
 
 export function useWebSocket(channel: string, options: UseWebSocketOptions = {}) {
   const wsRef = useRef<WebSocket | null>(null);
-  const { token } = useAuthStore();
   const { onMessage, onConnect, onDisconnect, onError } = options;
 
-  const connect = useCallback(() => {
+  const connect = useCallback(async () => {
+    // Refreshes the short-lived access token first when it expired
+    const token = await getAccessToken();
     if (!token) {
       console.error('No auth token available');
       return;
@@ -62,7 +63,7 @@ export function useWebSocket(channel: string, options: UseWebSocketOptions = {})
     } catch (error) {
       console.error('Failed to create WebSocket connection:', error);
     }
-  }, [token, channel, onMessage, onConnect, onDisconnect, onError]);
+  }, [channel, onMessage, onConnect, onDisconnect, onError]);
 
   const disconnect = useCallback(() => {
     if (wsRef.current) {
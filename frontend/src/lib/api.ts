@@ -1,6 +1,7 @@
 import axios from 'axios';
 import { LoginRequest, SignupRequest, AuthResponse, ApiResponse } from '@/types/auth';
 import { Message, SendMessageRequest, GetMessagesResponse } from '@/types/message';
+import { useAuthStore } from '@/store/authStore';
 
 const API_BASE_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080/api';
 
@@ -20,12 +21,97 @@ api.interceptors.request.use((config) => {
   return config;
 });
 
-// Response interceptor for error handling
+// Refresh request in flight, shared so concurrent callers rotate the token once
+let refreshing: Promise<string> | null = null;
+
+const rotateTokens = async (): Promise<string> => {
+  const staleRefreshToken = localStorage.getItem('refresh_token');
+
+  const rotate = async (): Promise<string> => {
+    // Another tab may have rotated the tokens while this one waited for the lock
+    const refreshToken = localStorage.getItem('refresh_token');
+    const token = localStorage.getItem('auth_token');
+    if (refreshToken && refreshToken !== staleRefreshToken && token && !expiresSoon(token)) {
+      useAuthStore.setState({ token });
+      return token;
+    }
+    if (!refreshToken) {
+      throw new Error('No refresh token');
+    }
+
+    const response = await axios.post<ApiResponse<AuthResponse>>(`${API_BASE_URL}/auth/refresh`, {
+      refresh_token: refreshToken,
+    });
+    const data = response.data.data!;
+    localStorage.setItem('auth_token', data.token);
+    localStorage.setItem('refresh_token', data.refresh_token);
+    useAuthStore.setState({ token: data.token });
+    return data.token;
+  };
+
+  // Tabs share the refresh token, so only one of them may rotate it at a time
+  if (typeof navigator !== 'undefined' && navigator.locks) {
+    return navigator.locks.request('auth-token-refresh', rotate);
+  }
+  return rotate();
+};
+
+// Exchanges the refresh token for a new access token, once for all callers
+export const refreshAccessToken = (): Promise<string> => {
+  refreshing = refreshing || rotateTokens().finally(() => {
+    refreshing = null;
+  });
+  return refreshing;
+};
+
+// Access tokens this close to expiry are refreshed before use
+const TOKEN_EXPIRY_MARGIN_MS = 30 * 1000;
+
+const expiresSoon = (token: string): boolean => {
+  try {
+    const payload = JSON.parse(atob(token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/')));
+    return !payload.exp || payload.exp * 1000 - Date.now() < TOKEN_EXPIRY_MARGIN_MS;
+  } catch {
+    return true;
+  }
+};
+
+// Returns a usable access token for connections that bypass the axios
+// interceptor, such as the WebSocket, refreshing it first when it expired
+export const getAccessToken = async (): Promise<string | null> => {
+  const token = localStorage.getItem('auth_token');
+  if (token && !expiresSoon(token)) {
+    return token;
+  }
+
+  try {
+    return await refreshAccessToken();
+  } catch (error) {
+    console.error('Failed to refresh access token:', error);
+    return null;
+  }
+};
+
+// Response interceptor for error handling: an expired access token is
+// refreshed once, then the request is retried
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const original = error.config;
+    if (error.response?.status === 401 && original && !original._retry) {
+      original._retry = true;
+      try {
+        const token = await refreshAccessToken();
+        original.headers.Authorization = `Bearer ${token}`;
+        return api(original);
+      } catch {
+        // fall through to logout
+      }
+    }
+
     if (error.response?.status === 401) {
       localStorage.removeItem('auth_token');
+      localStorage.removeItem('refresh_token');
       window.location.href = '/login';
     }
     return Promise.reject(error);
@@ -48,17 +134,38 @@ export const authApi = {
     return response.data.data!;
   },
 
-  refreshToken: async (): Promise<AuthResponse> => {
-    const response = await api.post<ApiResponse<AuthResponse>>('/auth/refresh');
+  refreshToken: async (refreshToken: string): Promise<AuthResponse> => {
+    const response = await api.post<ApiResponse<AuthResponse>>('/auth/refresh', {
+      refresh_token: refreshToken,
+    });
     return response.data.data!;
   },
+
+  logout: async (refreshToken: string, all = false): Promise<void> => {
+    await api.post('/auth/logout', { refresh_token: refreshToken, all });
+  },
+};
+
+// Revokes the session's refresh token on the server, then forgets the tokens
+export const logout = async (): Promise<void> => {
+  const refreshToken = localStorage.getItem('refresh_token');
+  if (refreshToken) {
+    try {
+      await authApi.logout(refreshToken);
+    } catch (error) {
+      console.error('Failed to revoke refresh token:', error);
+    }
+  }
+  useAuthStore.getState().clearAuth();
 };
 
 export const messageApi = {
-  getMessages: async (channel: string, page = 1, limit = 50): Promise<Message[]> => {
-    const response = await api.get<ApiResponse<GetMessagesResponse>>(
-      `/messages?channel=${channel}&page=${page}&limit=${limit}`
-    );
+  getMessages: async (channel: string, limit = 50, cursor?: string): Promise<Message[]> => {
+    const params = new URLSearchParams({ channel, limit: String(limit) });
+    if (cursor) {
+      params.set('cursor', cursor);
+    }
+    const response = await api.get<ApiResponse<GetMessagesResponse>>(`/messages?${params}`);
     return response.data.data?.messages || [];
   },
 
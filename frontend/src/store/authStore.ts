@@ -7,7 +7,7 @@ interface AuthState {
   token: string | null;
   isAuthenticated: boolean;
   isLoading: boolean;
-  setAuth: (user: User, token: string) => void;
+  setAuth: (user: User, token: string, refreshToken?: string) => void;
   clearAuth: () => void;
   setLoading: (loading: boolean) => void;
   initializeAuth: () => void;
@@ -20,12 +20,16 @@ export const useAuthStore = create<AuthState>()(
       token: null,
       isAuthenticated: false,
       isLoading: false,
-      setAuth: (user, token) => {
+      setAuth: (user, token, refreshToken) => {
         localStorage.setItem('auth_token', token);
+        if (refreshToken) {
+          localStorage.setItem('refresh_token', refreshToken);
+        }
         set({ user, token, isAuthenticated: true });
       },
       clearAuth: () => {
         localStorage.removeItem('auth_token');
+        localStorage.removeItem('refresh_token');
         set({ user: null, token: null, isAuthenticated: false });
       },
       setLoading: (loading) => set({ isLoading: loading }),
@@ -1,6 +1,6 @@
 import { create } from 'zustand';
 import { Message } from '@/types/message';
-import { messageApi } from '@/lib/api';
+import { getAccessToken, messageApi } from '@/lib/api';
 
 interface ChatState {
   messages: Message[];
@@ -9,7 +9,7 @@ interface ChatState {
   ws: WebSocket | null;
   
   // Actions
-  connect: (channel: string) => void;
+  connect: (channel: string) => Promise<void>;
   disconnect: () => void;
   sendMessage: (channel: string, content: string) => Promise<void>;
   addMessage: (message: Message) => void;
@@ -23,7 +23,7 @@ export const useChatStore = create<ChatState>((set, get) => ({
   currentChannel: null,
   ws: null,
 
-  connect: (channel: string) => {
+  connect: async (channel: string) => {
     const { ws: existingWs, disconnect } = get();
     
     // 既存の接続を完全にクリーンアップ
@@ -37,51 +37,10 @@ export const useChatStore = create<ChatState>((set, get) => ({
       return;
     }
 
-    const token = localStorage.getItem('auth_token');
-    console.log('🔍 Debug: Token check:', {
-      tokenExists: !!token,
-      tokenLength: token?.length || 0,
-      tokenPreview: token ? `${token.substring(0, 20)}...` : 'null'
-    });
-    
+    // アクセストークンは15分で切れるため、期限切れなら接続前にリフレッシュする
+    const token = await getAccessToken();
     if (!token) {
-      console.error('❌ No auth token found in localStorage');
-      return;
-    }
-
-    // トークンの形式を確認（JWT形式かどうか）
-    const tokenParts = token.split('.');
-    if (tokenParts.length !== 3) {
-      console.error('❌ Invalid JWT token format - expected 3 parts, got', tokenParts.length);
-      return;
-    }
-
-    // JWTペイロードをデコードして有効期限をチェック
-    try {
-      const payload = JSON.parse(atob(tokenParts[1]));
-      const currentTime = Math.floor(Date.now() / 1000);
-      
-      console.log('🔍 Token payload check:', {
-        exp: payload.exp,
-        currentTime,
-        isExpired: payload.exp && payload.exp < currentTime,
-        timeUntilExpiry: payload.exp ? payload.exp - currentTime : 'unknown',
-        fullPayload: payload,
-        userId: payload.user_id,
-        username: payload.username,
-        email: payload.email,
-        issuer: payload.iss,
-        subject: payload.sub
-      });
-      
-      if (payload.exp && payload.exp < currentTime) {
-        console.error('❌ Token has expired');
-        // 期限切れトークンを削除
-        localStorage.removeItem('auth_token');
-        return;
-      }
-    } catch (error) {
-      console.error('❌ Failed to decode token payload:', error);
+      console.error('❌ No valid auth token available');
       return;
     }
 
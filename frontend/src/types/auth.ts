@@ -20,6 +20,8 @@ export interface SignupRequest {
 export interface AuthResponse {
   user: User;
   token: string;
+  expires_at: string;
+  refresh_token: string;
 }
 
 export interface ApiResponse<T> {
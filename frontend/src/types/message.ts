@@ -17,7 +17,10 @@ export interface SendMessageRequest {
 
 export interface GetMessagesResponse {
   messages: Message[];
-  total: number;
-  page: number;
+  total?: number;
   limit: number;
+  has_more: boolean;
+  has_newer: boolean;
+  next_cursor?: string;
+  prev_cursor?: string;
 }
\ No newline at end of file